@@ -0,0 +1,91 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/railanbaigazy/chirpy/internal/database"
+)
+
+func (cfg *apiConfig) bookmarkChirpHandler(w http.ResponseWriter, r *http.Request) {
+	cfg.setBookmark(w, r, cfg.db.BookmarkChirp)
+}
+
+func (cfg *apiConfig) unbookmarkChirpHandler(w http.ResponseWriter, r *http.Request) {
+	cfg.setBookmark(w, r, cfg.db.UnbookmarkChirp)
+}
+
+func (cfg *apiConfig) setBookmark(w http.ResponseWriter, r *http.Request, update func(chirpID int, userID int) error) {
+	w.Header().Set("Content-Type", "application/json")
+
+	tokenStr, err := getTokenString(r)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, err.Error())
+		return
+	}
+
+	userID, err := getUserIDByToken(cfg, tokenStr)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, err.Error())
+		return
+	}
+
+	chirpID, err := strconv.Atoi(r.PathValue("chirpid"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "invalid id")
+		return
+	}
+
+	err = update(chirpID, userID)
+	if errors.Is(err, database.ErrChirpNotFound) {
+		respondWithError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (cfg *apiConfig) getBookmarksHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	tokenStr, err := getTokenString(r)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, err.Error())
+		return
+	}
+
+	userID, err := getUserIDByToken(cfg, tokenStr)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, err.Error())
+		return
+	}
+
+	query, err := parseChirpQuery(r)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if r.URL.Query().Get("sort") == "" {
+		query.Descending = true
+	}
+
+	page, err := cfg.db.GetBookmarks(userID, query)
+	if errors.Is(err, database.ErrInvalidCursor) {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	if err = cfg.markLikedByMe(userID, page.Chirps); err != nil {
+		respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	respondWithChirpPage(w, page)
+}
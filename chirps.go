@@ -2,18 +2,29 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
-	"sort"
 	"strconv"
 	"strings"
+
+	"github.com/railanbaigazy/chirpy/internal/database"
+	"github.com/railanbaigazy/chirpy/internal/moderation"
+	"github.com/railanbaigazy/chirpy/internal/textlen"
 )
 
 type chirpRequest struct {
-	Body string `json:"body"`
+	Body       string `json:"body"`
+	ParentID   int    `json:"parent_id"`
+	Kind       string `json:"kind"`
+	OriginalID int    `json:"original_id"`
+	MediaIDs   []int  `json:"media_ids"`
 }
 
-var profanes []string = []string{"kerfuffle", "sharbert", "fornax"}
+const (
+	defaultThreadDepth = 10
+	maxThreadDepth     = 50
+)
 
 func (cfg *apiConfig) createChirpHandler(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
@@ -27,6 +38,11 @@ func (cfg *apiConfig) createChirpHandler(w http.ResponseWriter, r *http.Request)
 	userID, err := getUserIDByToken(cfg, tokenStr)
 	if err != nil {
 		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if !cfg.checkEmailVerified(w, userID) {
+		return
 	}
 
 	chirpReq := chirpRequest{}
@@ -36,59 +52,222 @@ func (cfg *apiConfig) createChirpHandler(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	if len(chirpReq.Body) > 140 {
-		respondWithError(w, http.StatusBadRequest, "chirp is too long")
-		return
+	moderated := moderation.Result{Text: chirpReq.Body}
+	if chirpReq.Kind != database.ChirpKindRechirp {
+		var ok bool
+		if moderated, ok = cfg.validateChirp(w, userID, chirpReq.Body); !ok {
+			return
+		}
 	}
 
-	cleanedBody := cleanText(chirpReq.Body)
-	chirp, err := cfg.db.CreateChirp(cleanedBody, userID)
+	chirp, err := cfg.db.CreateChirp(database.ChirpParams{
+		Body:       moderated.Text,
+		AuthorID:   userID,
+		ParentID:   chirpReq.ParentID,
+		Kind:       chirpReq.Kind,
+		OriginalID: chirpReq.OriginalID,
+		MediaIDs:   chirpReq.MediaIDs,
+		Flagged:    moderated.Flagged,
+	})
+	if errors.Is(err, database.ErrAlreadyRechirped) {
+		respondWithError(w, http.StatusConflict, err.Error())
+		return
+	}
 	if err != nil {
 		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
 	}
 	respondWithJSON(w, 201, chirp)
 }
 
-func cleanText(body string) string {
-	words := strings.Fields(body)
-	cleanedBody := []string{}
-	for _, word := range words {
-		isProfane := false
-		for _, profane := range profanes {
-			if strings.ToLower(word) == profane {
-				cleanedBody = append(cleanedBody, "****")
-				isProfane = true
-				break
-			}
-		}
-		if !isProfane {
-			cleanedBody = append(cleanedBody, word)
-		}
+func (cfg *apiConfig) validateChirp(w http.ResponseWriter, userID int, body string) (moderation.Result, bool) {
+	user, err := cfg.db.GetUserByID(userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, err.Error())
+		return moderation.Result{}, false
+	}
+	result, err := cfg.checkChirp(body, user)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return moderation.Result{}, false
+	}
+	return result, true
+}
+
+// checkChirp checks body against the length limit for author and the
+// content policy, and returns it as moderated.
+func (cfg *apiConfig) checkChirp(body string, author database.UserResp) (moderation.Result, error) {
+	if length, limit := textlen.Weighted(body, cfg.chirpLimits.URLLength), cfg.chirpLimits.forUser(author); length > limit {
+		return moderation.Result{}, fmt.Errorf("chirp is too long: %d characters, the limit is %d", length, limit)
+	}
+	result := cfg.moderator.Moderate(body)
+	if result.Rejected {
+		return moderation.Result{}, errors.New("chirp violates the content policy")
+	}
+	return result, nil
+}
+
+// reviewDraft is the database.DraftReview drafts are published with, so
+// they are held to the same rules as posting a chirp when they go out: the
+// email verification requirement, the limits and the content policy.
+func (cfg *apiConfig) reviewDraft(draft database.Draft, author database.AccountResp) (string, bool, error) {
+	if cfg.requireVerifiedEmail && !author.EmailVerified {
+		return "", false, errEmailNotVerified
+	}
+	result, err := cfg.checkChirp(draft.Body, author.UserResp)
+	if err != nil {
+		return "", false, err
 	}
-	return strings.Join(cleanedBody, " ")
+	return result.Text, result.Flagged, nil
 }
 
 func (cfg *apiConfig) getChirpsHandler(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
-	authorID := r.URL.Query().Get("author_id")
-	sortType := r.URL.Query().Get("sort")
-	if sortType == "" {
-		sortType = "asc"
+
+	viewerID, err := getViewerID(cfg, r)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, err.Error())
+		return
 	}
 
-	chirps, err := cfg.db.GetChirps(authorID)
+	query, err := parseChirpQuery(r)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	page, err := cfg.db.GetChirps(query)
 	if err != nil {
 		respondWithError(w, http.StatusBadRequest, fmt.Sprint(err))
 		return
 	}
 
-	if sortType == "asc" {
-		sort.Slice(chirps, func(i, j int) bool { return chirps[i].ID < chirps[j].ID })
-	} else if sortType == "desc" {
-		sort.Slice(chirps, func(i, j int) bool { return chirps[i].ID > chirps[j].ID })
+	if err = cfg.markLikedByMe(viewerID, page.Chirps); err != nil {
+		respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	respondWithChirpPage(w, page)
+}
+
+// getFlaggedChirpsHandler lists the chirps moderation has flagged for
+// review. It is for admins only.
+func (cfg *apiConfig) getFlaggedChirpsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if !cfg.checkAdmin(w, r) {
+		return
+	}
+
+	query, err := parseChirpQuery(r)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	query.Flagged = true
+
+	page, err := cfg.db.GetChirps(query)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
 	}
 
-	respondWithJSON(w, http.StatusOK, chirps)
+	respondWithChirpPage(w, page)
+}
+
+func (cfg *apiConfig) getHashtagChirpsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	viewerID, err := getViewerID(cfg, r)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, err.Error())
+		return
+	}
+
+	query, err := parseChirpQuery(r)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	query.Hashtag = strings.TrimPrefix(r.PathValue("tag"), "#")
+
+	page, err := cfg.db.GetChirps(query)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if err = cfg.markLikedByMe(viewerID, page.Chirps); err != nil {
+		respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	respondWithChirpPage(w, page)
+}
+
+func (cfg *apiConfig) getUserMentionsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	userID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil || userID <= 0 {
+		respondWithError(w, http.StatusBadRequest, "invalid id")
+		return
+	}
+
+	viewerID, err := getViewerID(cfg, r)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, err.Error())
+		return
+	}
+
+	query, err := parseChirpQuery(r)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	query.MentionedUserID = userID
+
+	page, err := cfg.db.GetChirps(query)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if err = cfg.markLikedByMe(viewerID, page.Chirps); err != nil {
+		respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	respondWithChirpPage(w, page)
+}
+
+func (cfg *apiConfig) searchChirpsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	viewerID, err := getViewerID(cfg, r)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, err.Error())
+		return
+	}
+
+	query, err := parseChirpQuery(r)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	page, err := cfg.db.SearchChirps(r.URL.Query().Get("q"), query)
+	if errors.Is(err, database.ErrEmptySearch) || errors.Is(err, database.ErrInvalidCursor) {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	if err = cfg.markLikedByMe(viewerID, page.Chirps); err != nil {
+		respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	respondWithChirpPage(w, page)
 }
 
 func (cfg *apiConfig) getChirpByIDHandler(w http.ResponseWriter, r *http.Request) {
@@ -98,12 +277,22 @@ func (cfg *apiConfig) getChirpByIDHandler(w http.ResponseWriter, r *http.Request
 		respondWithError(w, http.StatusBadRequest, "invalid id")
 		return
 	}
+	viewerID, err := getViewerID(cfg, r)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, err.Error())
+		return
+	}
 	chirp, err := cfg.db.GetChirpByID(id)
 	if err != nil {
 		respondWithError(w, 404, fmt.Sprint(err))
 		return
 	}
-	respondWithJSON(w, http.StatusOK, chirp)
+	chirps := []database.Chirp{chirp}
+	if err = cfg.markLikedByMe(viewerID, chirps); err != nil {
+		respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	respondWithJSON(w, http.StatusOK, chirps[0])
 }
 
 func (cfg *apiConfig) deleteChirpHandler(w http.ResponseWriter, r *http.Request) {
@@ -116,6 +305,7 @@ func (cfg *apiConfig) deleteChirpHandler(w http.ResponseWriter, r *http.Request)
 	userID, err := getUserIDByToken(cfg, tokenStr)
 	if err != nil {
 		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
 	}
 
 	chirpID, err := strconv.Atoi(r.PathValue("chirpid"))
@@ -130,3 +320,119 @@ func (cfg *apiConfig) deleteChirpHandler(w http.ResponseWriter, r *http.Request)
 	}
 	w.WriteHeader(204)
 }
+
+func (cfg *apiConfig) updateChirpHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	tokenStr, err := getTokenString(r)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, err.Error())
+		return
+	}
+
+	userID, err := getUserIDByToken(cfg, tokenStr)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, err.Error())
+		return
+	}
+
+	chirpID, err := strconv.Atoi(r.PathValue("chirpid"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "invalid id")
+		return
+	}
+
+	chirpReq := chirpRequest{}
+	err = json.NewDecoder(r.Body).Decode(&chirpReq)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+
+	moderated, ok := cfg.validateChirp(w, userID, chirpReq.Body)
+	if !ok {
+		return
+	}
+
+	chirp, err := cfg.db.UpdateChirp(chirpID, userID, moderated.Text, moderated.Flagged, cfg.chirpEditWindow)
+	if errors.Is(err, database.ErrChirpNotFound) {
+		respondWithError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	if errors.Is(err, database.ErrAccessDenied) || errors.Is(err, database.ErrEditWindowExpired) {
+		respondWithError(w, http.StatusForbidden, err.Error())
+		return
+	}
+	if errors.Is(err, database.ErrRechirpNotEditable) {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	chirps := []database.Chirp{chirp}
+	if err = cfg.markLikedByMe(userID, chirps); err != nil {
+		respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	respondWithJSON(w, http.StatusOK, chirps[0])
+}
+
+func (cfg *apiConfig) getChirpHistoryHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	id, err := strconv.Atoi(r.PathValue("chirpid"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "invalid id")
+		return
+	}
+	revisions, err := cfg.db.GetChirpHistory(id)
+	if err != nil {
+		respondWithError(w, 404, err.Error())
+		return
+	}
+	respondWithJSON(w, http.StatusOK, revisions)
+}
+
+func (cfg *apiConfig) getChirpThreadHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	id, err := strconv.Atoi(r.PathValue("chirpid"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "invalid id")
+		return
+	}
+
+	depth := defaultThreadDepth
+	if r.URL.Query().Has("depth") {
+		depth, err = intParam(r.URL.Query(), "depth")
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+	}
+	if depth > maxThreadDepth {
+		respondWithError(w, http.StatusBadRequest, fmt.Sprintf("depth must be at most %d", maxThreadDepth))
+		return
+	}
+
+	viewerID, err := getViewerID(cfg, r)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, err.Error())
+		return
+	}
+
+	thread, err := cfg.db.GetThread(id, depth)
+	if errors.Is(err, database.ErrChirpNotFound) {
+		respondWithError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if err = cfg.markThreadLikedByMe(viewerID, &thread); err != nil {
+		respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	respondWithJSON(w, http.StatusOK, thread)
+}
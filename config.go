@@ -1,39 +1,336 @@
 package main
 
 import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
 	"flag"
 	"fmt"
 	"log"
 	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/railanbaigazy/chirpy/internal/database"
+	"github.com/railanbaigazy/chirpy/internal/jwtkeys"
+	"github.com/railanbaigazy/chirpy/internal/mailer"
+	"github.com/railanbaigazy/chirpy/internal/media"
+	"github.com/railanbaigazy/chirpy/internal/moderation"
 )
 
 type apiConfig struct {
-	fileserverHits int
-	db             *database.DB
-	jwtSecret      string
+	fileserverHits    int
+	db                database.Store
+	jwtKeys           *jwtkeys.KeySet
+	jwtAlgorithm      string
+	jwtKeyRotation    time.Duration
+	chirpEditWindow   time.Duration
+	media             *media.Store
+	moderator         *moderation.Moderator
+	chirpLimits       chirpLimits
+	schedulerInterval time.Duration
+
+	mailer               mailer.Mailer
+	appURL               string
+	requireVerifiedEmail bool
+
+	// mailsByIP and mailsByIPAndEmail throttle the endpoints that send mail,
+	// per client and per client and recipient.
+	mailsByIP         *throttle
+	mailsByIPAndEmail *throttle
+
+	// adminKey is the API key admin endpoints require. They are disabled
+	// when it is empty.
+	adminKey string
 }
 
 func startDB() (apiConfig, error) {
-	const filepathDB = "database.json"
+	driver := os.Getenv("DB_DRIVER")
+	if driver == "" {
+		driver = "json"
+	}
+
+	filepathDB := os.Getenv("DB_PATH")
+	if filepathDB == "" {
+		filepathDB = "database." + driver
+	}
+
+	flushInterval, err := durationFromEnv("DB_FLUSH_INTERVAL", 0)
+	if err != nil {
+		return apiConfig{}, err
+	}
+
+	chirpEditWindow, err := durationFromEnv("CHIRP_EDIT_WINDOW", 15*time.Minute)
+	if err != nil {
+		return apiConfig{}, err
+	}
+
+	schedulerInterval, err := durationFromEnv("SCHEDULER_INTERVAL", 10*time.Second)
+	if err != nil {
+		return apiConfig{}, err
+	}
+	if schedulerInterval <= 0 {
+		return apiConfig{}, fmt.Errorf("invalid SCHEDULER_INTERVAL: must be positive")
+	}
+
+	limits := chirpLimits{MaxMedia: database.MaxChirpMedia}
+	if limits.MaxLength, err = intFromEnv("CHIRP_MAX_LENGTH", 140); err != nil {
+		return apiConfig{}, err
+	}
+	if limits.ChirpyRedMaxLength, err = intFromEnv("CHIRP_MAX_LENGTH_CHIRPY_RED", 280); err != nil {
+		return apiConfig{}, err
+	}
+	if limits.URLLength, err = intFromEnv("CHIRP_URL_LENGTH", 23); err != nil {
+		return apiConfig{}, err
+	}
+
+	mediaDir := os.Getenv("MEDIA_DIR")
+	if mediaDir == "" {
+		mediaDir = "media"
+	}
+
+	if limits.MaxMediaBytes, err = intFromEnv("MEDIA_MAX_BYTES", 5<<20); err != nil {
+		return apiConfig{}, err
+	}
+
+	moderationConfig := moderation.DefaultConfig()
+	if path := os.Getenv("MODERATION_CONFIG"); path != "" {
+		if moderationConfig, err = moderation.LoadConfig(path); err != nil {
+			return apiConfig{}, fmt.Errorf("invalid MODERATION_CONFIG: %v", err)
+		}
+	}
+	moderator, err := moderation.New(moderationConfig)
+	if err != nil {
+		return apiConfig{}, fmt.Errorf("invalid MODERATION_CONFIG: %v", err)
+	}
+
+	jwtKeysDir := os.Getenv("JWT_KEYS_DIR")
+	if jwtKeysDir == "" {
+		jwtKeysDir = "jwt-keys"
+	}
+
+	jwtAlgorithm := os.Getenv("JWT_ALGORITHM")
+	if jwtAlgorithm == "" {
+		jwtAlgorithm = jwtkeys.ES256
+	}
+	if !slices.Contains(jwtkeys.Algorithms, jwtAlgorithm) {
+		return apiConfig{}, fmt.Errorf("invalid JWT_ALGORITHM: must be one of %v", jwtkeys.Algorithms)
+	}
+
+	jwtKeyRotation, err := durationFromEnv("JWT_KEY_ROTATION", 0)
+	if err != nil {
+		return apiConfig{}, err
+	}
+	if jwtKeyRotation < 0 {
+		return apiConfig{}, fmt.Errorf("invalid JWT_KEY_ROTATION: must not be negative")
+	}
+
+	refreshTokenKeyFile := os.Getenv("REFRESH_TOKEN_KEY_FILE")
+	if refreshTokenKeyFile == "" {
+		refreshTokenKeyFile = "refresh-token.key"
+	}
+
+	mailFrom := os.Getenv("MAIL_FROM")
+	if mailFrom == "" {
+		mailFrom = "no-reply@chirpy.local"
+	}
+	mail, err := newMailer(os.Getenv("MAILER"), mailFrom)
+	if err != nil {
+		return apiConfig{}, err
+	}
+
+	appURL := strings.TrimSuffix(os.Getenv("APP_URL"), "/")
+	if appURL == "" {
+		appURL = "http://localhost:8080"
+	}
+
+	requireVerifiedEmail, err := boolFromEnv("REQUIRE_VERIFIED_EMAIL", false)
+	if err != nil {
+		return apiConfig{}, err
+	}
+
+	timelineMode := database.FanOutOnRead
+	if value := os.Getenv("TIMELINE_FANOUT"); value != "" {
+		if timelineMode, err = database.ParseTimelineMode(value); err != nil {
+			return apiConfig{}, fmt.Errorf("invalid TIMELINE_FANOUT: %v", err)
+		}
+	}
 
 	isDebug := flag.Bool("debug", false, "Enable debug mode")
+	isMigrateDryRun := flag.Bool("migrate-dry-run", false, "List pending database migrations and exit")
 	flag.Parse()
 
+	if *isMigrateDryRun {
+		pending, err := dryRunMigrations(driver, filepathDB)
+		if err != nil {
+			return apiConfig{}, fmt.Errorf("migration dry run failed: %v", err)
+		}
+		if len(pending) == 0 {
+			log.Print("Database schema is up to date")
+		}
+		for _, m := range pending {
+			log.Print("Pending migration ", m)
+		}
+		os.Exit(0)
+	}
+
 	if *isDebug {
 		fmt.Println("Debug mode enabled. Deleting the database...")
-		if err := os.Remove(filepathDB); err != nil {
+		if err := database.DeleteDB(filepathDB); err != nil {
 			log.Print("Database is already deleted")
 		} else {
 			log.Print("Database is successfully deleted")
 		}
 	}
-	db, err := database.NewDB(filepathDB)
+	refreshTokenKey, err := loadRefreshTokenKey(refreshTokenKeyFile)
+	if err != nil {
+		return apiConfig{}, fmt.Errorf("failed to load refresh token key: %v", err)
+	}
+	db, err := openStore(driver, filepathDB, flushInterval, timelineMode, refreshTokenKey)
 	if err != nil {
 		return apiConfig{}, fmt.Errorf("failed to initialize database: %v", err)
 	}
+	mediaStore, err := media.NewStore(mediaDir)
+	if err != nil {
+		return apiConfig{}, fmt.Errorf("failed to initialize media storage: %v", err)
+	}
+	jwtKeys, err := jwtkeys.Load(jwtKeysDir, jwtAlgorithm, database.AccessTokenTTL)
+	if err != nil {
+		return apiConfig{}, fmt.Errorf("failed to load JWT keys: %v", err)
+	}
 	log.Print("Config is created")
-	jwtSecret := os.Getenv("JWS_SECRET")
-	return apiConfig{fileserverHits: 0, db: db, jwtSecret: jwtSecret}, nil
+	return apiConfig{
+		fileserverHits:    0,
+		db:                db,
+		jwtKeys:           jwtKeys,
+		jwtAlgorithm:      jwtAlgorithm,
+		jwtKeyRotation:    jwtKeyRotation,
+		chirpEditWindow:   chirpEditWindow,
+		media:             mediaStore,
+		moderator:         moderator,
+		chirpLimits:       limits,
+		schedulerInterval: schedulerInterval,
+
+		mailer:               mail,
+		appURL:               appURL,
+		requireVerifiedEmail: requireVerifiedEmail,
+		mailsByIP:            newThrottle(mailsPerIP, mailWindow),
+		mailsByIPAndEmail:    newThrottle(mailsPerIPAndEmail, mailWindow),
+
+		adminKey: os.Getenv("ADMIN_KEY"),
+	}, nil
+}
+
+func durationFromEnv(name string, fallback time.Duration) (time.Duration, error) {
+	value := os.Getenv(name)
+	if value == "" {
+		return fallback, nil
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s: %v", name, err)
+	}
+	return d, nil
+}
+
+func intFromEnv(name string, fallback int) (int, error) {
+	value := os.Getenv(name)
+	if value == "" {
+		return fallback, nil
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("invalid %s: must be a positive integer", name)
+	}
+	return n, nil
+}
+
+func boolFromEnv(name string, fallback bool) (bool, error) {
+	value := os.Getenv(name)
+	if value == "" {
+		return fallback, nil
+	}
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		return false, fmt.Errorf("invalid %s: must be true or false", name)
+	}
+	return b, nil
+}
+
+// newMailer returns the mailer selected by MAILER: "log" (the default)
+// prints messages, "file" appends them to MAIL_FILE and "smtp" sends them
+// through SMTP_ADDR.
+func newMailer(kind string, from string) (mailer.Mailer, error) {
+	switch kind {
+	case "", "log":
+		return mailer.NewLog(from), nil
+	case "file":
+		path := os.Getenv("MAIL_FILE")
+		if path == "" {
+			path = "mail.log"
+		}
+		return mailer.NewFile(path, from)
+	case "smtp":
+		addr := os.Getenv("SMTP_ADDR")
+		if addr == "" {
+			return nil, fmt.Errorf("SMTP_ADDR is required when MAILER is smtp")
+		}
+		m, err := mailer.NewSMTP(addr, from, os.Getenv("SMTP_USERNAME"), os.Getenv("SMTP_PASSWORD"))
+		if err != nil {
+			return nil, fmt.Errorf("invalid SMTP_ADDR: %v", err)
+		}
+		return m, nil
+	default:
+		return nil, fmt.Errorf("invalid MAILER: must be log, file or smtp")
+	}
+}
+
+// loadRefreshTokenKey reads the hex-encoded key refresh tokens are hashed
+// with, generating one on first start. Losing or changing the key ends every
+// session.
+func loadRefreshTokenKey(path string) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		key := make([]byte, 32)
+		if _, err := rand.Read(key); err != nil {
+			return nil, err
+		}
+		if err := os.WriteFile(path, []byte(hex.EncodeToString(key)+"\n"), 0600); err != nil {
+			return nil, err
+		}
+		log.Printf("generated refresh token key in %s", path)
+		return key, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	key, err := hex.DecodeString(strings.TrimSpace(string(data)))
+	if err != nil || len(key) < 32 {
+		return nil, fmt.Errorf("%s must hold at least 32 hex-encoded bytes", path)
+	}
+	return key, nil
+}
+
+func openStore(driver string, path string, flushInterval time.Duration, timelineMode database.TimelineMode, refreshTokenKey []byte) (database.Store, error) {
+	switch driver {
+	case "json":
+		return database.NewDB(path, flushInterval, timelineMode, refreshTokenKey)
+	case "sqlite":
+		return database.NewSQLiteDB(path, timelineMode, refreshTokenKey)
+	default:
+		return nil, fmt.Errorf("unknown database driver %q", driver)
+	}
+}
+
+func dryRunMigrations(driver string, path string) ([]string, error) {
+	switch driver {
+	case "json":
+		return database.DryRunMigrations(path)
+	case "sqlite":
+		return database.DryRunSQLiteMigrations(path)
+	default:
+		return nil, fmt.Errorf("unknown database driver %q", driver)
+	}
 }
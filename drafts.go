@@ -0,0 +1,180 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/railanbaigazy/chirpy/internal/database"
+)
+
+type draftRequest struct {
+	chirpRequest
+	PublishAt time.Time `json:"publish_at"`
+}
+
+func (cfg *apiConfig) createDraftHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	tokenStr, err := getTokenString(r)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, err.Error())
+		return
+	}
+
+	userID, err := getUserIDByToken(cfg, tokenStr)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, err.Error())
+		return
+	}
+
+	if !cfg.checkEmailVerified(w, userID) {
+		return
+	}
+
+	draftReq := draftRequest{}
+	err = json.NewDecoder(r.Body).Decode(&draftReq)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+
+	moderated, ok := cfg.validateChirp(w, userID, draftReq.Body)
+	if !ok {
+		return
+	}
+
+	draft, err := cfg.db.CreateDraft(database.ChirpParams{
+		Body:       moderated.Text,
+		AuthorID:   userID,
+		ParentID:   draftReq.ParentID,
+		Kind:       draftReq.Kind,
+		OriginalID: draftReq.OriginalID,
+		MediaIDs:   draftReq.MediaIDs,
+		Flagged:    moderated.Flagged,
+	}, draftReq.PublishAt)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	respondWithJSON(w, http.StatusCreated, draft)
+}
+
+func (cfg *apiConfig) getDraftsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	tokenStr, err := getTokenString(r)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, err.Error())
+		return
+	}
+
+	userID, err := getUserIDByToken(cfg, tokenStr)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, err.Error())
+		return
+	}
+
+	drafts, err := cfg.db.GetDrafts(userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	respondWithJSON(w, http.StatusOK, drafts)
+}
+
+func (cfg *apiConfig) deleteDraftHandler(w http.ResponseWriter, r *http.Request) {
+	tokenStr, err := getTokenString(r)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, err.Error())
+		return
+	}
+
+	userID, err := getUserIDByToken(cfg, tokenStr)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, err.Error())
+		return
+	}
+
+	draftID, err := strconv.Atoi(r.PathValue("draftid"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "invalid id")
+		return
+	}
+
+	err = cfg.db.DeleteDraft(draftID, userID)
+	if errors.Is(err, database.ErrDraftNotFound) {
+		respondWithError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (cfg *apiConfig) publishDraftHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	tokenStr, err := getTokenString(r)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, err.Error())
+		return
+	}
+
+	userID, err := getUserIDByToken(cfg, tokenStr)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, err.Error())
+		return
+	}
+
+	draftID, err := strconv.Atoi(r.PathValue("draftid"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "invalid id")
+		return
+	}
+
+	chirp, err := cfg.db.PublishDraft(draftID, userID, cfg.reviewDraft)
+	if errors.Is(err, database.ErrDraftNotFound) {
+		respondWithError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	if errors.Is(err, errEmailNotVerified) {
+		respondWithError(w, http.StatusForbidden, errEmailNotVerified.Error())
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	respondWithJSON(w, http.StatusCreated, chirp)
+}
+
+// runScheduler publishes scheduled drafts as they come due, checking every
+// interval until ctx is done. It closes done once it has stopped.
+func (cfg *apiConfig) runScheduler(ctx context.Context, interval time.Duration, done chan<- struct{}) {
+	defer close(done)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		published, err := cfg.db.PublishDueDrafts(time.Now(), cfg.reviewDraft)
+		if err != nil {
+			log.Printf("error publishing scheduled drafts: %v", err)
+		}
+		if len(published) > 0 {
+			log.Printf("published %d scheduled chirps", len(published))
+		}
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
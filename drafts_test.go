@@ -0,0 +1,35 @@
+package main
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/railanbaigazy/chirpy/internal/database"
+	"github.com/railanbaigazy/chirpy/internal/moderation"
+)
+
+func TestReviewDraftRequiresVerifiedEmail(t *testing.T) {
+	moderator, err := moderation.New(moderation.DefaultConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := apiConfig{
+		moderator:            moderator,
+		chirpLimits:          chirpLimits{MaxLength: 140, URLLength: 23},
+		requireVerifiedEmail: true,
+	}
+	draft := database.Draft{Body: "hello"}
+
+	if _, _, err := cfg.reviewDraft(draft, database.AccountResp{}); !errors.Is(err, errEmailNotVerified) {
+		t.Fatalf("reviewing an unverified author's draft returned %v, want errEmailNotVerified", err)
+	}
+	body, _, err := cfg.reviewDraft(draft, database.AccountResp{EmailVerified: true})
+	if err != nil || body != "hello" {
+		t.Fatalf("reviewing a verified author's draft returned %q, %v", body, err)
+	}
+
+	cfg.requireVerifiedEmail = false
+	if _, _, err := cfg.reviewDraft(draft, database.AccountResp{}); err != nil {
+		t.Fatalf("reviewing without the requirement returned %v", err)
+	}
+}
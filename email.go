@@ -0,0 +1,211 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/railanbaigazy/chirpy/internal/database"
+	"github.com/railanbaigazy/chirpy/internal/mailer"
+)
+
+type passwordResetRequest struct {
+	Email string `json:"email"`
+}
+
+type resetPasswordRequest struct {
+	Token    string `json:"token"`
+	Password string `json:"password"`
+}
+
+type verifyEmailRequest struct {
+	Token string `json:"token"`
+}
+
+// checkMailThrottle responds with an error and returns false when the client
+// making the request asked for too many mails recently, in all or to email.
+// Recipients aren't throttled on their own, since then anyone could use up
+// someone else's allowance and keep them from resetting their password. It
+// doesn't look email up, so it tells nothing about accounts.
+func (cfg *apiConfig) checkMailThrottle(w http.ResponseWriter, r *http.Request, email string) bool {
+	now := time.Now()
+	ip := clientIP(r)
+	email = strings.ToLower(strings.TrimSpace(email))
+	if !cfg.mailsByIP.allow(ip, now) || !cfg.mailsByIPAndEmail.allow(ip+" "+email, now) {
+		w.Header().Set("Retry-After", fmt.Sprint(int(mailWindow.Seconds())))
+		respondWithError(w, http.StatusTooManyRequests, "too many emails requested, try again later")
+		return false
+	}
+	return true
+}
+
+// sendMail sends msg in the background, so that how long the mail server
+// takes doesn't tell callers whether an account exists.
+func (cfg *apiConfig) sendMail(msg mailer.Message) {
+	go func() {
+		if err := cfg.mailer.Send(msg); err != nil {
+			log.Printf("failed to send %q to %s: %v", msg.Subject, msg.To, err)
+		}
+	}()
+}
+
+func (cfg *apiConfig) sendEmailVerification(userID int) error {
+	token, account, err := cfg.db.CreateEmailVerificationToken(userID)
+	if err != nil {
+		return err
+	}
+	cfg.sendMail(mailer.Message{
+		To:      account.Email,
+		Subject: "Verify your Chirpy email address",
+		Body: fmt.Sprintf("Hi @%s,\n\nConfirm this is your email address by opening:\n\n%s/verify-email?token=%s\n\n"+
+			"The link expires in %v. If you didn't sign up for Chirpy, ignore this email.\n",
+			account.Handle, cfg.appURL, url.QueryEscape(token), database.EmailVerificationTokenTTL),
+	})
+	return nil
+}
+
+// requestPasswordResetHandler mails a password reset link. It answers the
+// same whether or not an account uses the address.
+func (cfg *apiConfig) requestPasswordResetHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	req := passwordResetRequest{}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if !cfg.checkMailThrottle(w, r, req.Email) {
+		return
+	}
+
+	token, account, err := cfg.db.CreatePasswordResetToken(req.Email)
+	if err != nil && !errors.Is(err, database.ErrUserNotFound) {
+		respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if err == nil {
+		cfg.sendMail(mailer.Message{
+			To:      account.Email,
+			Subject: "Reset your Chirpy password",
+			Body: fmt.Sprintf("Hi @%s,\n\nReset your password by opening:\n\n%s/reset-password?token=%s\n\n"+
+				"The link expires in %v. If you didn't ask to reset your password, ignore this email.\n",
+				account.Handle, cfg.appURL, url.QueryEscape(token), database.PasswordResetTokenTTL),
+		})
+	}
+	w.WriteHeader(http.StatusAccepted)
+}
+
+func (cfg *apiConfig) resetPasswordHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	req := resetPasswordRequest{}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	hashPassword := validatePassword(w, req.Password)
+	if hashPassword == nil {
+		return
+	}
+
+	err := cfg.db.ResetPassword(req.Token, hashPassword)
+	if errors.Is(err, database.ErrInvalidEmailToken) {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (cfg *apiConfig) requestEmailVerificationHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	tokenStr, err := getTokenString(r)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, err.Error())
+		return
+	}
+
+	userID, err := getUserIDByToken(cfg, tokenStr)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, err.Error())
+		return
+	}
+	account, err := cfg.db.GetAccount(userID)
+	if errors.Is(err, database.ErrUserNotFound) {
+		respondWithError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if !cfg.checkMailThrottle(w, r, account.Email) {
+		return
+	}
+
+	err = cfg.sendEmailVerification(userID)
+	if errors.Is(err, database.ErrEmailAlreadyVerified) {
+		respondWithError(w, http.StatusConflict, err.Error())
+		return
+	}
+	if errors.Is(err, database.ErrUserNotFound) {
+		respondWithError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	w.WriteHeader(http.StatusAccepted)
+}
+
+func (cfg *apiConfig) verifyEmailHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	req := verifyEmailRequest{}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	account, err := cfg.db.VerifyEmail(req.Token)
+	if errors.Is(err, database.ErrInvalidEmailToken) {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	respondWithJSON(w, http.StatusOK, account)
+}
+
+var errEmailNotVerified = errors.New("verify your email address before posting")
+
+// checkEmailVerified responds with an error and returns false when posting
+// requires a verified email address and userID hasn't verified theirs.
+func (cfg *apiConfig) checkEmailVerified(w http.ResponseWriter, userID int) bool {
+	if !cfg.requireVerifiedEmail {
+		return true
+	}
+	account, err := cfg.db.GetAccount(userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, err.Error())
+		return false
+	}
+	if !account.EmailVerified {
+		respondWithError(w, http.StatusForbidden, errEmailNotVerified.Error())
+		return false
+	}
+	return true
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestCheckMailThrottle(t *testing.T) {
+	cfg := apiConfig{
+		mailsByIP:         newThrottle(mailsPerIP, mailWindow),
+		mailsByIPAndEmail: newThrottle(mailsPerIPAndEmail, mailWindow),
+	}
+	check := func(ip, email string) int {
+		r := httptest.NewRequest("POST", "/api/password_resets", nil)
+		r.RemoteAddr = ip + ":1234"
+		w := httptest.NewRecorder()
+		cfg.checkMailThrottle(w, r, email)
+		return w.Code
+	}
+
+	for range mailsPerIPAndEmail {
+		if code := check("192.0.2.1", "victim@example.com"); code != http.StatusOK {
+			t.Fatalf("mail within the limit returned %d", code)
+		}
+	}
+	if code := check("192.0.2.1", "Victim@example.com "); code != http.StatusTooManyRequests {
+		t.Fatalf("mail over the limit for one address returned %d", code)
+	}
+	if code := check("192.0.2.2", "victim@example.com"); code != http.StatusOK {
+		t.Fatalf("another client mailing the same address returned %d", code)
+	}
+
+	for i := range mailsPerIP {
+		check("198.51.100.1", string(rune('a'+i))+"@example.com")
+	}
+	if code := check("198.51.100.1", "z@example.com"); code != http.StatusTooManyRequests {
+		t.Fatalf("mail over the limit for one client returned %d", code)
+	}
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/railanbaigazy/chirpy/internal/database"
+)
+
+func (cfg *apiConfig) followUserHandler(w http.ResponseWriter, r *http.Request) {
+	cfg.setFollow(w, r, cfg.db.FollowUser)
+}
+
+func (cfg *apiConfig) unfollowUserHandler(w http.ResponseWriter, r *http.Request) {
+	cfg.setFollow(w, r, cfg.db.UnfollowUser)
+}
+
+func (cfg *apiConfig) setFollow(w http.ResponseWriter, r *http.Request, update func(followerID int, followeeID int) error) {
+	w.Header().Set("Content-Type", "application/json")
+
+	tokenStr, err := getTokenString(r)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, err.Error())
+		return
+	}
+
+	userID, err := getUserIDByToken(cfg, tokenStr)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, err.Error())
+		return
+	}
+
+	followeeID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil || followeeID <= 0 {
+		respondWithError(w, http.StatusBadRequest, "invalid id")
+		return
+	}
+
+	err = update(userID, followeeID)
+	if errors.Is(err, database.ErrUserNotFound) {
+		respondWithError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	if errors.Is(err, database.ErrCannotFollowSelf) {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (cfg *apiConfig) getFollowersHandler(w http.ResponseWriter, r *http.Request) {
+	cfg.listFollows(w, r, cfg.db.GetFollowers)
+}
+
+func (cfg *apiConfig) getFollowingHandler(w http.ResponseWriter, r *http.Request) {
+	cfg.listFollows(w, r, cfg.db.GetFollowing)
+}
+
+func (cfg *apiConfig) listFollows(w http.ResponseWriter, r *http.Request, list func(userID int) ([]database.Follow, error)) {
+	w.Header().Set("Content-Type", "application/json")
+
+	userID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil || userID <= 0 {
+		respondWithError(w, http.StatusBadRequest, "invalid id")
+		return
+	}
+
+	follows, err := list(userID)
+	if errors.Is(err, database.ErrUserNotFound) {
+		respondWithError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	respondWithJSON(w, http.StatusOK, follows)
+}
+
+func (cfg *apiConfig) timelineHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	tokenStr, err := getTokenString(r)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, err.Error())
+		return
+	}
+
+	userID, err := getUserIDByToken(cfg, tokenStr)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, err.Error())
+		return
+	}
+
+	query, err := parseChirpQuery(r)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if r.URL.Query().Get("sort") == "" {
+		query.Descending = true
+	}
+
+	page, err := cfg.db.GetTimeline(userID, query)
+	if errors.Is(err, database.ErrInvalidCursor) {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	if err = cfg.markLikedByMe(userID, page.Chirps); err != nil {
+		respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	respondWithChirpPage(w, page)
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"crypto/subtle"
 	"errors"
 	"net/http"
 	"strings"
@@ -20,6 +21,21 @@ func getTokenString(r *http.Request) (string, error) {
 	return tokenStr, nil
 }
 
+// checkAdmin responds with an error and returns false unless the request
+// carries the admin API key.
+func (cfg *apiConfig) checkAdmin(w http.ResponseWriter, r *http.Request) bool {
+	apiKey, err := getApiKey(r)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, err.Error())
+		return false
+	}
+	if cfg.adminKey == "" || subtle.ConstantTimeCompare([]byte(apiKey), []byte(cfg.adminKey)) != 1 {
+		respondWithError(w, http.StatusForbidden, "admin access required")
+		return false
+	}
+	return true
+}
+
 func getApiKey(r *http.Request) (string, error) {
 	headerText := r.Header.Get("Authorization")
 	if headerText == "" {
@@ -0,0 +1,119 @@
+package database
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	benchmarkChirps   = 100_000
+	benchmarkEmail    = "author@example.com"
+	benchmarkPassword = "password"
+)
+
+// benchmarkDB holds benchmarkChirps chirps created through CreateChirp by a
+// single author. Creating that many takes a while, so it is seeded once and
+// shared by the benchmarks.
+var benchmarkDB struct {
+	once sync.Once
+	dir  string
+	db   *DB
+	err  error
+}
+
+func TestMain(m *testing.M) {
+	code := m.Run()
+	if benchmarkDB.db != nil {
+		benchmarkDB.db.Close()
+	}
+	if benchmarkDB.dir != "" {
+		os.RemoveAll(benchmarkDB.dir)
+	}
+	os.Exit(code)
+}
+
+func seedBenchmarkDB() (*DB, error) {
+	dir, err := os.MkdirTemp("", "chirpy-benchmark-")
+	if err != nil {
+		return nil, err
+	}
+	benchmarkDB.dir = dir
+	// Only the journal is written while seeding; the snapshot waits for Close.
+	db, err := NewDB(filepath.Join(dir, "database.json"), time.Hour, FanOutOnRead, testTokenKey)
+	if err != nil {
+		return nil, err
+	}
+	benchmarkDB.db = db
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(benchmarkPassword), bcrypt.MinCost)
+	if err != nil {
+		return nil, err
+	}
+	author, err := db.CreateUser(benchmarkEmail, "author", hash)
+	if err != nil {
+		return nil, err
+	}
+	for i := range benchmarkChirps {
+		params := ChirpParams{Body: fmt.Sprintf("chirp number %d", i+1), AuthorID: author.ID}
+		if _, err := db.CreateChirp(params); err != nil {
+			return nil, err
+		}
+	}
+	return db, nil
+}
+
+// seededDB returns the shared benchmark database and resets the timer, so
+// seeding it isn't measured.
+func seededDB(b *testing.B) *DB {
+	b.Helper()
+	benchmarkDB.once.Do(func() {
+		_, benchmarkDB.err = seedBenchmarkDB()
+	})
+	if benchmarkDB.err != nil {
+		b.Fatal(benchmarkDB.err)
+	}
+	b.ResetTimer()
+	return benchmarkDB.db
+}
+
+func BenchmarkGetChirps(b *testing.B) {
+	db := seededDB(b)
+	for range b.N {
+		if _, err := db.GetChirps(ChirpQuery{Descending: true, Limit: 20}); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkGetChirpByID(b *testing.B) {
+	db := seededDB(b)
+	for i := range b.N {
+		if _, err := db.GetChirpByID(i%benchmarkChirps + 1); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkRefreshAccessToken(b *testing.B) {
+	db := seededDB(b)
+	login, _, err := db.Login(benchmarkEmail, benchmarkPassword, testSigner{}, SessionInfo{})
+	if err != nil {
+		b.Fatal(err)
+	}
+	refreshToken := login.RefreshToken
+	b.ResetTimer()
+	for range b.N {
+		// Each refresh rotates the token, so the next one uses the new token.
+		resp, err := db.RefreshAccessToken(refreshToken, testSigner{}, "")
+		if err != nil {
+			b.Fatal(err)
+		}
+		refreshToken = resp.RefreshToken
+	}
+}
@@ -0,0 +1,66 @@
+package database
+
+import (
+	"slices"
+	"time"
+)
+
+// A Bookmark is private to the user who made it: bookmarks are only ever
+// listed for their owner and never counted on the chirp.
+type Bookmark struct {
+	ChirpID   int       `json:"chirp_id"`
+	UserID    int       `json:"user_id"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// BookmarkChirp bookmarks chirpID for userID. Bookmarking a chirp twice is
+// not an error.
+func (db *DB) BookmarkChirp(chirpID int, userID int) error {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+
+	dbStructure := db.data
+
+	chirp, ok := dbStructure.Chirps[chirpID]
+	if !ok || chirp.Deleted {
+		return ErrChirpNotFound
+	}
+
+	oldBookmarks := dbStructure.Bookmarks[chirpID]
+	if slices.ContainsFunc(oldBookmarks, func(bookmark Bookmark) bool { return bookmark.UserID == userID }) {
+		return nil
+	}
+
+	bookmarks := make([]Bookmark, len(oldBookmarks), len(oldBookmarks)+1)
+	copy(bookmarks, oldBookmarks)
+	bookmarks = append(bookmarks, Bookmark{ChirpID: chirpID, UserID: userID, CreatedAt: time.Now().UTC()})
+	return db.commit(putEntry(collectionBookmarks, chirpID, bookmarks))
+}
+
+func (db *DB) UnbookmarkChirp(chirpID int, userID int) error {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+
+	dbStructure := db.data
+
+	chirp, ok := dbStructure.Chirps[chirpID]
+	if !ok || chirp.Deleted {
+		return ErrChirpNotFound
+	}
+
+	oldBookmarks := dbStructure.Bookmarks[chirpID]
+	bookmarks := slices.DeleteFunc(slices.Clone(oldBookmarks), func(bookmark Bookmark) bool { return bookmark.UserID == userID })
+	if len(bookmarks) == len(oldBookmarks) {
+		return nil
+	}
+	if len(bookmarks) == 0 {
+		return db.commit(deleteEntry(collectionBookmarks, chirpID))
+	}
+	return db.commit(putEntry(collectionBookmarks, chirpID, bookmarks))
+}
+
+// GetBookmarks returns the chirps userID has bookmarked.
+func (db *DB) GetBookmarks(userID int, query ChirpQuery) (ChirpPage, error) {
+	query.bookmarkedByUserID = userID
+	return db.GetChirps(query)
+}
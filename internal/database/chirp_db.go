@@ -2,108 +2,299 @@ package database
 
 import (
 	"errors"
-	"strconv"
+	"time"
 )
 
 type Chirp struct {
-	ID       int    `json:"id"`
-	Body     string `json:"body"`
-	AuthorID int    `json:"author_id"`
+	ID        int           `json:"id"`
+	Body      string        `json:"body"`
+	AuthorID  int           `json:"author_id"`
+	Entities  ChirpEntities `json:"entities"`
+	CreatedAt time.Time     `json:"created_at"`
+	UpdatedAt time.Time     `json:"updated_at"`
+
+	// ParentID is the chirp this one replies to and RootID the chirp that
+	// started the conversation, which is the chirp itself for top-level
+	// chirps. A deleted chirp that still has replies is kept as a tombstone
+	// with Deleted set and its body cleared, so the thread stays connected.
+	ParentID int  `json:"parent_id,omitempty"`
+	RootID   int  `json:"root_id"`
+	Deleted  bool `json:"deleted,omitempty"`
+
+	// Rechirps and quotes share the chirp OriginalID points to; a rechirp
+	// has no body of its own. Quotes outlive their original, rechirps are
+	// deleted with it.
+	Kind       string `json:"kind"`
+	OriginalID int    `json:"original_id,omitempty"`
+
+	Media ChirpMedia `json:"media,omitempty"`
+
+	// Flagged marks chirps that moderation wants a person to look at.
+	Flagged bool `json:"flagged,omitempty"`
+
+	// The counts are kept up to date as likes, rechirps and quotes come and
+	// go. LikedByMe is never stored; handlers fill it in for the user making
+	// the request.
+	LikeCount    int   `json:"like_count"`
+	RechirpCount int   `json:"rechirp_count"`
+	QuoteCount   int   `json:"quote_count"`
+	LikedByMe    *bool `json:"liked_by_me,omitempty"`
+}
+
+const (
+	ChirpKindChirp   = "chirp"
+	ChirpKindRechirp = "rechirp"
+	ChirpKindQuote   = "quote"
+)
+
+type ChirpParams struct {
+	Body       string
+	AuthorID   int
+	ParentID   int
+	Kind       string
+	OriginalID int
+	MediaIDs   []int
+	Flagged    bool
+}
+
+// validate checks that the fields set in p fit its kind, defaulting an empty
+// kind to a plain chirp.
+func (p *ChirpParams) validate() error {
+	if p.Kind == "" {
+		p.Kind = ChirpKindChirp
+	}
+	switch p.Kind {
+	case ChirpKindChirp:
+		if p.OriginalID != 0 {
+			return ErrInvalidChirpKind
+		}
+	case ChirpKindRechirp:
+		if p.Body != "" || p.ParentID != 0 || len(p.MediaIDs) > 0 {
+			return ErrInvalidChirpKind
+		}
+		fallthrough
+	case ChirpKindQuote:
+		if p.OriginalID == 0 {
+			return ErrOriginalNotFound
+		}
+	default:
+		return ErrInvalidChirpKind
+	}
+	return validateMediaIDs(p.MediaIDs)
 }
 
-func (db *DB) CreateChirp(body string, userID int) (Chirp, error) {
+// CreateChirp stores a new chirp. Replying to, rechirping or quoting a
+// rechirp applies to the chirp it shares instead.
+func (db *DB) CreateChirp(params ChirpParams) (Chirp, error) {
 	db.mux.Lock()
 	defer db.mux.Unlock()
 
-	dbStructure, err := db.loadDB()
+	newChirp, entries, err := db.data.newChirp(params)
 	if err != nil {
 		return Chirp{}, err
 	}
+	if err := db.commit(entries...); err != nil {
+		return Chirp{}, err
+	}
+
+	return newChirp, nil
+}
+
+// newChirp builds the chirp described by params together with the journal
+// entries that store it, without committing them.
+func (dbStructure *DBStructure) newChirp(params ChirpParams) (Chirp, []journalEntry, error) {
+	if err := params.validate(); err != nil {
+		return Chirp{}, nil, err
+	}
 
-	newID := len(dbStructure.Chirps) + 1
+	media, err := dbStructure.chirpMedia(params.MediaIDs, params.AuthorID)
+	if err != nil {
+		return Chirp{}, nil, err
+	}
+
+	now := time.Now().UTC()
+	newID := dbStructure.nextID(collectionChirps)
 	newChirp := Chirp{
-		ID:       newID,
-		Body:     body,
-		AuthorID: userID,
+		ID:        newID,
+		Body:      params.Body,
+		AuthorID:  params.AuthorID,
+		Entities:  parseEntities(params.Body, dbStructure.resolveMention),
+		CreatedAt: now,
+		UpdatedAt: now,
+		RootID:    newID,
+		Kind:      params.Kind,
+		Media:     media,
+		Flagged:   params.Flagged,
 	}
 
-	dbStructure.Chirps[newID] = newChirp
+	if params.ParentID != 0 {
+		parent, ok := dbStructure.sharedChirp(params.ParentID)
+		if !ok {
+			return Chirp{}, nil, ErrParentNotFound
+		}
+		newChirp.ParentID = parent.ID
+		newChirp.RootID = parent.RootID
+	}
 
-	if err := db.writeDB(dbStructure); err != nil {
-		return Chirp{}, err
+	entries := []journalEntry{}
+	if params.Kind != ChirpKindChirp {
+		original, ok := dbStructure.sharedChirp(params.OriginalID)
+		if !ok {
+			return Chirp{}, nil, ErrOriginalNotFound
+		}
+		if params.Kind == ChirpKindRechirp {
+			for _, id := range dbStructure.idx.rechirpsByOriginal[original.ID] {
+				if dbStructure.Chirps[id].AuthorID == params.AuthorID {
+					return Chirp{}, nil, ErrAlreadyRechirped
+				}
+			}
+			original.RechirpCount++
+		} else {
+			original.QuoteCount++
+		}
+		newChirp.OriginalID = original.ID
+		entries = append(entries, putEntry(collectionChirps, original.ID, original))
 	}
 
-	return newChirp, nil
+	entries = append(entries, putEntry(collectionChirps, newID, newChirp))
+	return newChirp, entries, nil
 }
 
-func (db *DB) GetChirps(authorIDStr string) ([]Chirp, error) {
+func (db *DB) GetChirps(query ChirpQuery) (ChirpPage, error) {
 	db.mux.RLock()
 	defer db.mux.RUnlock()
 
-	dbStructure, err := db.loadDB()
+	dbStructure := db.data
+
+	low, high, err := query.idRange()
 	if err != nil {
-		return nil, err
+		return ChirpPage{}, err
+	}
+
+	sources := [][]int{dbStructure.idx.chirpIDs}
+	switch {
+	case query.timelineUserID != 0:
+		sources = dbStructure.timelineSources(query.timelineUserID)
+	case query.bookmarkedByUserID != 0:
+		sources = [][]int{dbStructure.idx.chirpsBookmarkedBy[query.bookmarkedByUserID]}
+	case query.LikedByUserID != 0:
+		sources = [][]int{dbStructure.idx.chirpsLikedBy[query.LikedByUserID]}
+	case query.Hashtag != "":
+		sources = [][]int{dbStructure.idx.chirpsByHashtag[normalizeTag(query.Hashtag)]}
+	case query.MentionedUserID != 0:
+		sources = [][]int{dbStructure.idx.chirpsByMention[query.MentionedUserID]}
+	case query.AuthorID != 0:
+		sources = [][]int{dbStructure.idx.chirpsByAuthor[query.AuthorID]}
 	}
 
-	chirps := make([]Chirp, 0, len(dbStructure.Chirps))
-	if authorIDStr == "" {
-		for _, chirp := range dbStructure.Chirps {
+	chirps := []Chirp{}
+	collect := func(id int) bool {
+		chirp := dbStructure.Chirps[id]
+		if query.matches(chirp) {
 			chirps = append(chirps, chirp)
 		}
-		return chirps, nil
+		return query.Limit <= 0 || len(chirps) <= query.Limit
 	}
 
-	authorID, err := strconv.Atoi(authorIDStr)
-	if err != nil {
-		return nil, err
-	}
+	walkMerged(sources, low, high, query.Descending, collect)
 
-	for _, chirp := range dbStructure.Chirps {
-		if chirp.AuthorID == authorID {
-			chirps = append(chirps, chirp)
-		}
+	return query.newPage(chirps), nil
+}
+
+// sharedChirp looks up the chirp that replies, rechirps and quotes of id
+// refer to: the chirp itself, or its original if it is a rechirp.
+func (dbStructure *DBStructure) sharedChirp(id int) (Chirp, bool) {
+	chirp, ok := dbStructure.Chirps[id]
+	if ok && chirp.Kind == ChirpKindRechirp {
+		chirp, ok = dbStructure.Chirps[chirp.OriginalID]
 	}
-	return chirps, nil
+	if !ok || chirp.Deleted {
+		return Chirp{}, false
+	}
+	return chirp, true
 }
 
 func (db *DB) GetChirpByID(id int) (Chirp, error) {
 	db.mux.RLock()
 	defer db.mux.RUnlock()
 
-	dbStructure, err := db.loadDB()
-	if err != nil {
-		return Chirp{}, err
-	}
+	dbStructure := db.data
 
 	chirp, ok := dbStructure.Chirps[id]
-	if !ok {
+	if !ok || chirp.Deleted {
 		return Chirp{}, errors.New("id not found")
 	}
 
 	return chirp, nil
 }
 
+// DeleteChirp removes a chirp and its rechirps. A chirp with replies is
+// replaced by a tombstone instead, and tombstones left without replies are
+// removed along with their last reply.
 func (db *DB) DeleteChirp(chirpID int, userID int) error {
 	db.mux.Lock()
 	defer db.mux.Unlock()
 
-	dbStructure, err := db.loadDB()
-	if err != nil {
-		return err
-	}
+	dbStructure := db.data
 
 	chirp, ok := dbStructure.Chirps[chirpID]
-	if !ok {
-		return errors.New("chirp not found")
+	if !ok || chirp.Deleted {
+		return ErrChirpNotFound
 	}
 
 	if chirp.AuthorID != userID {
-		return errors.New("access denied")
+		return ErrAccessDenied
 	}
-	delete(dbStructure.Chirps, chirpID)
 
-	if err = db.writeDB(dbStructure); err != nil {
-		return err
+	entries := []journalEntry{}
+	if original, ok := dbStructure.Chirps[chirp.OriginalID]; ok && !original.Deleted {
+		if chirp.Kind == ChirpKindRechirp {
+			original.RechirpCount--
+		} else {
+			original.QuoteCount--
+		}
+		entries = append(entries, putEntry(collectionChirps, original.ID, original))
+	}
+	for _, id := range dbStructure.idx.rechirpsByOriginal[chirpID] {
+		entries = append(entries,
+			deleteEntry(collectionChirps, id),
+			deleteEntry(collectionLikes, id),
+			deleteEntry(collectionBookmarks, id),
+		)
+	}
+
+	if len(dbStructure.idx.repliesByParent[chirpID]) > 0 {
+		chirp.Body = ""
+		chirp.Entities = ChirpEntities{Hashtags: []Hashtag{}, Mentions: []Mention{}}
+		chirp.UpdatedAt = time.Now().UTC()
+		chirp.Deleted = true
+		chirp.Media = nil
+		chirp.Flagged = false
+		chirp.LikeCount = 0
+		chirp.RechirpCount = 0
+		chirp.QuoteCount = 0
+		entries = append(entries,
+			putEntry(collectionChirps, chirpID, chirp),
+			deleteEntry(collectionRevisions, chirpID),
+			deleteEntry(collectionLikes, chirpID),
+			deleteEntry(collectionBookmarks, chirpID),
+		)
+		return db.commit(entries...)
+	}
+
+	entries = append(entries,
+		deleteEntry(collectionChirps, chirpID),
+		deleteEntry(collectionRevisions, chirpID),
+		deleteEntry(collectionLikes, chirpID),
+		deleteEntry(collectionBookmarks, chirpID),
+	)
+	for chirp.ParentID != 0 {
+		parent := dbStructure.Chirps[chirp.ParentID]
+		if !parent.Deleted || len(dbStructure.idx.repliesByParent[parent.ID]) > 1 {
+			break
+		}
+		entries = append(entries, deleteEntry(collectionChirps, parent.ID))
+		chirp = parent
 	}
-	return nil
+	return db.commit(entries...)
 }
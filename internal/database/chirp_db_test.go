@@ -0,0 +1,79 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+)
+
+// chirpIDs returns the IDs of the chirps on page, in order.
+func chirpIDs(page ChirpPage) []int {
+	ids := make([]int, 0, len(page.Chirps))
+	for _, chirp := range page.Chirps {
+		ids = append(ids, chirp.ID)
+	}
+	return ids
+}
+
+func TestGetChirpsCursorPagination(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store Store) {
+		author, err := store.CreateUser("a@example.com", "", []byte("hash"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := range 5 {
+			if _, err := store.CreateChirp(ChirpParams{Body: fmt.Sprintf("chirp %d", i+1), AuthorID: author.ID}); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		for _, descending := range []bool{false, true} {
+			want := [][]int{{1, 2}, {3, 4}, {5}}
+			if descending {
+				want = [][]int{{5, 4}, {3, 2}, {1}}
+			}
+			query := ChirpQuery{Descending: descending, Limit: 2}
+			for i, wantIDs := range want {
+				page, err := store.GetChirps(query)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if got := chirpIDs(page); fmt.Sprint(got) != fmt.Sprint(wantIDs) {
+					t.Fatalf("descending=%v page %d holds %v, want %v", descending, i+1, got, wantIDs)
+				}
+				if last := i == len(want)-1; last != (page.NextCursor == "") {
+					t.Fatalf("descending=%v page %d has next cursor %q", descending, i+1, page.NextCursor)
+				}
+				query.Cursor = page.NextCursor
+			}
+		}
+	})
+}
+
+func TestGetChirpsRejectsBadCursors(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store Store) {
+		author, err := store.CreateUser("a@example.com", "", []byte("hash"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for range 3 {
+			if _, err := store.CreateChirp(ChirpParams{Body: "hello", AuthorID: author.ID}); err != nil {
+				t.Fatal(err)
+			}
+		}
+		page, err := store.GetChirps(ChirpQuery{Limit: 1})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for name, query := range map[string]ChirpQuery{
+			"garbage":         {Cursor: "not a cursor"},
+			"flipped order":   {Cursor: page.NextCursor, Descending: true},
+			"not base64 json": {Cursor: "bm90IGpzb24"},
+		} {
+			if _, err := store.GetChirps(query); !errors.Is(err, ErrInvalidCursor) {
+				t.Errorf("%s cursor returned %v, want ErrInvalidCursor", name, err)
+			}
+		}
+	})
+}
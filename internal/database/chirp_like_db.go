@@ -0,0 +1,89 @@
+package database
+
+import (
+	"slices"
+	"time"
+)
+
+type Like struct {
+	ChirpID   int       `json:"chirp_id"`
+	UserID    int       `json:"user_id"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// LikeChirp records that userID likes chirpID. Liking a chirp twice is not
+// an error and leaves the count unchanged.
+func (db *DB) LikeChirp(chirpID int, userID int) (Chirp, error) {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+
+	dbStructure := db.data
+
+	chirp, ok := dbStructure.Chirps[chirpID]
+	if !ok || chirp.Deleted {
+		return Chirp{}, ErrChirpNotFound
+	}
+
+	oldLikes := dbStructure.Likes[chirpID]
+	if slices.ContainsFunc(oldLikes, func(like Like) bool { return like.UserID == userID }) {
+		return chirp, nil
+	}
+
+	likes := make([]Like, len(oldLikes), len(oldLikes)+1)
+	copy(likes, oldLikes)
+	likes = append(likes, Like{ChirpID: chirpID, UserID: userID, CreatedAt: time.Now().UTC()})
+	chirp.LikeCount = len(likes)
+
+	err := db.commit(
+		putEntry(collectionLikes, chirpID, likes),
+		putEntry(collectionChirps, chirpID, chirp),
+	)
+	if err != nil {
+		return Chirp{}, err
+	}
+	return chirp, nil
+}
+
+func (db *DB) UnlikeChirp(chirpID int, userID int) (Chirp, error) {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+
+	dbStructure := db.data
+
+	chirp, ok := dbStructure.Chirps[chirpID]
+	if !ok || chirp.Deleted {
+		return Chirp{}, ErrChirpNotFound
+	}
+
+	oldLikes := dbStructure.Likes[chirpID]
+	likes := slices.DeleteFunc(slices.Clone(oldLikes), func(like Like) bool { return like.UserID == userID })
+	if len(likes) == len(oldLikes) {
+		return chirp, nil
+	}
+	chirp.LikeCount = len(likes)
+
+	likesEntry := putEntry(collectionLikes, chirpID, likes)
+	if len(likes) == 0 {
+		likesEntry = deleteEntry(collectionLikes, chirpID)
+	}
+	err := db.commit(likesEntry, putEntry(collectionChirps, chirpID, chirp))
+	if err != nil {
+		return Chirp{}, err
+	}
+	return chirp, nil
+}
+
+// LikedChirps reports which of chirpIDs userID has liked.
+func (db *DB) LikedChirps(userID int, chirpIDs []int) (map[int]bool, error) {
+	db.mux.RLock()
+	defer db.mux.RUnlock()
+
+	dbStructure := db.data
+
+	liked := dbStructure.idx.chirpsLikedBy[userID]
+	result := make(map[int]bool, len(chirpIDs))
+	for _, id := range chirpIDs {
+		_, result[id] = slices.BinarySearch(liked, id)
+	}
+	return result, nil
+}
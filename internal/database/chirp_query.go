@@ -0,0 +1,124 @@
+package database
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math"
+	"time"
+)
+
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// ChirpQuery describes a page of chirps ordered by ID. Zero values mean
+// "no filter"; a zero Limit returns every matching chirp.
+type ChirpQuery struct {
+	AuthorID        int
+	Hashtag         string
+	MentionedUserID int
+	LikedByUserID   int
+	Flagged         bool
+	SinceID         int
+	MaxID           int
+	CreatedAfter    time.Time
+	CreatedBefore   time.Time
+	Descending      bool
+	Limit           int
+	Cursor          string
+
+	// timelineUserID limits the query to the home timeline of a user; it
+	// is set by GetTimeline.
+	timelineUserID int
+	// bookmarkedByUserID limits the query to the bookmarks of a user. It is
+	// set by GetBookmarks only, so bookmarks can't be listed for anyone else.
+	bookmarkedByUserID int
+}
+
+// A ChirpPage is one page of a chirp listing. NextCursor is empty on the
+// last page.
+type ChirpPage struct {
+	Chirps     []Chirp `json:"chirps"`
+	NextCursor string  `json:"next_cursor,omitempty"`
+}
+
+type chirpCursor struct {
+	ID         int  `json:"id"`
+	Descending bool `json:"desc"`
+}
+
+func encodeCursor(id int, descending bool) string {
+	data, _ := json.Marshal(chirpCursor{ID: id, Descending: descending})
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeCursor(cursor string) (chirpCursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return chirpCursor{}, ErrInvalidCursor
+	}
+	c := chirpCursor{}
+	if err := json.Unmarshal(data, &c); err != nil {
+		return chirpCursor{}, ErrInvalidCursor
+	}
+	return c, nil
+}
+
+// idRange returns the exclusive lower and inclusive upper ID bounds implied
+// by SinceID, MaxID and the cursor.
+func (q ChirpQuery) idRange() (int, int, error) {
+	low, high := q.SinceID, math.MaxInt
+	if q.MaxID > 0 {
+		high = q.MaxID
+	}
+
+	if q.Cursor != "" {
+		c, err := decodeCursor(q.Cursor)
+		if err != nil {
+			return 0, 0, err
+		}
+		if c.Descending != q.Descending {
+			return 0, 0, ErrInvalidCursor
+		}
+		if q.Descending {
+			high = min(high, c.ID-1)
+		} else {
+			low = max(low, c.ID)
+		}
+	}
+	return low, high, nil
+}
+
+func (q ChirpQuery) matches(chirp Chirp) bool {
+	if q.AuthorID != 0 && chirp.AuthorID != q.AuthorID {
+		return false
+	}
+	if q.Hashtag != "" && !chirp.Entities.hasHashtag(normalizeTag(q.Hashtag)) {
+		return false
+	}
+	if q.MentionedUserID != 0 && !chirp.Entities.mentions(q.MentionedUserID) {
+		return false
+	}
+	if q.Flagged && !chirp.Flagged {
+		return false
+	}
+	if !q.CreatedAfter.IsZero() && chirp.CreatedAt.Before(q.CreatedAfter) {
+		return false
+	}
+	if !q.CreatedBefore.IsZero() && !chirp.CreatedAt.Before(q.CreatedBefore) {
+		return false
+	}
+	return true
+}
+
+// newPage trims chirps, which may hold one more than Limit, down to Limit
+// and sets the cursor for the following page if there is one.
+func (q ChirpQuery) newPage(chirps []Chirp) ChirpPage {
+	if q.Limit <= 0 || len(chirps) <= q.Limit {
+		return ChirpPage{Chirps: chirps}
+	}
+	chirps = chirps[:q.Limit]
+	return ChirpPage{
+		Chirps:     chirps,
+		NextCursor: encodeCursor(chirps[len(chirps)-1].ID, q.Descending),
+	}
+}
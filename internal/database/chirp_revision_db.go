@@ -0,0 +1,81 @@
+package database
+
+import "time"
+
+type ChirpRevision struct {
+	Revision  int       `json:"revision"`
+	Body      string    `json:"body"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+func (db *DB) UpdateChirp(chirpID int, userID int, body string, flagged bool, editWindow time.Duration) (Chirp, error) {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+
+	dbStructure := db.data
+
+	chirp, ok := dbStructure.Chirps[chirpID]
+	if !ok || chirp.Deleted {
+		return Chirp{}, ErrChirpNotFound
+	}
+
+	if chirp.AuthorID != userID {
+		return Chirp{}, ErrAccessDenied
+	}
+
+	if chirp.Kind == ChirpKindRechirp {
+		return Chirp{}, ErrRechirpNotEditable
+	}
+
+	now := time.Now().UTC()
+	if now.Sub(chirp.CreatedAt) > editWindow {
+		return Chirp{}, ErrEditWindowExpired
+	}
+
+	oldRevisions := dbStructure.Revisions[chirpID]
+	revisions := make([]ChirpRevision, len(oldRevisions), len(oldRevisions)+1)
+	copy(revisions, oldRevisions)
+	revisions = append(revisions, ChirpRevision{
+		Revision:  len(oldRevisions) + 1,
+		Body:      chirp.Body,
+		CreatedAt: chirp.UpdatedAt,
+	})
+
+	chirp.Body = body
+	chirp.Entities = parseEntities(body, dbStructure.resolveMention)
+	chirp.UpdatedAt = now
+	chirp.Flagged = flagged
+
+	err := db.commit(
+		putEntry(collectionChirps, chirpID, chirp),
+		putEntry(collectionRevisions, chirpID, revisions),
+	)
+	if err != nil {
+		return Chirp{}, err
+	}
+	return chirp, nil
+}
+
+// GetChirpHistory returns every version of a chirp, oldest first, ending
+// with the current body.
+func (db *DB) GetChirpHistory(chirpID int) ([]ChirpRevision, error) {
+	db.mux.RLock()
+	defer db.mux.RUnlock()
+
+	dbStructure := db.data
+
+	chirp, ok := dbStructure.Chirps[chirpID]
+	if !ok || chirp.Deleted {
+		return nil, ErrChirpNotFound
+	}
+
+	oldRevisions := dbStructure.Revisions[chirpID]
+	revisions := make([]ChirpRevision, len(oldRevisions), len(oldRevisions)+1)
+	copy(revisions, oldRevisions)
+	revisions = append(revisions, ChirpRevision{
+		Revision:  len(oldRevisions) + 1,
+		Body:      chirp.Body,
+		CreatedAt: chirp.UpdatedAt,
+	})
+	return revisions, nil
+}
@@ -0,0 +1,42 @@
+package database
+
+import (
+	"sort"
+	"strings"
+)
+
+func (db *DB) SearchChirps(text string, query ChirpQuery) (ChirpPage, error) {
+	db.mux.RLock()
+	defer db.mux.RUnlock()
+
+	return searchChirps(&db.data, text, query)
+}
+
+func (dbStructure *DBStructure) postings(term string, prefix bool) (map[int][]int, error) {
+	if !prefix {
+		return dbStructure.idx.terms[term], nil
+	}
+
+	merged := make(map[int][]int)
+	vocab := dbStructure.idx.vocab
+	for i := sort.SearchStrings(vocab, term); i < len(vocab) && strings.HasPrefix(vocab[i], term); i++ {
+		for id, positions := range dbStructure.idx.terms[vocab[i]] {
+			merged[id] = append(merged[id], positions...)
+		}
+	}
+	return merged, nil
+}
+
+func (dbStructure *DBStructure) chirpCount() (int, error) {
+	return len(dbStructure.idx.chirpIDs), nil
+}
+
+func (dbStructure *DBStructure) chirpsByID(ids []int) (map[int]Chirp, error) {
+	chirps := make(map[int]Chirp, len(ids))
+	for _, id := range ids {
+		if chirp, ok := dbStructure.Chirps[id]; ok {
+			chirps[id] = chirp
+		}
+	}
+	return chirps, nil
+}
@@ -0,0 +1,48 @@
+package database
+
+// Thread is a chirp together with its replies, oldest first. MoreReplies is
+// set on chirps whose replies were cut off by the depth limit.
+type Thread struct {
+	Chirp       Chirp    `json:"chirp"`
+	Replies     []Thread `json:"replies"`
+	MoreReplies bool     `json:"more_replies,omitempty"`
+}
+
+// buildThread assembles the thread below chirp, following replies at most
+// depth levels down.
+func buildThread(chirp Chirp, depth int, replies func(parentID int) []Chirp) Thread {
+	thread := Thread{Chirp: chirp, Replies: []Thread{}}
+	children := replies(chirp.ID)
+	if depth <= 0 {
+		thread.MoreReplies = len(children) > 0
+		return thread
+	}
+	for _, child := range children {
+		thread.Replies = append(thread.Replies, buildThread(child, depth-1, replies))
+	}
+	return thread
+}
+
+// GetThread returns the whole conversation chirpID belongs to, starting
+// from its root.
+func (db *DB) GetThread(chirpID int, depth int) (Thread, error) {
+	db.mux.RLock()
+	defer db.mux.RUnlock()
+
+	dbStructure := db.data
+
+	chirp, ok := dbStructure.Chirps[chirpID]
+	if !ok {
+		return Thread{}, ErrChirpNotFound
+	}
+
+	replies := func(parentID int) []Chirp {
+		ids := dbStructure.idx.repliesByParent[parentID]
+		chirps := make([]Chirp, 0, len(ids))
+		for _, id := range ids {
+			chirps = append(chirps, dbStructure.Chirps[id])
+		}
+		return chirps
+	}
+	return buildThread(dbStructure.Chirps[chirp.RootID], depth, replies), nil
+}
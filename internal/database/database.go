@@ -2,48 +2,407 @@ package database
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
 	"os"
+	"path/filepath"
 	"sync"
+	"time"
 )
 
 type DBStructure struct {
-	Chirps map[int]Chirp `json:"chirps"`
-	Users  map[int]User  `json:"users"`
+	SchemaVersion int                     `json:"schema_version"`
+	JournalSeq    int                     `json:"journal_seq"`
+	Sequences     map[string]int          `json:"sequences"`
+	Chirps        map[int]Chirp           `json:"chirps"`
+	Users         map[int]User            `json:"users"`
+	Revisions     map[int][]ChirpRevision `json:"revisions"`
+	Likes         map[int][]Like          `json:"likes"`
+	Follows       map[int]Follow          `json:"follows"`
+	Media         map[int]Media           `json:"media"`
+	Drafts        map[int]Draft           `json:"drafts"`
+	Bookmarks     map[int][]Bookmark      `json:"bookmarks"`
+	Sessions      map[int]Session         `json:"sessions"`
+	RefreshTokens map[int]RefreshToken    `json:"refresh_tokens"`
+	TOTP          map[int]TOTP            `json:"totp"`
+	MFAChallenges map[int]MFAChallenge    `json:"mfa_challenges"`
+	EmailTokens   map[int]EmailToken      `json:"email_tokens"`
+
+	idx *indexes
+
+	// tokenKey is the key of the DB that loaded the structure, for the
+	// migrations that seal secrets with it. It is never written out.
+	tokenKey []byte
 }
 
 type DB struct {
 	path string
 	mux  *sync.RWMutex
+	data DBStructure
+
+	flushInterval time.Duration
+	timelineMode  TimelineMode
+	tokenKey      []byte
+	flushMux      *sync.Mutex
+	flushedSeq    int
+	done          chan struct{}
+	stopped       chan struct{}
 }
 
-func NewDB(path string) (*DB, error) {
+// NewDB loads the database at path into memory. Mutations are journaled
+// immediately; the snapshot is rewritten on every mutation when
+// flushInterval is zero and at most once per flushInterval otherwise.
+// tokenKey keys the hashes refresh tokens and other secrets are stored as
+// and signs emailed tokens; changing it invalidates every session.
+func NewDB(path string, flushInterval time.Duration, timelineMode TimelineMode, tokenKey []byte) (*DB, error) {
 	db := &DB{
-		path: path,
-		mux:  &sync.RWMutex{},
+		path:          path,
+		mux:           &sync.RWMutex{},
+		flushInterval: flushInterval,
+		timelineMode:  timelineMode,
+		tokenKey:      tokenKey,
+		flushMux:      &sync.Mutex{},
+		done:          make(chan struct{}),
+		stopped:       make(chan struct{}),
 	}
 	if err := db.ensureDB(); err != nil {
 		return nil, err
 	}
+	dbStructure, err := db.recoverDB()
+	if err != nil {
+		return nil, err
+	}
+	if timelineMode == FanOutOnWrite {
+		dbStructure.idx.buildTimelines()
+	}
+	db.data = dbStructure
+	db.flushedSeq = dbStructure.JournalSeq
+
+	if flushInterval > 0 {
+		go db.flushLoop()
+	} else {
+		close(db.stopped)
+	}
 	return db, nil
 }
 
+func (db *DB) Close() error {
+	close(db.done)
+	<-db.stopped
+	return db.flush()
+}
+
+// DeleteDB removes the database file at path together with the files the
+// JSON and SQLite backends keep next to it.
+func DeleteDB(path string) error {
+	err := os.Remove(path)
+	for _, suffix := range []string{journalSuffix, "-wal", "-shm"} {
+		os.Remove(path + suffix)
+	}
+	return err
+}
+
+func newDBStructure() DBStructure {
+	dbStructure := DBStructure{
+		SchemaVersion: latestSchemaVersion(),
+		Sequences:     make(map[string]int),
+		Chirps:        make(map[int]Chirp),
+		Users:         make(map[int]User),
+		Revisions:     make(map[int][]ChirpRevision),
+		Likes:         make(map[int][]Like),
+		Follows:       make(map[int]Follow),
+		Media:         make(map[int]Media),
+		Drafts:        make(map[int]Draft),
+		Bookmarks:     make(map[int][]Bookmark),
+		Sessions:      make(map[int]Session),
+		RefreshTokens: make(map[int]RefreshToken),
+		TOTP:          make(map[int]TOTP),
+		MFAChallenges: make(map[int]MFAChallenge),
+		EmailTokens:   make(map[int]EmailToken),
+	}
+	dbStructure.buildIndexes()
+	return dbStructure
+}
+
+// nextID returns the next unused ID for collection. IDs are never reused,
+// even after the entity holding the highest one is deleted.
+func (dbStructure *DBStructure) nextID(collection string) int {
+	return dbStructure.Sequences[collection] + 1
+}
+
+func (dbStructure *DBStructure) bumpSequence(collection string, id int) {
+	if id > dbStructure.Sequences[collection] {
+		dbStructure.Sequences[collection] = id
+	}
+}
+
+// repairSequences makes sure no sequence is behind the IDs already stored,
+// which is the case for files written before sequences were persisted.
+func (dbStructure *DBStructure) repairSequences() {
+	if dbStructure.Sequences == nil {
+		dbStructure.Sequences = make(map[string]int)
+	}
+	for id := range dbStructure.Chirps {
+		dbStructure.bumpSequence(collectionChirps, id)
+	}
+	for id := range dbStructure.Users {
+		dbStructure.bumpSequence(collectionUsers, id)
+	}
+	for id := range dbStructure.Follows {
+		dbStructure.bumpSequence(collectionFollows, id)
+	}
+	for id := range dbStructure.Media {
+		dbStructure.bumpSequence(collectionMedia, id)
+	}
+	for id := range dbStructure.Drafts {
+		dbStructure.bumpSequence(collectionDrafts, id)
+	}
+	for id := range dbStructure.Sessions {
+		dbStructure.bumpSequence(collectionSessions, id)
+	}
+	for id := range dbStructure.RefreshTokens {
+		dbStructure.bumpSequence(collectionRefreshTokens, id)
+	}
+	for id := range dbStructure.MFAChallenges {
+		dbStructure.bumpSequence(collectionMFAChallenges, id)
+	}
+	for id := range dbStructure.EmailTokens {
+		dbStructure.bumpSequence(collectionEmailTokens, id)
+	}
+}
+
 func (db *DB) ensureDB() error {
 	if _, err := os.Stat(db.path); os.IsNotExist(err) {
-		initialData := DBStructure{
-			Chirps: make(map[int]Chirp),
-			Users:  make(map[int]User),
+		return db.writeDB(newDBStructure())
+	}
+	// Older versions left the files readable by everyone.
+	for _, path := range []string{db.path, db.journalPath()} {
+		if err := os.Chmod(path, 0600); err != nil && !os.IsNotExist(err) {
+			return err
 		}
-		return db.writeDB(initialData)
 	}
 	return nil
 }
 
+// recoverDB loads the snapshot, replays the journal entries that did not make
+// it into it and migrates the result to the current schema. Entries are
+// replayed in the schema they were written in, migrating the data up to it
+// first. If the snapshot itself cannot be parsed, the database is rebuilt
+// from the journal only when the journal still starts at the first entry
+// ever written, before any compaction; otherwise it refuses to start rather
+// than silently drop everything the snapshot held.
+func (db *DB) recoverDB() (DBStructure, error) {
+	entries, err := db.readJournal()
+	if err != nil {
+		return DBStructure{}, err
+	}
+
+	rewrite := false
+	dbStructure, err := db.loadDB()
+	if err != nil {
+		var syntaxErr *json.SyntaxError
+		var typeErr *json.UnmarshalTypeError
+		if !errors.As(err, &syntaxErr) && !errors.As(err, &typeErr) {
+			return DBStructure{}, err
+		}
+		// Journal sequences start at 1 on an empty database, and snapshots
+		// from before the journal start theirs at 2, so only a journal that
+		// starts at 1 holds everything the snapshot did.
+		if len(entries) == 0 || entries[0].Seq != 1 {
+			return DBStructure{}, fmt.Errorf("database snapshot %s is corrupt (%v) and the journal doesn't cover its contents; restore it from a backup", db.path, err)
+		}
+		// Entries from before schema versions were journaled can't be
+		// replayed safely without the snapshot they were written against.
+		if entries[0].Schema == 0 {
+			return DBStructure{}, fmt.Errorf("database snapshot %s is corrupt (%v) and the journal predates schema versions", db.path, err)
+		}
+		dbStructure = newDBStructure()
+		dbStructure.SchemaVersion = entries[0].Schema
+		corruptPath := fmt.Sprintf("%s.corrupt-%d", db.path, time.Now().Unix())
+		log.Printf("database snapshot is corrupt (%v), moving it to %s and rebuilding from journal", err, corruptPath)
+		if err := os.Rename(db.path, corruptPath); err != nil {
+			return DBStructure{}, err
+		}
+		rewrite = true
+	}
+	dbStructure.tokenKey = db.tokenKey
+	dbStructure.buildIndexes()
+
+	logMigrations := func(applied []migration) {
+		for _, m := range applied {
+			log.Printf("applied database migration %d: %s", m.version, m.description)
+			rewrite = true
+		}
+	}
+
+	replayed := 0
+	for _, entry := range entries {
+		if entry.Seq <= dbStructure.JournalSeq {
+			continue
+		}
+		// Entries journaled before schema versions were recorded were
+		// written against a snapshot migrated to the latest schema.
+		schema := entry.Schema
+		if schema == 0 {
+			schema = latestSchemaVersion()
+		}
+		if schema > dbStructure.SchemaVersion {
+			applied, err := dbStructure.migrateTo(schema)
+			logMigrations(applied)
+			if err != nil {
+				return DBStructure{}, err
+			}
+			dbStructure.buildIndexes()
+		}
+		if err := dbStructure.apply(entry); err != nil {
+			return DBStructure{}, fmt.Errorf("replaying journal entry %d: %v", entry.Seq, err)
+		}
+		dbStructure.JournalSeq = entry.Seq
+		replayed++
+	}
+	if replayed > 0 {
+		log.Printf("replayed %d journal entries", replayed)
+		rewrite = true
+	}
+
+	applied, err := dbStructure.migrate()
+	logMigrations(applied)
+	if err != nil {
+		return DBStructure{}, err
+	}
+	if len(applied) > 0 {
+		dbStructure.buildIndexes()
+	}
+
+	if rewrite {
+		if err := db.writeDB(dbStructure); err != nil {
+			return DBStructure{}, err
+		}
+		if err := db.compactJournal(dbStructure.JournalSeq); err != nil {
+			return DBStructure{}, err
+		}
+	}
+	return dbStructure, nil
+}
+
+// commit records entries in the journal and then applies them to the
+// in-memory data, so memory never runs ahead of what can be replayed. It must
+// be called with the write lock held.
+func (db *DB) commit(entries ...journalEntry) error {
+	seq := db.data.JournalSeq
+	for i := range entries {
+		seq++
+		entries[i].Seq = seq
+		entries[i].Schema = db.data.SchemaVersion
+	}
+	if err := db.appendJournal(entries); err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		if err := db.data.apply(entry); err != nil {
+			return err
+		}
+		db.data.JournalSeq = entry.Seq
+	}
+
+	if db.flushInterval > 0 {
+		return nil
+	}
+	if err := db.writeDB(db.data); err != nil {
+		return err
+	}
+	return db.compactJournal(db.data.JournalSeq)
+}
+
+func (db *DB) flushLoop() {
+	defer close(db.stopped)
+	ticker := time.NewTicker(db.flushInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if err := db.flush(); err != nil {
+				log.Printf("error flushing database: %v", err)
+			}
+		case <-db.done:
+			return
+		}
+	}
+}
+
+// flush writes a snapshot if anything has been committed since the last one.
+func (db *DB) flush() error {
+	db.flushMux.Lock()
+	defer db.flushMux.Unlock()
+
+	db.mux.RLock()
+	seq := db.data.JournalSeq
+	if seq == db.flushedSeq {
+		db.mux.RUnlock()
+		return nil
+	}
+	file, err := json.MarshalIndent(db.data, "", "  ")
+	db.mux.RUnlock()
+	if err != nil {
+		return err
+	}
+
+	if err := writeFileAtomic(db.path, file, 0600); err != nil {
+		return err
+	}
+	db.flushedSeq = seq
+
+	db.mux.Lock()
+	defer db.mux.Unlock()
+	return db.compactJournal(seq)
+}
+
 func (db *DB) writeDB(dbStructure DBStructure) error {
 	file, err := json.MarshalIndent(dbStructure, "", "  ")
 	if err != nil {
 		return err
 	}
-	return os.WriteFile(db.path, file, 0644)
+	return writeFileAtomic(db.path, file, 0600)
+}
+
+// writeFileAtomic writes data to a temporary file next to path, syncs it and
+// renames it over path, so readers only ever see the old or the new contents.
+func writeFileAtomic(path string, data []byte, perm os.FileMode) error {
+	dir := filepath.Dir(path)
+	tmp, err := os.CreateTemp(dir, filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err = tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err = tmp.Chmod(perm); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err = tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		return err
+	}
+	if err = os.Rename(tmp.Name(), path); err != nil {
+		return err
+	}
+
+	dirFile, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer dirFile.Close()
+	return dirFile.Sync()
 }
 
 func (db *DB) loadDB() (DBStructure, error) {
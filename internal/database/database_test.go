@@ -0,0 +1,236 @@
+package database
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+var testTokenKey = []byte("0123456789abcdef0123456789abcdef")
+
+func newTestDB(t testing.TB, flushInterval time.Duration) (*DB, string) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "database.json")
+	db, err := NewDB(path, flushInterval, FanOutOnRead, testTokenKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return db, path
+}
+
+// forEachStore runs test against a fresh JSON and SQLite store.
+func forEachStore(t *testing.T, test func(t *testing.T, store Store)) {
+	t.Run("json", func(t *testing.T) {
+		db, _ := newTestDB(t, 0)
+		defer db.Close()
+		test(t, db)
+	})
+	t.Run("sqlite", func(t *testing.T) {
+		db, err := NewSQLiteDB(filepath.Join(t.TempDir(), "database.db"), FanOutOnRead, testTokenKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer db.Close()
+		test(t, db)
+	})
+}
+
+func reopenTestDB(t testing.TB, path string) *DB {
+	t.Helper()
+	db, err := NewDB(path, 0, FanOutOnRead, testTokenKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+func journalSize(t testing.TB, path string) int64 {
+	t.Helper()
+	info, err := os.Stat(path + journalSuffix)
+	if os.IsNotExist(err) {
+		return 0
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return info.Size()
+}
+
+func TestJournalCompactedAfterEachSnapshot(t *testing.T) {
+	db, path := newTestDB(t, 0)
+	if _, err := db.CreateUser("a@example.com", "", []byte("hash")); err != nil {
+		t.Fatal(err)
+	}
+	if size := journalSize(t, path); size != 0 {
+		t.Fatalf("journal holds %d bytes after the snapshot was written", size)
+	}
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	db = reopenTestDB(t, path)
+	defer db.Close()
+	if _, err := db.GetAccount(1); err != nil {
+		t.Fatalf("user lost after reopening: %v", err)
+	}
+}
+
+func TestJournalCompactedAfterFlush(t *testing.T) {
+	db, path := newTestDB(t, time.Hour)
+	for _, email := range []string{"a@example.com", "b@example.com"} {
+		if _, err := db.CreateUser(email, "", []byte("hash")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if journalSize(t, path) == 0 {
+		t.Fatal("journal is empty before the snapshot was written")
+	}
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if size := journalSize(t, path); size != 0 {
+		t.Fatalf("journal holds %d bytes after flushing", size)
+	}
+
+	db = reopenTestDB(t, path)
+	defer db.Close()
+	if _, err := db.GetAccount(2); err != nil {
+		t.Fatalf("user lost after reopening: %v", err)
+	}
+}
+
+func TestCompactJournalKeepsNewerEntries(t *testing.T) {
+	db, path := newTestDB(t, time.Hour)
+	defer db.Close()
+	for _, email := range []string{"a@example.com", "b@example.com"} {
+		if _, err := db.CreateUser(email, "", []byte("hash")); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	db.mux.Lock()
+	err := db.compactJournal(1)
+	db.mux.Unlock()
+	if err != nil {
+		t.Fatal(err)
+	}
+	entries, err := db.readJournal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].Seq != 2 {
+		t.Fatalf("journal at %s holds %+v, want only entry 2", path, entries)
+	}
+}
+
+func TestRecoverReplaysUnflushedEntries(t *testing.T) {
+	db, path := newTestDB(t, time.Hour)
+	if _, err := db.CreateUser("a@example.com", "", []byte("hash")); err != nil {
+		t.Fatal(err)
+	}
+	// Simulate a crash: stop the flush loop without writing a snapshot.
+	close(db.done)
+	<-db.stopped
+
+	db = reopenTestDB(t, path)
+	defer db.Close()
+	if _, err := db.GetAccount(1); err != nil {
+		t.Fatalf("journaled user not replayed: %v", err)
+	}
+	if size := journalSize(t, path); size != 0 {
+		t.Fatalf("journal holds %d bytes after recovery wrote a snapshot", size)
+	}
+}
+
+// writeTestDB writes a snapshot and journal as an older version of the
+// server would have left them.
+func writeTestDB(t testing.TB, snapshot string, entries ...journalEntry) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "database.json")
+	if err := os.WriteFile(path, []byte(snapshot), 0600); err != nil {
+		t.Fatal(err)
+	}
+	journal := []byte{}
+	for _, entry := range entries {
+		line, err := json.Marshal(entry)
+		if err != nil {
+			t.Fatal(err)
+		}
+		journal = append(append(journal, line...), '\n')
+	}
+	if err := os.WriteFile(path+journalSuffix, journal, 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// schema5Chirp is a chirp as schema 5 stored it, before chirps had a kind.
+func schema5Chirp(seq int) journalEntry {
+	entry := putEntry(collectionChirps, 1, map[string]any{"id": 1, "body": "hello", "author_id": 1, "root_id": 1})
+	entry.Seq = seq
+	entry.Schema = 5
+	return entry
+}
+
+func TestRecoverMigratesReplayedEntries(t *testing.T) {
+	snapshot := `{"schema_version": 5, "journal_seq": 0, "sequences": {}, "chirps": {}, "users": {}, "revisions": {}, "likes": {}}`
+	path := writeTestDB(t, snapshot, schema5Chirp(1))
+
+	db := reopenTestDB(t, path)
+	defer db.Close()
+	chirp, err := db.GetChirpByID(1)
+	if err != nil {
+		t.Fatalf("journaled chirp not replayed: %v", err)
+	}
+	if chirp.Kind != ChirpKindChirp {
+		t.Fatalf("replayed chirp has kind %q, want %q", chirp.Kind, ChirpKindChirp)
+	}
+}
+
+func TestRecoverCorruptSnapshotFromJournal(t *testing.T) {
+	path := writeTestDB(t, `{"schema_version": `, schema5Chirp(1))
+
+	db := reopenTestDB(t, path)
+	defer db.Close()
+	chirp, err := db.GetChirpByID(1)
+	if err != nil {
+		t.Fatalf("journaled chirp not replayed: %v", err)
+	}
+	if chirp.Kind != ChirpKindChirp {
+		t.Fatalf("replayed chirp has kind %q, want %q", chirp.Kind, ChirpKindChirp)
+	}
+	if matches, _ := filepath.Glob(path + ".corrupt-*"); len(matches) != 1 {
+		t.Fatalf("corrupt snapshot not moved aside: %v", matches)
+	}
+}
+
+func TestRecoverCorruptSnapshotRefusesLegacyJournal(t *testing.T) {
+	entry := schema5Chirp(1)
+	entry.Schema = 0
+	path := writeTestDB(t, `{"schema_version": `, entry)
+
+	if _, err := NewDB(path, 0, FanOutOnRead, testTokenKey); err == nil {
+		t.Fatal("replayed a journal of unknown schema onto an empty database")
+	}
+	if _, err := os.Stat(path); err != nil {
+		t.Fatalf("corrupt snapshot moved despite failing: %v", err)
+	}
+}
+
+func TestRecoverCorruptSnapshotRefusesCompactedJournal(t *testing.T) {
+	path := writeTestDB(t, `{"schema_version": `, schema5Chirp(2))
+
+	_, err := NewDB(path, 0, FanOutOnRead, testTokenKey)
+	if err == nil {
+		t.Fatal("rebuilt a database from a journal that doesn't start at the first entry")
+	}
+	if !strings.Contains(err.Error(), path) {
+		t.Fatalf("error %q doesn't name the corrupt snapshot", err)
+	}
+	if _, err := os.Stat(path); err != nil {
+		t.Fatalf("corrupt snapshot moved despite failing: %v", err)
+	}
+}
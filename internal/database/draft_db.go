@@ -0,0 +1,231 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"time"
+)
+
+// A Draft is a chirp that has not been published yet. Scheduled drafts are
+// published by PublishDueDrafts once PublishAt has passed; plain drafts
+// wait for their author to publish them. Publishing creates a new chirp,
+// so drafts never show up among chirps.
+type Draft struct {
+	ID         int        `json:"id"`
+	AuthorID   int        `json:"author_id"`
+	Body       string     `json:"body"`
+	ParentID   int        `json:"parent_id,omitempty"`
+	Kind       string     `json:"kind"`
+	OriginalID int        `json:"original_id,omitempty"`
+	MediaIDs   []int      `json:"media_ids"`
+	Flagged    bool       `json:"flagged,omitempty"`
+	Status     string     `json:"status"`
+	PublishAt  *time.Time `json:"publish_at,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+}
+
+const (
+	DraftStatusDraft     = "draft"
+	DraftStatusScheduled = "scheduled"
+)
+
+// A DraftReview checks a draft against the rules chirps are held to as it
+// is published, since they may have changed since it was written. The
+// author is passed as their account, so the review can also check its
+// standing, such as whether their email address is verified. It returns the
+// body to publish and whether to flag it for moderation; an error keeps the
+// draft from being published. It is called with the database locked and
+// must not use the Store.
+type DraftReview func(draft Draft, author AccountResp) (body string, flagged bool, err error)
+
+// reviewed returns the parameters to publish draft with, as review left
+// them.
+func (d Draft) reviewed(author AccountResp, review DraftReview) (ChirpParams, error) {
+	params := d.params()
+	var err error
+	params.Body, params.Flagged, err = review(d, author)
+	if err != nil {
+		return ChirpParams{}, fmt.Errorf("%w: %w", ErrDraftRejected, err)
+	}
+	return params, nil
+}
+
+func (d Draft) params() ChirpParams {
+	return ChirpParams{
+		Body:       d.Body,
+		AuthorID:   d.AuthorID,
+		ParentID:   d.ParentID,
+		Kind:       d.Kind,
+		OriginalID: d.OriginalID,
+		MediaIDs:   d.MediaIDs,
+		Flagged:    d.Flagged,
+	}
+}
+
+// newDraft validates params for a draft and builds it. A zero publishAt
+// makes a plain draft; rechirps have nothing to draft and are refused.
+func newDraft(params ChirpParams, publishAt time.Time) (Draft, error) {
+	if err := params.validate(); err != nil {
+		return Draft{}, err
+	}
+	if params.Kind == ChirpKindRechirp {
+		return Draft{}, ErrInvalidChirpKind
+	}
+
+	now := time.Now().UTC()
+	draft := Draft{
+		AuthorID:   params.AuthorID,
+		Body:       params.Body,
+		ParentID:   params.ParentID,
+		Kind:       params.Kind,
+		OriginalID: params.OriginalID,
+		MediaIDs:   params.MediaIDs,
+		Flagged:    params.Flagged,
+		Status:     DraftStatusDraft,
+		CreatedAt:  now,
+	}
+	if draft.MediaIDs == nil {
+		draft.MediaIDs = []int{}
+	}
+	if !publishAt.IsZero() {
+		if !publishAt.After(now) {
+			return Draft{}, ErrPublishAtInPast
+		}
+		publishAt = publishAt.UTC()
+		draft.Status = DraftStatusScheduled
+		draft.PublishAt = &publishAt
+	}
+	return draft, nil
+}
+
+func (db *DB) CreateDraft(params ChirpParams, publishAt time.Time) (Draft, error) {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+
+	dbStructure := db.data
+
+	draft, err := newDraft(params, publishAt)
+	if err != nil {
+		return Draft{}, err
+	}
+	if _, err := dbStructure.chirpMedia(draft.MediaIDs, draft.AuthorID); err != nil {
+		return Draft{}, err
+	}
+	if draft.ParentID != 0 {
+		if _, ok := dbStructure.sharedChirp(draft.ParentID); !ok {
+			return Draft{}, ErrParentNotFound
+		}
+	}
+	if draft.OriginalID != 0 {
+		if _, ok := dbStructure.sharedChirp(draft.OriginalID); !ok {
+			return Draft{}, ErrOriginalNotFound
+		}
+	}
+
+	draft.ID = dbStructure.nextID(collectionDrafts)
+	if err := db.commit(putEntry(collectionDrafts, draft.ID, draft)); err != nil {
+		return Draft{}, err
+	}
+	return draft, nil
+}
+
+// GetDrafts returns the drafts of authorID, oldest first.
+func (db *DB) GetDrafts(authorID int) ([]Draft, error) {
+	db.mux.RLock()
+	defer db.mux.RUnlock()
+
+	drafts := []Draft{}
+	for _, id := range db.data.idx.draftsByAuthor[authorID] {
+		drafts = append(drafts, db.data.Drafts[id])
+	}
+	return drafts, nil
+}
+
+func (db *DB) DeleteDraft(draftID int, authorID int) error {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+
+	draft, ok := db.data.Drafts[draftID]
+	if !ok || draft.AuthorID != authorID {
+		return ErrDraftNotFound
+	}
+	return db.commit(deleteEntry(collectionDrafts, draftID))
+}
+
+func (db *DB) PublishDraft(draftID int, authorID int, review DraftReview) (Chirp, error) {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+
+	draft, ok := db.data.Drafts[draftID]
+	if !ok || draft.AuthorID != authorID {
+		return Chirp{}, ErrDraftNotFound
+	}
+	return db.publishDraft(draft, review)
+}
+
+// PublishDueDrafts publishes every scheduled draft whose time has come by
+// now, in the order they were scheduled for. A draft that can no longer be
+// published, say because the chirp it replies to was deleted, is turned
+// back into a plain draft so its author can deal with it.
+func (db *DB) PublishDueDrafts(now time.Time, review DraftReview) ([]Chirp, error) {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+
+	due := []Draft{}
+	for _, scheduled := range db.data.idx.scheduledDrafts {
+		if scheduled.at.After(now) {
+			break
+		}
+		due = append(due, db.data.Drafts[scheduled.id])
+	}
+
+	published := []Chirp{}
+	for _, draft := range due {
+		chirp, err := db.publishDraft(draft, review)
+		if err == nil {
+			published = append(published, chirp)
+			continue
+		}
+		if !isChirpValidationError(err) {
+			return published, err
+		}
+		log.Printf("could not publish scheduled draft %d: %v", draft.ID, err)
+		draft.Status = DraftStatusDraft
+		draft.PublishAt = nil
+		if err := db.commit(putEntry(collectionDrafts, draft.ID, draft)); err != nil {
+			return published, err
+		}
+	}
+	return published, nil
+}
+
+func (db *DB) publishDraft(draft Draft, review DraftReview) (Chirp, error) {
+	params, err := draft.reviewed(db.data.Users[draft.AuthorID].account(), review)
+	if err != nil {
+		return Chirp{}, err
+	}
+	chirp, entries, err := db.data.newChirp(params)
+	if err != nil {
+		return Chirp{}, err
+	}
+	entries = append(entries, deleteEntry(collectionDrafts, draft.ID))
+	if err := db.commit(entries...); err != nil {
+		return Chirp{}, err
+	}
+	return chirp, nil
+}
+
+// isChirpValidationError reports whether err means the chirp itself can't
+// be created, as opposed to the database failing.
+func isChirpValidationError(err error) bool {
+	for _, target := range []error{
+		ErrParentNotFound, ErrOriginalNotFound, ErrInvalidChirpKind,
+		ErrMediaNotFound, ErrTooManyMedia, ErrDuplicateMedia, ErrDraftRejected,
+	} {
+		if errors.Is(err, target) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,113 @@
+package database
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func acceptDraft(draft Draft, author AccountResp) (string, bool, error) {
+	return draft.Body, false, nil
+}
+
+func TestPublishDueDraftsInScheduledOrder(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store Store) {
+		user, err := store.CreateUser("a@example.com", "", []byte("hash"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		now := time.Now()
+		for _, d := range []struct {
+			body string
+			in   time.Duration
+		}{
+			{"third", 3 * time.Hour},
+			{"first", time.Hour},
+			{"plain", 0},
+			{"second", 2 * time.Hour},
+			{"later", 48 * time.Hour},
+		} {
+			publishAt := time.Time{}
+			if d.in != 0 {
+				publishAt = now.Add(d.in)
+			}
+			if _, err := store.CreateDraft(ChirpParams{Body: d.body, AuthorID: user.ID}, publishAt); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		published, err := store.PublishDueDrafts(now.Add(4*time.Hour), acceptDraft)
+		if err != nil {
+			t.Fatal(err)
+		}
+		bodies := []string{}
+		for _, chirp := range published {
+			bodies = append(bodies, chirp.Body)
+		}
+		if got := strings.Join(bodies, ","); got != "first,second,third" {
+			t.Fatalf("published %s, want first,second,third", got)
+		}
+
+		drafts, err := store.GetDrafts(user.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(drafts) != 2 || drafts[0].Body != "plain" || drafts[1].Body != "later" {
+			t.Fatalf("drafts left: %+v", drafts)
+		}
+	})
+}
+
+func TestPublishDraftIsReviewed(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store Store) {
+		user, err := store.CreateUser("a@example.com", "", []byte("hash"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		now := time.Now()
+		scheduled, err := store.CreateDraft(ChirpParams{Body: "too long", AuthorID: user.ID}, now.Add(time.Hour))
+		if err != nil {
+			t.Fatal(err)
+		}
+		plain, err := store.CreateDraft(ChirpParams{Body: "darn it", AuthorID: user.ID}, time.Time{})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		reject := func(draft Draft, author AccountResp) (string, bool, error) {
+			return "", false, errors.New("chirp is too long")
+		}
+		published, err := store.PublishDueDrafts(now.Add(2*time.Hour), reject)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(published) != 0 {
+			t.Fatalf("published %d rejected drafts", len(published))
+		}
+		drafts, err := store.GetDrafts(user.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if drafts[0].ID != scheduled.ID || drafts[0].Status != DraftStatusDraft || drafts[0].PublishAt != nil {
+			t.Fatalf("rejected scheduled draft left as %+v, want a plain draft", drafts[0])
+		}
+
+		if _, err := store.PublishDraft(plain.ID, user.ID, reject); !errors.Is(err, ErrDraftRejected) {
+			t.Fatalf("PublishDraft with a rejecting review returned %v, want ErrDraftRejected", err)
+		}
+		mask := func(draft Draft, author AccountResp) (string, bool, error) {
+			if author.ID != user.ID || author.Email != user.Email {
+				t.Errorf("reviewed with author %d <%s>, want %d <%s>", author.ID, author.Email, user.ID, user.Email)
+			}
+			return strings.Replace(draft.Body, "darn", "****", 1), true, nil
+		}
+		chirp, err := store.PublishDraft(plain.ID, user.ID, mask)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if chirp.Body != "**** it" || !chirp.Flagged {
+			t.Fatalf("published %q flagged=%v, want the reviewed body, flagged", chirp.Body, chirp.Flagged)
+		}
+	})
+}
@@ -0,0 +1,231 @@
+package database
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	PurposePasswordReset     = "password_reset"
+	PurposeEmailVerification = "email_verification"
+
+	PasswordResetTokenTTL     = time.Hour
+	EmailVerificationTokenTTL = 24 * time.Hour
+)
+
+// An EmailToken is a single-use token mailed to a user, to reset their
+// password or verify their email address. It is only good for the address
+// it was sent to, and a new token of the same purpose replaces the old one.
+type EmailToken struct {
+	ID        int       `json:"id"`
+	UserID    int       `json:"user_id"`
+	Purpose   string    `json:"purpose"`
+	Email     string    `json:"email"`
+	TokenHash string    `json:"token_hash"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// signEmailToken returns a token holding the user ID, the expiry and a
+// random nonce, signed with key for purpose. The signature lets forged and
+// expired tokens be refused before the database is consulted.
+func signEmailToken(key []byte, purpose string, userID int, expiresAt time.Time) (string, error) {
+	nonce := make([]byte, 16)
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	payload := fmt.Sprintf("%d.%d.%s", userID, expiresAt.Unix(), hex.EncodeToString(nonce))
+	return payload + "." + emailTokenSignature(key, purpose, payload), nil
+}
+
+func emailTokenSignature(key []byte, purpose string, payload string) string {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte("email-token:" + purpose + ":" + payload))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// verifyEmailToken checks the signature and expiry of token and returns
+// the user ID it was issued to.
+func verifyEmailToken(key []byte, purpose string, token string, now time.Time) (int, bool) {
+	i := strings.LastIndexByte(token, '.')
+	if i < 0 {
+		return 0, false
+	}
+	payload, signature := token[:i], token[i+1:]
+	expected := emailTokenSignature(key, purpose, payload)
+	if subtle.ConstantTimeCompare([]byte(signature), []byte(expected)) != 1 {
+		return 0, false
+	}
+
+	parts := strings.Split(payload, ".")
+	if len(parts) != 3 {
+		return 0, false
+	}
+	userID, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return 0, false
+	}
+	expiresAt, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil || now.Unix() >= expiresAt {
+		return 0, false
+	}
+	return userID, true
+}
+
+// newEmailToken returns a token for purpose and the entries that store it
+// in place of the user's previous tokens for the same purpose.
+func (dbStructure *DBStructure) newEmailToken(user User, purpose string, ttl time.Duration, tokenKey []byte) (string, []journalEntry, error) {
+	expiresAt := time.Now().UTC().Add(ttl)
+	token, err := signEmailToken(tokenKey, purpose, user.ID, expiresAt)
+	if err != nil {
+		return "", nil, err
+	}
+
+	entries := dbStructure.deleteEmailTokens(user.ID, purpose)
+	emailToken := EmailToken{
+		ID:        dbStructure.nextID(collectionEmailTokens),
+		UserID:    user.ID,
+		Purpose:   purpose,
+		Email:     user.Email,
+		TokenHash: hashToken(tokenKey, token),
+		ExpiresAt: expiresAt,
+	}
+	entries = append(entries, putEntry(collectionEmailTokens, emailToken.ID, emailToken))
+	return token, entries, nil
+}
+
+// deleteEmailTokens returns the entries that delete the user's tokens for
+// purpose, along with any of their tokens that have expired.
+func (dbStructure *DBStructure) deleteEmailTokens(userID int, purpose string) []journalEntry {
+	now := time.Now()
+	entries := []journalEntry{}
+	for _, id := range dbStructure.idx.emailTokensByUser[userID] {
+		emailToken := dbStructure.EmailTokens[id]
+		if emailToken.Purpose == purpose || !emailToken.ExpiresAt.After(now) {
+			entries = append(entries, deleteEntry(collectionEmailTokens, id))
+		}
+	}
+	return entries
+}
+
+// userByEmailToken returns the user a token was mailed to for purpose, if
+// the token is still good.
+func (dbStructure *DBStructure) userByEmailToken(token string, purpose string, tokenKey []byte) (User, error) {
+	userID, ok := verifyEmailToken(tokenKey, purpose, token, time.Now())
+	if !ok {
+		return User{}, ErrInvalidEmailToken
+	}
+	id, ok := dbStructure.idx.emailTokenByHash[hashToken(tokenKey, token)]
+	if !ok {
+		return User{}, ErrInvalidEmailToken
+	}
+	emailToken := dbStructure.EmailTokens[id]
+	user, ok := dbStructure.Users[emailToken.UserID]
+	if !ok || emailToken.UserID != userID || emailToken.Purpose != purpose || emailToken.Email != user.Email {
+		return User{}, ErrInvalidEmailToken
+	}
+	return user, nil
+}
+
+// CreatePasswordResetToken returns a token to mail to the user with email,
+// to reset their password.
+func (db *DB) CreatePasswordResetToken(email string) (string, AccountResp, error) {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+
+	dbStructure := db.data
+
+	ok, user := dbStructure.userExists(email)
+	if !ok {
+		return "", AccountResp{}, ErrUserNotFound
+	}
+	token, entries, err := dbStructure.newEmailToken(user, PurposePasswordReset, PasswordResetTokenTTL, db.tokenKey)
+	if err != nil {
+		return "", AccountResp{}, err
+	}
+	if err := db.commit(entries...); err != nil {
+		return "", AccountResp{}, err
+	}
+	return token, user.account(), nil
+}
+
+// ResetPassword sets the password of the user token was mailed to and ends
+// all of their sessions. Having received the token also verifies their
+// email address.
+func (db *DB) ResetPassword(token string, password []byte) error {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+
+	dbStructure := db.data
+
+	user, err := dbStructure.userByEmailToken(token, PurposePasswordReset, db.tokenKey)
+	if err != nil {
+		return err
+	}
+
+	user.Password = password
+	user.EmailVerified = true
+	entries := dbStructure.deleteEmailTokens(user.ID, PurposePasswordReset)
+	for _, id := range dbStructure.idx.sessionsByUser[user.ID] {
+		entries = append(entries, dbStructure.revokeSession(id)...)
+	}
+	for id, challenge := range dbStructure.MFAChallenges {
+		if challenge.UserID == user.ID {
+			entries = append(entries, deleteEntry(collectionMFAChallenges, id))
+		}
+	}
+	entries = append(entries, putEntry(collectionUsers, user.ID, user))
+	return db.commit(entries...)
+}
+
+// CreateEmailVerificationToken returns a token to mail to the user's
+// current email address, to verify it.
+func (db *DB) CreateEmailVerificationToken(userID int) (string, AccountResp, error) {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+
+	dbStructure := db.data
+
+	user, ok := dbStructure.Users[userID]
+	if !ok {
+		return "", AccountResp{}, ErrUserNotFound
+	}
+	if user.EmailVerified {
+		return "", AccountResp{}, ErrEmailAlreadyVerified
+	}
+	token, entries, err := dbStructure.newEmailToken(user, PurposeEmailVerification, EmailVerificationTokenTTL, db.tokenKey)
+	if err != nil {
+		return "", AccountResp{}, err
+	}
+	if err := db.commit(entries...); err != nil {
+		return "", AccountResp{}, err
+	}
+	return token, user.account(), nil
+}
+
+func (db *DB) VerifyEmail(token string) (AccountResp, error) {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+
+	dbStructure := db.data
+
+	user, err := dbStructure.userByEmailToken(token, PurposeEmailVerification, db.tokenKey)
+	if err != nil {
+		return AccountResp{}, err
+	}
+
+	user.EmailVerified = true
+	entries := dbStructure.deleteEmailTokens(user.ID, PurposeEmailVerification)
+	entries = append(entries, putEntry(collectionUsers, user.ID, user))
+	if err := db.commit(entries...); err != nil {
+		return AccountResp{}, err
+	}
+	return user.account(), nil
+}
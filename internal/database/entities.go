@@ -0,0 +1,118 @@
+package database
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+type ChirpEntities struct {
+	Hashtags []Hashtag `json:"hashtags"`
+	Mentions []Mention `json:"mentions"`
+}
+
+// Hashtag and Mention offsets are byte offsets into the chirp body; End is
+// exclusive and both include the leading # or @.
+type Hashtag struct {
+	Tag   string `json:"tag"`
+	Start int    `json:"start"`
+	End   int    `json:"end"`
+}
+
+type Mention struct {
+	Name   string `json:"name"`
+	UserID int    `json:"user_id,omitempty"`
+	Start  int    `json:"start"`
+	End    int    `json:"end"`
+}
+
+// entityRegex matches a # or @ that starts a word, so "a@b.com" and "c#"
+// are not picked up.
+var entityRegex = regexp.MustCompile(`(?:^|[^\p{L}\p{N}_])([#@])([\p{L}\p{N}_]+)`)
+
+// parseEntities extracts hashtags and mentions from body. resolve maps a
+// mentioned name to a user ID and reports whether one was found.
+func parseEntities(body string, resolve func(name string) (int, bool)) ChirpEntities {
+	entities := ChirpEntities{Hashtags: []Hashtag{}, Mentions: []Mention{}}
+	for _, m := range entityRegex.FindAllStringSubmatchIndex(body, -1) {
+		start, end := m[2], m[5]
+		name := body[m[4]:m[5]]
+		if body[start] == '#' {
+			entities.Hashtags = append(entities.Hashtags, Hashtag{Tag: name, Start: start, End: end})
+			continue
+		}
+		mention := Mention{Name: name, Start: start, End: end}
+		if id, ok := resolve(name); ok {
+			mention.UserID = id
+		}
+		entities.Mentions = append(entities.Mentions, mention)
+	}
+	return entities
+}
+
+func normalizeTag(tag string) string {
+	return strings.ToLower(tag)
+}
+
+func (e ChirpEntities) hasHashtag(tag string) bool {
+	for _, hashtag := range e.Hashtags {
+		if normalizeTag(hashtag.Tag) == tag {
+			return true
+		}
+	}
+	return false
+}
+
+func (e ChirpEntities) mentions(userID int) bool {
+	for _, mention := range e.Mentions {
+		if mention.UserID == userID {
+			return true
+		}
+	}
+	return false
+}
+
+func (e ChirpEntities) Value() (driver.Value, error) {
+	data, err := json.Marshal(e)
+	if err != nil {
+		return nil, err
+	}
+	return string(data), nil
+}
+
+func (e *ChirpEntities) Scan(src any) error {
+	switch v := src.(type) {
+	case string:
+		return json.Unmarshal([]byte(v), e)
+	case []byte:
+		return json.Unmarshal(v, e)
+	default:
+		return errors.New("unsupported type for chirp entities")
+	}
+}
+
+// chirpHashtags returns the distinct normalized tags of chirp.
+func chirpHashtags(chirp Chirp) []string {
+	tags := []string{}
+	for _, hashtag := range chirp.Entities.Hashtags {
+		tag := normalizeTag(hashtag.Tag)
+		if !slices.Contains(tags, tag) {
+			tags = append(tags, tag)
+		}
+	}
+	return tags
+}
+
+// chirpMentions returns the distinct IDs of the users chirp mentions.
+func chirpMentions(chirp Chirp) []int {
+	ids := []int{}
+	for _, mention := range chirp.Entities.Mentions {
+		if mention.UserID != 0 && !slices.Contains(ids, mention.UserID) {
+			ids = append(ids, mention.UserID)
+		}
+	}
+	return ids
+}
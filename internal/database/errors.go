@@ -0,0 +1,41 @@
+package database
+
+import "errors"
+
+var (
+	ErrChirpNotFound        = errors.New("chirp not found")
+	ErrAccessDenied         = errors.New("access denied")
+	ErrEditWindowExpired    = errors.New("edit window has expired")
+	ErrParentNotFound       = errors.New("parent chirp not found")
+	ErrOriginalNotFound     = errors.New("original chirp not found")
+	ErrInvalidChirpKind     = errors.New("invalid chirp kind")
+	ErrAlreadyRechirped     = errors.New("chirp already rechirped")
+	ErrRechirpNotEditable   = errors.New("rechirps cannot be edited")
+	ErrUserNotFound         = errors.New("user doesn't exist")
+	ErrCannotFollowSelf     = errors.New("users cannot follow themselves")
+	ErrInvalidHandle        = errors.New("handle must be 3 to 15 letters, digits or underscores")
+	ErrHandleReserved       = errors.New("handle is reserved")
+	ErrHandleTaken          = errors.New("handle is already taken")
+	ErrEmailTaken           = errors.New("email is already taken")
+	ErrDisplayNameTooLong   = errors.New("display name is too long")
+	ErrBioTooLong           = errors.New("bio is too long")
+	ErrInvalidAvatarURL     = errors.New("avatar URL must be an http or https URL or an uploaded media URL")
+	ErrMediaNotFound        = errors.New("media not found")
+	ErrTooManyMedia         = errors.New("a chirp can have at most 4 media attachments")
+	ErrDuplicateMedia       = errors.New("media is attached more than once")
+	ErrDraftNotFound        = errors.New("draft not found")
+	ErrPublishAtInPast      = errors.New("publish time must be in the future")
+	ErrDraftRejected        = errors.New("draft can't be published")
+	ErrSessionNotFound      = errors.New("session not found")
+	ErrRefreshTokenNotFound = errors.New("refresh token not found")
+	ErrRefreshTokenExpired  = errors.New("expired refresh token")
+	ErrRefreshTokenReused   = errors.New("refresh token was already used; the session has been revoked")
+	ErrTOTPAlreadyEnabled   = errors.New("two-factor authentication is already enabled")
+	ErrTOTPNotEnrolled      = errors.New("two-factor authentication enrollment has not been started")
+	ErrTOTPNotEnabled       = errors.New("two-factor authentication is not enabled")
+	ErrInvalidMFACode       = errors.New("invalid authentication code")
+	ErrMFAChallengeNotFound = errors.New("invalid or expired MFA token")
+	ErrMFALocked            = errors.New("too many wrong authentication codes, try again later")
+	ErrInvalidEmailToken    = errors.New("invalid or expired token")
+	ErrEmailAlreadyVerified = errors.New("email address is already verified")
+)
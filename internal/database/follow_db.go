@@ -0,0 +1,102 @@
+package database
+
+import (
+	"slices"
+	"time"
+)
+
+type Follow struct {
+	ID         int       `json:"id"`
+	FollowerID int       `json:"follower_id"`
+	FolloweeID int       `json:"followee_id"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+type followPair struct {
+	followerID int
+	followeeID int
+}
+
+// FollowUser makes followerID follow followeeID. Following someone twice is
+// not an error.
+func (db *DB) FollowUser(followerID int, followeeID int) error {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+
+	dbStructure := db.data
+
+	if followerID == followeeID {
+		return ErrCannotFollowSelf
+	}
+	if _, ok := dbStructure.Users[followeeID]; !ok {
+		return ErrUserNotFound
+	}
+	if _, ok := dbStructure.idx.followByPair[followPair{followerID, followeeID}]; ok {
+		return nil
+	}
+
+	newID := dbStructure.nextID(collectionFollows)
+	follow := Follow{
+		ID:         newID,
+		FollowerID: followerID,
+		FolloweeID: followeeID,
+		CreatedAt:  time.Now().UTC(),
+	}
+	return db.commit(putEntry(collectionFollows, newID, follow))
+}
+
+func (db *DB) UnfollowUser(followerID int, followeeID int) error {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+
+	dbStructure := db.data
+
+	if _, ok := dbStructure.Users[followeeID]; !ok {
+		return ErrUserNotFound
+	}
+	id, ok := dbStructure.idx.followByPair[followPair{followerID, followeeID}]
+	if !ok {
+		return nil
+	}
+	return db.commit(deleteEntry(collectionFollows, id))
+}
+
+// GetFollowers returns the follows pointing at userID, oldest first.
+func (db *DB) GetFollowers(userID int) ([]Follow, error) {
+	db.mux.RLock()
+	defer db.mux.RUnlock()
+
+	dbStructure := db.data
+
+	if _, ok := dbStructure.Users[userID]; !ok {
+		return nil, ErrUserNotFound
+	}
+	follows := []Follow{}
+	for _, followerID := range dbStructure.idx.followers[userID] {
+		follows = append(follows, dbStructure.Follows[dbStructure.idx.followByPair[followPair{followerID, userID}]])
+	}
+	sortFollows(follows)
+	return follows, nil
+}
+
+// GetFollowing returns the follows made by userID, oldest first.
+func (db *DB) GetFollowing(userID int) ([]Follow, error) {
+	db.mux.RLock()
+	defer db.mux.RUnlock()
+
+	dbStructure := db.data
+
+	if _, ok := dbStructure.Users[userID]; !ok {
+		return nil, ErrUserNotFound
+	}
+	follows := []Follow{}
+	for _, followeeID := range dbStructure.idx.following[userID] {
+		follows = append(follows, dbStructure.Follows[dbStructure.idx.followByPair[followPair{userID, followeeID}]])
+	}
+	sortFollows(follows)
+	return follows, nil
+}
+
+func sortFollows(follows []Follow) {
+	slices.SortFunc(follows, func(a, b Follow) int { return a.ID - b.ID })
+}
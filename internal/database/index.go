@@ -0,0 +1,523 @@
+package database
+
+import (
+	"sort"
+	"strings"
+	"time"
+)
+
+// indexes are in-memory lookup tables over DBStructure. They are not
+// persisted; buildIndexes recreates them on load and apply keeps them
+// current on every mutation.
+type indexes struct {
+	userByEmail        map[string]int
+	userByHandle       map[string]int
+	chirpIDs           []int
+	chirpsByAuthor     map[int][]int
+	chirpsByHashtag    map[string][]int
+	chirpsByMention    map[int][]int
+	repliesByParent    map[int][]int
+	rechirpsByOriginal map[int][]int
+	chirpsLikedBy      map[int][]int
+	chirpsBookmarkedBy map[int][]int
+
+	followByPair map[followPair]int
+	following    map[int][]int
+	followers    map[int][]int
+
+	mediaByOwner map[int][]int
+
+	draftsByAuthor map[int][]int
+	// scheduledDrafts holds the scheduled drafts in the order they are due.
+	scheduledDrafts []timedID
+
+	sessionsByUser map[int][]int
+	// sessionsByExpiry holds the sessions in the order they expire.
+	sessionsByExpiry       []timedID
+	refreshTokenByHash     map[string]int
+	refreshTokensBySession map[int][]int
+	mfaChallengeByHash     map[string]int
+	emailTokenByHash       map[string]int
+	emailTokensByUser      map[int][]int
+
+	// timelines holds the chirp IDs on each user's home timeline. It is only
+	// built when timelines are fanned out on write and nil otherwise.
+	timelines map[int][]int
+
+	// terms maps each search term to the positions it occurs at in each
+	// chirp; vocab holds the same terms sorted for prefix lookups.
+	terms map[string]map[int][]int
+	vocab []string
+}
+
+func (dbStructure *DBStructure) buildIndexes() {
+	dbStructure.idx = &indexes{
+		userByEmail:            make(map[string]int),
+		userByHandle:           make(map[string]int),
+		chirpsByAuthor:         make(map[int][]int),
+		chirpsByHashtag:        make(map[string][]int),
+		chirpsByMention:        make(map[int][]int),
+		repliesByParent:        make(map[int][]int),
+		rechirpsByOriginal:     make(map[int][]int),
+		chirpsLikedBy:          make(map[int][]int),
+		chirpsBookmarkedBy:     make(map[int][]int),
+		followByPair:           make(map[followPair]int),
+		following:              make(map[int][]int),
+		followers:              make(map[int][]int),
+		mediaByOwner:           make(map[int][]int),
+		draftsByAuthor:         make(map[int][]int),
+		sessionsByUser:         make(map[int][]int),
+		refreshTokenByHash:     make(map[string]int),
+		refreshTokensBySession: make(map[int][]int),
+		mfaChallengeByHash:     make(map[string]int),
+		emailTokenByHash:       make(map[string]int),
+		emailTokensByUser:      make(map[int][]int),
+		terms:                  make(map[string]map[int][]int),
+	}
+	for _, user := range dbStructure.Users {
+		dbStructure.idx.addUser(user)
+	}
+	for _, follow := range dbStructure.Follows {
+		dbStructure.idx.addFollow(follow)
+	}
+	for _, media := range dbStructure.Media {
+		dbStructure.idx.addMedia(media)
+	}
+	for _, draft := range dbStructure.Drafts {
+		dbStructure.idx.addDraft(draft)
+	}
+	for _, session := range dbStructure.Sessions {
+		dbStructure.idx.addSession(session)
+	}
+	for _, token := range dbStructure.RefreshTokens {
+		dbStructure.idx.addRefreshToken(token)
+	}
+	for _, challenge := range dbStructure.MFAChallenges {
+		dbStructure.idx.addMFAChallenge(challenge)
+	}
+	for _, emailToken := range dbStructure.EmailTokens {
+		dbStructure.idx.addEmailToken(emailToken)
+	}
+	for _, chirp := range dbStructure.Chirps {
+		if chirp.ParentID != 0 {
+			dbStructure.idx.repliesByParent[chirp.ParentID] = append(dbStructure.idx.repliesByParent[chirp.ParentID], chirp.ID)
+		}
+		if chirp.Kind == ChirpKindRechirp {
+			dbStructure.idx.rechirpsByOriginal[chirp.OriginalID] = append(dbStructure.idx.rechirpsByOriginal[chirp.OriginalID], chirp.ID)
+		}
+		if chirp.Deleted {
+			continue
+		}
+		dbStructure.idx.chirpIDs = append(dbStructure.idx.chirpIDs, chirp.ID)
+		dbStructure.idx.chirpsByAuthor[chirp.AuthorID] = append(dbStructure.idx.chirpsByAuthor[chirp.AuthorID], chirp.ID)
+		for _, tag := range chirpHashtags(chirp) {
+			dbStructure.idx.chirpsByHashtag[tag] = append(dbStructure.idx.chirpsByHashtag[tag], chirp.ID)
+		}
+		for _, userID := range chirpMentions(chirp) {
+			dbStructure.idx.chirpsByMention[userID] = append(dbStructure.idx.chirpsByMention[userID], chirp.ID)
+		}
+		for position, term := range tokenize(chirp.Body) {
+			dbStructure.idx.addPosting(term, chirp.ID, position)
+		}
+	}
+	sort.Ints(dbStructure.idx.chirpIDs)
+	for _, ids := range dbStructure.idx.chirpsByAuthor {
+		sort.Ints(ids)
+	}
+	for _, ids := range dbStructure.idx.chirpsByHashtag {
+		sort.Ints(ids)
+	}
+	for _, ids := range dbStructure.idx.chirpsByMention {
+		sort.Ints(ids)
+	}
+	for _, likes := range dbStructure.Likes {
+		for _, like := range likes {
+			dbStructure.idx.chirpsLikedBy[like.UserID] = append(dbStructure.idx.chirpsLikedBy[like.UserID], like.ChirpID)
+		}
+	}
+	for _, bookmarks := range dbStructure.Bookmarks {
+		dbStructure.idx.addBookmarks(bookmarks)
+	}
+	for _, ids := range dbStructure.idx.repliesByParent {
+		sort.Ints(ids)
+	}
+	for _, ids := range dbStructure.idx.rechirpsByOriginal {
+		sort.Ints(ids)
+	}
+	for _, ids := range dbStructure.idx.chirpsLikedBy {
+		sort.Ints(ids)
+	}
+	dbStructure.idx.vocab = make([]string, 0, len(dbStructure.idx.terms))
+	for term := range dbStructure.idx.terms {
+		dbStructure.idx.vocab = append(dbStructure.idx.vocab, term)
+	}
+	sort.Strings(dbStructure.idx.vocab)
+}
+
+func (idx *indexes) addUser(user User) {
+	idx.userByEmail[user.Email] = user.ID
+	if user.Handle != "" {
+		idx.userByHandle[user.Handle] = user.ID
+	}
+}
+
+func (idx *indexes) removeUser(user User) {
+	delete(idx.userByEmail, user.Email)
+	if user.Handle != "" {
+		delete(idx.userByHandle, user.Handle)
+	}
+}
+
+// addChirp and removeChirp keep tombstones out of every index except
+// repliesByParent, so they only show up when walking a thread.
+func (idx *indexes) addChirp(chirp Chirp) {
+	if chirp.ParentID != 0 {
+		idx.repliesByParent[chirp.ParentID] = insertSorted(idx.repliesByParent[chirp.ParentID], chirp.ID)
+	}
+	if chirp.Kind == ChirpKindRechirp {
+		idx.rechirpsByOriginal[chirp.OriginalID] = insertSorted(idx.rechirpsByOriginal[chirp.OriginalID], chirp.ID)
+	}
+	if chirp.Deleted {
+		return
+	}
+	if idx.timelines != nil {
+		idx.timelines[chirp.AuthorID] = insertSorted(idx.timelines[chirp.AuthorID], chirp.ID)
+		for _, userID := range idx.followers[chirp.AuthorID] {
+			idx.timelines[userID] = insertSorted(idx.timelines[userID], chirp.ID)
+		}
+	}
+	idx.chirpIDs = insertSorted(idx.chirpIDs, chirp.ID)
+	idx.chirpsByAuthor[chirp.AuthorID] = insertSorted(idx.chirpsByAuthor[chirp.AuthorID], chirp.ID)
+	for _, tag := range chirpHashtags(chirp) {
+		idx.chirpsByHashtag[tag] = insertSorted(idx.chirpsByHashtag[tag], chirp.ID)
+	}
+	for _, userID := range chirpMentions(chirp) {
+		idx.chirpsByMention[userID] = insertSorted(idx.chirpsByMention[userID], chirp.ID)
+	}
+	for position, term := range tokenize(chirp.Body) {
+		if _, ok := idx.terms[term]; !ok {
+			idx.vocab = insertSortedString(idx.vocab, term)
+		}
+		idx.addPosting(term, chirp.ID, position)
+	}
+}
+
+func (idx *indexes) removeChirp(chirp Chirp) {
+	if chirp.ParentID != 0 {
+		if ids := removeSorted(idx.repliesByParent[chirp.ParentID], chirp.ID); len(ids) == 0 {
+			delete(idx.repliesByParent, chirp.ParentID)
+		} else {
+			idx.repliesByParent[chirp.ParentID] = ids
+		}
+	}
+	if chirp.Kind == ChirpKindRechirp {
+		if ids := removeSorted(idx.rechirpsByOriginal[chirp.OriginalID], chirp.ID); len(ids) == 0 {
+			delete(idx.rechirpsByOriginal, chirp.OriginalID)
+		} else {
+			idx.rechirpsByOriginal[chirp.OriginalID] = ids
+		}
+	}
+	if chirp.Deleted {
+		return
+	}
+	if idx.timelines != nil {
+		idx.timelines[chirp.AuthorID] = removeSorted(idx.timelines[chirp.AuthorID], chirp.ID)
+		for _, userID := range idx.followers[chirp.AuthorID] {
+			idx.timelines[userID] = removeSorted(idx.timelines[userID], chirp.ID)
+		}
+	}
+	idx.chirpIDs = removeSorted(idx.chirpIDs, chirp.ID)
+	ids := removeSorted(idx.chirpsByAuthor[chirp.AuthorID], chirp.ID)
+	if len(ids) == 0 {
+		delete(idx.chirpsByAuthor, chirp.AuthorID)
+	} else {
+		idx.chirpsByAuthor[chirp.AuthorID] = ids
+	}
+	for _, tag := range chirpHashtags(chirp) {
+		if ids := removeSorted(idx.chirpsByHashtag[tag], chirp.ID); len(ids) == 0 {
+			delete(idx.chirpsByHashtag, tag)
+		} else {
+			idx.chirpsByHashtag[tag] = ids
+		}
+	}
+	for _, userID := range chirpMentions(chirp) {
+		if ids := removeSorted(idx.chirpsByMention[userID], chirp.ID); len(ids) == 0 {
+			delete(idx.chirpsByMention, userID)
+		} else {
+			idx.chirpsByMention[userID] = ids
+		}
+	}
+	for _, term := range tokenize(chirp.Body) {
+		postings, ok := idx.terms[term]
+		if !ok {
+			continue
+		}
+		delete(postings, chirp.ID)
+		if len(postings) == 0 {
+			delete(idx.terms, term)
+			idx.vocab = removeSortedString(idx.vocab, term)
+		}
+	}
+}
+
+func (idx *indexes) addLikes(likes []Like) {
+	for _, like := range likes {
+		idx.chirpsLikedBy[like.UserID] = insertSorted(idx.chirpsLikedBy[like.UserID], like.ChirpID)
+	}
+}
+
+func (idx *indexes) removeLikes(likes []Like) {
+	for _, like := range likes {
+		if ids := removeSorted(idx.chirpsLikedBy[like.UserID], like.ChirpID); len(ids) == 0 {
+			delete(idx.chirpsLikedBy, like.UserID)
+		} else {
+			idx.chirpsLikedBy[like.UserID] = ids
+		}
+	}
+}
+
+func (idx *indexes) addBookmarks(bookmarks []Bookmark) {
+	for _, bookmark := range bookmarks {
+		idx.chirpsBookmarkedBy[bookmark.UserID] = insertSorted(idx.chirpsBookmarkedBy[bookmark.UserID], bookmark.ChirpID)
+	}
+}
+
+func (idx *indexes) removeBookmarks(bookmarks []Bookmark) {
+	for _, bookmark := range bookmarks {
+		if ids := removeSorted(idx.chirpsBookmarkedBy[bookmark.UserID], bookmark.ChirpID); len(ids) == 0 {
+			delete(idx.chirpsBookmarkedBy, bookmark.UserID)
+		} else {
+			idx.chirpsBookmarkedBy[bookmark.UserID] = ids
+		}
+	}
+}
+
+func (idx *indexes) addMedia(media Media) {
+	idx.mediaByOwner[media.OwnerID] = insertSorted(idx.mediaByOwner[media.OwnerID], media.ID)
+}
+
+func (idx *indexes) removeMedia(media Media) {
+	if ids := removeSorted(idx.mediaByOwner[media.OwnerID], media.ID); len(ids) == 0 {
+		delete(idx.mediaByOwner, media.OwnerID)
+	} else {
+		idx.mediaByOwner[media.OwnerID] = ids
+	}
+}
+
+// A timedID is an ID in an index ordered by time, ties broken by ID.
+type timedID struct {
+	at time.Time
+	id int
+}
+
+func (t timedID) before(other timedID) bool {
+	if !t.at.Equal(other.at) {
+		return t.at.Before(other.at)
+	}
+	return t.id < other.id
+}
+
+func insertTimed(values []timedID, value timedID) []timedID {
+	i := sort.Search(len(values), func(i int) bool { return !values[i].before(value) })
+	values = append(values, timedID{})
+	copy(values[i+1:], values[i:])
+	values[i] = value
+	return values
+}
+
+func removeTimed(values []timedID, value timedID) []timedID {
+	i := sort.Search(len(values), func(i int) bool { return !values[i].before(value) })
+	if i == len(values) || values[i].id != value.id {
+		return values
+	}
+	return append(values[:i], values[i+1:]...)
+}
+
+func (idx *indexes) addDraft(draft Draft) {
+	idx.draftsByAuthor[draft.AuthorID] = insertSorted(idx.draftsByAuthor[draft.AuthorID], draft.ID)
+	if draft.Status == DraftStatusScheduled && draft.PublishAt != nil {
+		idx.scheduledDrafts = insertTimed(idx.scheduledDrafts, timedID{at: *draft.PublishAt, id: draft.ID})
+	}
+}
+
+func (idx *indexes) removeDraft(draft Draft) {
+	if ids := removeSorted(idx.draftsByAuthor[draft.AuthorID], draft.ID); len(ids) == 0 {
+		delete(idx.draftsByAuthor, draft.AuthorID)
+	} else {
+		idx.draftsByAuthor[draft.AuthorID] = ids
+	}
+	if draft.Status == DraftStatusScheduled && draft.PublishAt != nil {
+		idx.scheduledDrafts = removeTimed(idx.scheduledDrafts, timedID{at: *draft.PublishAt, id: draft.ID})
+	}
+}
+
+func (idx *indexes) addSession(session Session) {
+	idx.sessionsByUser[session.UserID] = insertSorted(idx.sessionsByUser[session.UserID], session.ID)
+	idx.sessionsByExpiry = insertTimed(idx.sessionsByExpiry, timedID{at: session.ExpiresAt, id: session.ID})
+}
+
+func (idx *indexes) removeSession(session Session) {
+	idx.sessionsByExpiry = removeTimed(idx.sessionsByExpiry, timedID{at: session.ExpiresAt, id: session.ID})
+	if ids := removeSorted(idx.sessionsByUser[session.UserID], session.ID); len(ids) == 0 {
+		delete(idx.sessionsByUser, session.UserID)
+	} else {
+		idx.sessionsByUser[session.UserID] = ids
+	}
+}
+
+func (idx *indexes) addRefreshToken(token RefreshToken) {
+	idx.refreshTokenByHash[token.TokenHash] = token.ID
+	idx.refreshTokensBySession[token.SessionID] = insertSorted(idx.refreshTokensBySession[token.SessionID], token.ID)
+}
+
+func (idx *indexes) removeRefreshToken(token RefreshToken) {
+	delete(idx.refreshTokenByHash, token.TokenHash)
+	if ids := removeSorted(idx.refreshTokensBySession[token.SessionID], token.ID); len(ids) == 0 {
+		delete(idx.refreshTokensBySession, token.SessionID)
+	} else {
+		idx.refreshTokensBySession[token.SessionID] = ids
+	}
+}
+
+func (idx *indexes) addMFAChallenge(challenge MFAChallenge) {
+	idx.mfaChallengeByHash[challenge.TokenHash] = challenge.ID
+}
+
+func (idx *indexes) removeMFAChallenge(challenge MFAChallenge) {
+	delete(idx.mfaChallengeByHash, challenge.TokenHash)
+}
+
+func (idx *indexes) addEmailToken(emailToken EmailToken) {
+	idx.emailTokenByHash[emailToken.TokenHash] = emailToken.ID
+	idx.emailTokensByUser[emailToken.UserID] = insertSorted(idx.emailTokensByUser[emailToken.UserID], emailToken.ID)
+}
+
+func (idx *indexes) removeEmailToken(emailToken EmailToken) {
+	delete(idx.emailTokenByHash, emailToken.TokenHash)
+	if ids := removeSorted(idx.emailTokensByUser[emailToken.UserID], emailToken.ID); len(ids) == 0 {
+		delete(idx.emailTokensByUser, emailToken.UserID)
+	} else {
+		idx.emailTokensByUser[emailToken.UserID] = ids
+	}
+}
+
+func (idx *indexes) addFollow(follow Follow) {
+	idx.followByPair[followPair{follow.FollowerID, follow.FolloweeID}] = follow.ID
+	idx.following[follow.FollowerID] = insertSorted(idx.following[follow.FollowerID], follow.FolloweeID)
+	idx.followers[follow.FolloweeID] = insertSorted(idx.followers[follow.FolloweeID], follow.FollowerID)
+	if idx.timelines != nil {
+		for _, id := range idx.chirpsByAuthor[follow.FolloweeID] {
+			idx.timelines[follow.FollowerID] = insertSorted(idx.timelines[follow.FollowerID], id)
+		}
+	}
+}
+
+func (idx *indexes) removeFollow(follow Follow) {
+	delete(idx.followByPair, followPair{follow.FollowerID, follow.FolloweeID})
+	if ids := removeSorted(idx.following[follow.FollowerID], follow.FolloweeID); len(ids) == 0 {
+		delete(idx.following, follow.FollowerID)
+	} else {
+		idx.following[follow.FollowerID] = ids
+	}
+	if ids := removeSorted(idx.followers[follow.FolloweeID], follow.FollowerID); len(ids) == 0 {
+		delete(idx.followers, follow.FolloweeID)
+	} else {
+		idx.followers[follow.FolloweeID] = ids
+	}
+	if idx.timelines != nil {
+		for _, id := range idx.chirpsByAuthor[follow.FolloweeID] {
+			idx.timelines[follow.FollowerID] = removeSorted(idx.timelines[follow.FollowerID], id)
+		}
+	}
+}
+
+// buildTimelines fans every chirp out to its author and their followers.
+func (idx *indexes) buildTimelines() {
+	idx.timelines = make(map[int][]int)
+	for authorID, ids := range idx.chirpsByAuthor {
+		idx.timelines[authorID] = append(idx.timelines[authorID], ids...)
+		for _, userID := range idx.followers[authorID] {
+			idx.timelines[userID] = append(idx.timelines[userID], ids...)
+		}
+	}
+	for _, ids := range idx.timelines {
+		sort.Ints(ids)
+	}
+}
+
+func (idx *indexes) addPosting(term string, chirpID int, position int) {
+	postings, ok := idx.terms[term]
+	if !ok {
+		postings = make(map[int][]int)
+		idx.terms[term] = postings
+	}
+	postings[chirpID] = append(postings[chirpID], position)
+}
+
+// insertSorted adds id to the ascending slice ids unless it is already
+// there. New IDs are the largest, so this is usually an append.
+func insertSorted(ids []int, id int) []int {
+	i := sort.SearchInts(ids, id)
+	if i < len(ids) && ids[i] == id {
+		return ids
+	}
+	ids = append(ids, 0)
+	copy(ids[i+1:], ids[i:])
+	ids[i] = id
+	return ids
+}
+
+func removeSorted(ids []int, id int) []int {
+	i := sort.SearchInts(ids, id)
+	if i == len(ids) || ids[i] != id {
+		return ids
+	}
+	return append(ids[:i], ids[i+1:]...)
+}
+
+func insertSortedString(values []string, value string) []string {
+	i := sort.SearchStrings(values, value)
+	if i < len(values) && values[i] == value {
+		return values
+	}
+	values = append(values, "")
+	copy(values[i+1:], values[i:])
+	values[i] = value
+	return values
+}
+
+func removeSortedString(values []string, value string) []string {
+	i := sort.SearchStrings(values, value)
+	if i == len(values) || values[i] != value {
+		return values
+	}
+	return append(values[:i], values[i+1:]...)
+}
+
+// resolveMention resolves a mention by handle. Names too short to be a
+// handle fall back to the local part of user emails, so mentions like "@a"
+// still reach the user they did before handles existed.
+func (dbStructure *DBStructure) resolveMention(name string) (int, bool) {
+	if len(name) < minHandleLength {
+		return dbStructure.resolveMentionByEmail(name)
+	}
+	id, ok := dbStructure.idx.userByHandle[normalizeHandle(name)]
+	return id, ok
+}
+
+// resolveMentionByEmail resolves mentions against the local part of user
+// emails, ignoring names shared by more than one user. Mentions worked this
+// way before users had handles, and migrations written back then rely on it.
+func (dbStructure *DBStructure) resolveMentionByEmail(name string) (int, bool) {
+	found := 0
+	for _, user := range dbStructure.Users {
+		local, _, _ := strings.Cut(user.Email, "@")
+		if strings.EqualFold(local, name) {
+			if found != 0 {
+				return 0, false
+			}
+			found = user.ID
+		}
+	}
+	return found, found != 0
+}
@@ -0,0 +1,238 @@
+package database
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+const journalSuffix = ".journal"
+
+const (
+	opPut    = "put"
+	opDelete = "delete"
+)
+
+const (
+	collectionChirps        = "chirps"
+	collectionUsers         = "users"
+	collectionRevisions     = "revisions"
+	collectionLikes         = "likes"
+	collectionFollows       = "follows"
+	collectionMedia         = "media"
+	collectionDrafts        = "drafts"
+	collectionBookmarks     = "bookmarks"
+	collectionSessions      = "sessions"
+	collectionRefreshTokens = "refresh_tokens"
+	collectionTOTP          = "totp"
+	collectionMFAChallenges = "mfa_challenges"
+	collectionEmailTokens   = "email_tokens"
+)
+
+// journalEntry is a single mutation recorded in the journal before the
+// snapshot is rewritten, so that it can be replayed after a crash. Schema is
+// the schema version the value was written in; replay migrates the data to
+// it before applying the entry.
+type journalEntry struct {
+	Seq        int             `json:"seq"`
+	Schema     int             `json:"schema,omitempty"`
+	Op         string          `json:"op"`
+	Collection string          `json:"collection"`
+	Key        json.RawMessage `json:"key"`
+	Value      json.RawMessage `json:"value,omitempty"`
+}
+
+func putEntry(collection string, key any, value any) journalEntry {
+	keyJSON, _ := json.Marshal(key)
+	valueJSON, _ := json.Marshal(value)
+	return journalEntry{Op: opPut, Collection: collection, Key: keyJSON, Value: valueJSON}
+}
+
+func deleteEntry(collection string, key any) journalEntry {
+	keyJSON, _ := json.Marshal(key)
+	return journalEntry{Op: opDelete, Collection: collection, Key: keyJSON}
+}
+
+func (dbStructure *DBStructure) apply(entry journalEntry) error {
+	var err error
+	switch entry.Collection {
+	case collectionChirps:
+		err = applyEntry(dbStructure.Chirps, entry, dbStructure.idx.removeChirp, dbStructure.idx.addChirp)
+	case collectionUsers:
+		err = applyEntry(dbStructure.Users, entry, dbStructure.idx.removeUser, dbStructure.idx.addUser)
+	case collectionRevisions:
+		err = applyEntry(dbStructure.Revisions, entry, nil, nil)
+	case collectionLikes:
+		err = applyEntry(dbStructure.Likes, entry, dbStructure.idx.removeLikes, dbStructure.idx.addLikes)
+	case collectionFollows:
+		err = applyEntry(dbStructure.Follows, entry, dbStructure.idx.removeFollow, dbStructure.idx.addFollow)
+	case collectionMedia:
+		err = applyEntry(dbStructure.Media, entry, dbStructure.idx.removeMedia, dbStructure.idx.addMedia)
+	case collectionDrafts:
+		err = applyEntry(dbStructure.Drafts, entry, dbStructure.idx.removeDraft, dbStructure.idx.addDraft)
+	case collectionSessions:
+		err = applyEntry(dbStructure.Sessions, entry, dbStructure.idx.removeSession, dbStructure.idx.addSession)
+	case collectionRefreshTokens:
+		err = applyEntry(dbStructure.RefreshTokens, entry, dbStructure.idx.removeRefreshToken, dbStructure.idx.addRefreshToken)
+	case collectionBookmarks:
+		err = applyEntry(dbStructure.Bookmarks, entry, dbStructure.idx.removeBookmarks, dbStructure.idx.addBookmarks)
+	case collectionTOTP:
+		err = applyEntry(dbStructure.TOTP, entry, nil, nil)
+	case collectionMFAChallenges:
+		err = applyEntry(dbStructure.MFAChallenges, entry, dbStructure.idx.removeMFAChallenge, dbStructure.idx.addMFAChallenge)
+	case collectionEmailTokens:
+		err = applyEntry(dbStructure.EmailTokens, entry, dbStructure.idx.removeEmailToken, dbStructure.idx.addEmailToken)
+	default:
+		return fmt.Errorf("unknown journal collection %q", entry.Collection)
+	}
+	if err != nil {
+		return err
+	}
+
+	var id int
+	if entry.Op == opPut && json.Unmarshal(entry.Key, &id) == nil {
+		dbStructure.bumpSequence(entry.Collection, id)
+	}
+	return nil
+}
+
+// applyEntry applies entry to m, calling unindex with the value being
+// replaced or deleted and index with the value being stored. Either hook may
+// be nil for collections without indexes.
+func applyEntry[K comparable, V any](m map[K]V, entry journalEntry, unindex func(V), index func(V)) error {
+	var key K
+	if err := json.Unmarshal(entry.Key, &key); err != nil {
+		return err
+	}
+
+	var value V
+	switch entry.Op {
+	case opPut:
+		if err := json.Unmarshal(entry.Value, &value); err != nil {
+			return err
+		}
+	case opDelete:
+	default:
+		return fmt.Errorf("unknown journal op %q", entry.Op)
+	}
+
+	if old, ok := m[key]; ok && unindex != nil {
+		unindex(old)
+	}
+	if entry.Op == opDelete {
+		delete(m, key)
+		return nil
+	}
+	m[key] = value
+	if index != nil {
+		index(value)
+	}
+	return nil
+}
+
+func (db *DB) journalPath() string {
+	return db.path + journalSuffix
+}
+
+func (db *DB) appendJournal(entries []journalEntry) error {
+	buf := bytes.Buffer{}
+	encoder := json.NewEncoder(&buf)
+	for _, entry := range entries {
+		if err := encoder.Encode(entry); err != nil {
+			return err
+		}
+	}
+
+	file, err := os.OpenFile(db.journalPath(), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err = file.Write(buf.Bytes()); err != nil {
+		file.Close()
+		return err
+	}
+	if err = file.Sync(); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// compactJournal drops the entries up to seq, which a snapshot now holds, so
+// the journal only ever covers what happened since the last snapshot. Callers
+// hold the write lock, so nothing is appended while the journal is rewritten.
+func (db *DB) compactJournal(seq int) error {
+	entries, err := db.readJournal()
+	if err != nil {
+		return err
+	}
+	kept := bytes.Buffer{}
+	encoder := json.NewEncoder(&kept)
+	for _, entry := range entries {
+		if entry.Seq <= seq {
+			continue
+		}
+		if err := encoder.Encode(entry); err != nil {
+			return err
+		}
+	}
+	if kept.Len() == 0 {
+		err := os.Truncate(db.journalPath(), 0)
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		return err
+	}
+	return writeFileAtomic(db.journalPath(), kept.Bytes(), 0600)
+}
+
+// readJournal returns every complete entry in the journal. A torn entry left
+// by a crash during append is cut off so new entries are not written after it.
+func (db *DB) readJournal() ([]journalEntry, error) {
+	file, err := os.OpenFile(db.journalPath(), os.O_RDWR, 0600)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	entries := []journalEntry{}
+	reader := bufio.NewReader(file)
+	var validSize int64
+	for {
+		line, err := reader.ReadBytes('\n')
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		entry := journalEntry{}
+		if err := json.Unmarshal(line, &entry); err != nil {
+			break
+		}
+		entries = append(entries, entry)
+		validSize += int64(len(line))
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if info.Size() != validSize {
+		if err := file.Truncate(validSize); err != nil {
+			return nil, err
+		}
+		if err := file.Sync(); err != nil {
+			return nil, err
+		}
+	}
+	return entries, nil
+}
@@ -0,0 +1,127 @@
+package database
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"slices"
+	"time"
+)
+
+const MaxChirpMedia = 4
+
+// Media is an uploaded image. The files themselves live outside the
+// database; URL and ThumbnailURL point to where they are served.
+type Media struct {
+	ID           int       `json:"id"`
+	OwnerID      int       `json:"owner_id"`
+	Hash         string    `json:"hash"`
+	ContentType  string    `json:"content_type"`
+	Size         int64     `json:"size"`
+	Width        int       `json:"width"`
+	Height       int       `json:"height"`
+	URL          string    `json:"url"`
+	ThumbnailURL string    `json:"thumbnail_url"`
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+// ChirpMedia is the media attached to a chirp, copied into it when the
+// chirp is created.
+type ChirpMedia []Media
+
+func (m ChirpMedia) Value() (driver.Value, error) {
+	if m == nil {
+		m = ChirpMedia{}
+	}
+	data, err := json.Marshal(m)
+	if err != nil {
+		return nil, err
+	}
+	return string(data), nil
+}
+
+func (m *ChirpMedia) Scan(src any) error {
+	var err error
+	switch v := src.(type) {
+	case string:
+		err = json.Unmarshal([]byte(v), m)
+	case []byte:
+		err = json.Unmarshal(v, m)
+	default:
+		return errors.New("unsupported type for chirp media")
+	}
+	if len(*m) == 0 {
+		*m = nil
+	}
+	return err
+}
+
+func validateMediaIDs(ids []int) error {
+	if len(ids) > MaxChirpMedia {
+		return ErrTooManyMedia
+	}
+	for i, id := range ids {
+		if slices.Contains(ids[:i], id) {
+			return ErrDuplicateMedia
+		}
+	}
+	return nil
+}
+
+func (db *DB) CreateMedia(media Media) (Media, error) {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+
+	dbStructure := db.data
+
+	if _, ok := dbStructure.Users[media.OwnerID]; !ok {
+		return Media{}, ErrUserNotFound
+	}
+
+	media.ID = dbStructure.nextID(collectionMedia)
+	media.CreatedAt = time.Now().UTC()
+	if err := db.commit(putEntry(collectionMedia, media.ID, media)); err != nil {
+		return Media{}, err
+	}
+	return media, nil
+}
+
+func (db *DB) GetMedia(id int) (Media, error) {
+	db.mux.RLock()
+	defer db.mux.RUnlock()
+
+	media, ok := db.data.Media[id]
+	if !ok {
+		return Media{}, ErrMediaNotFound
+	}
+	return media, nil
+}
+
+// ownsMediaURL reports whether url is where media uploaded by userID, or its
+// thumbnail, is served.
+func (dbStructure *DBStructure) ownsMediaURL(userID int, url string) bool {
+	for _, id := range dbStructure.idx.mediaByOwner[userID] {
+		media := dbStructure.Media[id]
+		if media.URL == url || media.ThumbnailURL == url {
+			return true
+		}
+	}
+	return false
+}
+
+// chirpMedia looks up the media to attach to a chirp by authorID. Media
+// uploaded by someone else is treated as missing.
+func (dbStructure *DBStructure) chirpMedia(ids []int, authorID int) (ChirpMedia, error) {
+	if len(ids) == 0 {
+		return nil, nil
+	}
+	attached := ChirpMedia{}
+	for _, id := range ids {
+		media, ok := dbStructure.Media[id]
+		if !ok || media.OwnerID != authorID {
+			return nil, ErrMediaNotFound
+		}
+		attached = append(attached, media)
+	}
+	return attached, nil
+}
@@ -6,10 +6,7 @@ func (db *DB) UpgradeUser(userID int) error {
 	db.mux.Lock()
 	defer db.mux.Unlock()
 
-	dbStructure, err := db.loadDB()
-	if err != nil {
-		return err
-	}
+	dbStructure := db.data
 
 	user, ok := dbStructure.Users[userID]
 	if !ok {
@@ -17,8 +14,7 @@ func (db *DB) UpgradeUser(userID int) error {
 	}
 
 	user.IsChirpyRed = true
-	dbStructure.Users[userID] = user
-	if err = db.writeDB(dbStructure); err != nil {
+	if err := db.commit(putEntry(collectionUsers, userID, user)); err != nil {
 		return err
 	}
 
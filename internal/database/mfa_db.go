@@ -0,0 +1,386 @@
+package database
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/railanbaigazy/chirpy/internal/totp"
+)
+
+// TOTP is a user's authenticator secret. It is pending until the user
+// confirms enrollment with a code from their app; only then does login ask
+// for one. The secret is stored sealed with a key derived from the token
+// key, and recovery codes as keyed hashes that are used up one by one.
+// Wrong codes are counted across login challenges, and maxMFAFailures of
+// them in a row lock the user out for mfaLockout.
+type TOTP struct {
+	UserID        int        `json:"user_id"`
+	Secret        string     `json:"secret"`
+	Enabled       bool       `json:"enabled"`
+	LastStep      int64      `json:"last_step"`
+	RecoveryCodes []string   `json:"recovery_codes"`
+	Failures      int        `json:"failures"`
+	LockedUntil   *time.Time `json:"locked_until,omitempty"`
+	CreatedAt     time.Time  `json:"created_at"`
+}
+
+// TOTPEnrollment is what a user adds to their authenticator app.
+type TOTPEnrollment struct {
+	Secret          string `json:"secret"`
+	ProvisioningURI string `json:"provisioning_uri"`
+}
+
+// An MFAChallenge is issued by Login instead of tokens to users with
+// two-factor authentication, and exchanged for them by CompleteMFALogin.
+type MFAChallenge struct {
+	ID        int       `json:"id"`
+	UserID    int       `json:"user_id"`
+	TokenHash string    `json:"token_hash"`
+	Attempts  int       `json:"attempts"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+type MFAChallengeResp struct {
+	MFARequired bool      `json:"mfa_required"`
+	MFAToken    string    `json:"mfa_token"`
+	ExpiresAt   time.Time `json:"expires_at"`
+}
+
+const (
+	totpIssuer         = "Chirpy"
+	mfaChallengeTTL    = 5 * time.Minute
+	maxMFAAttempts     = 5
+	maxMFAFailures     = 10
+	mfaLockout         = 15 * time.Minute
+	recoveryCodeCount  = 10
+	recoveryCodeLength = 10
+)
+
+const (
+	recoveryCodeAlphabet = "abcdefghijklmnopqrstuvwxyz234567"
+	sealedSecretPrefix   = "v1."
+)
+
+// secretCipher returns the AEAD TOTP secrets are sealed with. Its key is
+// derived from the token key so the two are never used for the same thing.
+func secretCipher(key []byte) (cipher.AEAD, error) {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte("totp-secret"))
+	block, err := aes.NewCipher(mac.Sum(nil))
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// sealSecret encrypts the TOTP secret of userID for storage. The user ID is
+// authenticated with it, so a sealed secret can't be moved to another user.
+func sealSecret(key []byte, userID int, secret string) (string, error) {
+	aead, err := secretCipher(key)
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	sealed := aead.Seal(nonce, nonce, []byte(secret), []byte(strconv.Itoa(userID)))
+	return sealedSecretPrefix + base64.RawURLEncoding.EncodeToString(sealed), nil
+}
+
+func openSecret(key []byte, userID int, sealed string) (string, error) {
+	aead, err := secretCipher(key)
+	if err != nil {
+		return "", err
+	}
+	data, err := base64.RawURLEncoding.DecodeString(strings.TrimPrefix(sealed, sealedSecretPrefix))
+	if err != nil || !strings.HasPrefix(sealed, sealedSecretPrefix) || len(data) < aead.NonceSize() {
+		return "", errors.New("malformed TOTP secret")
+	}
+	nonce, ciphertext := data[:aead.NonceSize()], data[aead.NonceSize():]
+	secret, err := aead.Open(nil, nonce, ciphertext, []byte(strconv.Itoa(userID)))
+	if err != nil {
+		return "", errors.New("TOTP secret can't be decrypted with the current token key")
+	}
+	return string(secret), nil
+}
+
+func newTOTP(userID int, email string, key []byte) (TOTP, TOTPEnrollment, error) {
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		return TOTP{}, TOTPEnrollment{}, err
+	}
+	sealed, err := sealSecret(key, userID, secret)
+	if err != nil {
+		return TOTP{}, TOTPEnrollment{}, err
+	}
+	enrollment := TOTPEnrollment{
+		Secret:          secret,
+		ProvisioningURI: totp.ProvisioningURI(totpIssuer, email, secret),
+	}
+	return TOTP{UserID: userID, Secret: sealed, CreatedAt: time.Now().UTC()}, enrollment, nil
+}
+
+// generateRecoveryCodes returns new recovery codes, formatted like
+// "abcde-fghij", together with the hashes to store.
+func generateRecoveryCodes(key []byte) ([]string, []string, error) {
+	codes := make([]string, recoveryCodeCount)
+	hashes := make([]string, recoveryCodeCount)
+	for i := range codes {
+		b := make([]byte, recoveryCodeLength)
+		if _, err := rand.Read(b); err != nil {
+			return nil, nil, err
+		}
+		for j := range b {
+			b[j] = recoveryCodeAlphabet[int(b[j])%len(recoveryCodeAlphabet)]
+		}
+		code := string(b)
+		codes[i] = code[:recoveryCodeLength/2] + "-" + code[recoveryCodeLength/2:]
+		hashes[i] = hashToken(key, code)
+	}
+	return codes, hashes, nil
+}
+
+// confirm checks the first code from a pending enrollment and enables it,
+// returning the recovery codes to show the user.
+func (t *TOTP) confirm(code string, key []byte) ([]string, error) {
+	if t.Enabled {
+		return nil, ErrTOTPAlreadyEnabled
+	}
+	secret, err := openSecret(key, t.UserID, t.Secret)
+	if err != nil {
+		return nil, err
+	}
+	step, ok := totp.Validate(secret, code, time.Now(), t.LastStep)
+	if !ok {
+		return nil, ErrInvalidMFACode
+	}
+	codes, hashes, err := generateRecoveryCodes(key)
+	if err != nil {
+		return nil, err
+	}
+	t.Enabled = true
+	t.LastStep = step
+	t.RecoveryCodes = hashes
+	return codes, nil
+}
+
+// check verifies code unless the user is locked out, counting it towards
+// the lockout if it is wrong. The TOTP needs storing whatever the outcome.
+func (t *TOTP) check(code string, key []byte, now time.Time) error {
+	if t.LockedUntil != nil && t.LockedUntil.After(now) {
+		return ErrMFALocked
+	}
+	if !t.verify(code, key) {
+		t.Failures++
+		if t.Failures >= maxMFAFailures {
+			lockedUntil := now.Add(mfaLockout).UTC()
+			t.Failures = 0
+			t.LockedUntil = &lockedUntil
+		}
+		return ErrInvalidMFACode
+	}
+	t.Failures = 0
+	t.LockedUntil = nil
+	return nil
+}
+
+// verify accepts either a code from the authenticator app or an unused
+// recovery code, using up the latter.
+func (t *TOTP) verify(code string, key []byte) bool {
+	secret, err := openSecret(key, t.UserID, t.Secret)
+	if err != nil {
+		return false
+	}
+	if step, ok := totp.Validate(secret, code, time.Now(), t.LastStep); ok {
+		t.LastStep = step
+		return true
+	}
+
+	code = strings.ToLower(strings.NewReplacer("-", "", " ", "").Replace(code))
+	if len(code) != recoveryCodeLength {
+		return false
+	}
+	codeHash := hashToken(key, code)
+	for i, hash := range t.RecoveryCodes {
+		if subtle.ConstantTimeCompare([]byte(hash), []byte(codeHash)) == 1 {
+			t.RecoveryCodes = slices.Delete(slices.Clone(t.RecoveryCodes), i, i+1)
+			return true
+		}
+	}
+	return false
+}
+
+func (user User) loginResp(token string, refreshToken string) LoginResp {
+	return LoginResp{
+		ID:           user.ID,
+		Email:        user.Email,
+		Handle:       user.Handle,
+		Token:        token,
+		RefreshToken: refreshToken,
+		IsChirpyRed:  user.IsChirpyRed,
+	}
+}
+
+// newMFAChallenge returns the entries that start a login challenge for
+// userID. Expired challenges are removed along the way.
+func (dbStructure *DBStructure) newMFAChallenge(userID int, tokenKey []byte) (MFAChallengeResp, []journalEntry, error) {
+	mfaToken, err := generateToken()
+	if err != nil {
+		return MFAChallengeResp{}, nil, err
+	}
+
+	now := time.Now().UTC()
+	entries := []journalEntry{}
+	for id, challenge := range dbStructure.MFAChallenges {
+		if !challenge.ExpiresAt.After(now) {
+			entries = append(entries, deleteEntry(collectionMFAChallenges, id))
+		}
+	}
+
+	challenge := MFAChallenge{
+		ID:        dbStructure.nextID(collectionMFAChallenges),
+		UserID:    userID,
+		TokenHash: hashToken(tokenKey, mfaToken),
+		ExpiresAt: now.Add(mfaChallengeTTL),
+	}
+	entries = append(entries, putEntry(collectionMFAChallenges, challenge.ID, challenge))
+	return MFAChallengeResp{MFARequired: true, MFAToken: mfaToken, ExpiresAt: challenge.ExpiresAt}, entries, nil
+}
+
+func (db *DB) BeginTOTPEnrollment(userID int) (TOTPEnrollment, error) {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+
+	dbStructure := db.data
+
+	user, ok := dbStructure.Users[userID]
+	if !ok {
+		return TOTPEnrollment{}, ErrUserNotFound
+	}
+	if dbStructure.TOTP[userID].Enabled {
+		return TOTPEnrollment{}, ErrTOTPAlreadyEnabled
+	}
+
+	t, enrollment, err := newTOTP(userID, user.Email, db.tokenKey)
+	if err != nil {
+		return TOTPEnrollment{}, err
+	}
+	if err := db.commit(putEntry(collectionTOTP, userID, t)); err != nil {
+		return TOTPEnrollment{}, err
+	}
+	return enrollment, nil
+}
+
+func (db *DB) ConfirmTOTP(userID int, code string) ([]string, error) {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+
+	t, ok := db.data.TOTP[userID]
+	if !ok {
+		return nil, ErrTOTPNotEnrolled
+	}
+	codes, err := t.confirm(code, db.tokenKey)
+	if err != nil {
+		return nil, err
+	}
+	if err := db.commit(putEntry(collectionTOTP, userID, t)); err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// DisableTOTP turns two-factor authentication off, given a current code or
+// a recovery code. Wrong codes count towards the lockout like at login.
+func (db *DB) DisableTOTP(userID int, code string) error {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+
+	t, ok := db.data.TOTP[userID]
+	if !ok || !t.Enabled {
+		return ErrTOTPNotEnabled
+	}
+	if err := t.check(code, db.tokenKey, time.Now()); err != nil {
+		if commitErr := db.commit(putEntry(collectionTOTP, userID, t)); commitErr != nil {
+			return commitErr
+		}
+		return err
+	}
+	return db.commit(deleteEntry(collectionTOTP, userID))
+}
+
+// CompleteMFALogin exchanges a login challenge and a code for the tokens
+// Login would have returned. A challenge can be used once and is dropped
+// after maxMFAAttempts wrong codes; the user is locked out after
+// maxMFAFailures, however many challenges they were spread over.
+func (db *DB) CompleteMFALogin(mfaToken string, code string, signer TokenSigner, client SessionInfo) (LoginResp, error) {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+
+	dbStructure := db.data
+
+	tokenHash := hashToken(db.tokenKey, mfaToken)
+	id, ok := dbStructure.idx.mfaChallengeByHash[tokenHash]
+	if !ok {
+		return LoginResp{}, ErrMFAChallengeNotFound
+	}
+	challenge := dbStructure.MFAChallenges[id]
+	if subtle.ConstantTimeCompare([]byte(challenge.TokenHash), []byte(tokenHash)) != 1 {
+		return LoginResp{}, ErrMFAChallengeNotFound
+	}
+	if !challenge.ExpiresAt.After(time.Now()) {
+		if err := db.commit(deleteEntry(collectionMFAChallenges, id)); err != nil {
+			return LoginResp{}, err
+		}
+		return LoginResp{}, ErrMFAChallengeNotFound
+	}
+
+	user := dbStructure.Users[challenge.UserID]
+	t, ok := dbStructure.TOTP[challenge.UserID]
+	err := ErrInvalidMFACode
+	if ok && t.Enabled {
+		err = t.check(code, db.tokenKey, time.Now())
+	}
+	if err != nil {
+		challenge.Attempts++
+		entries := []journalEntry{putEntry(collectionMFAChallenges, id, challenge)}
+		if challenge.Attempts >= maxMFAAttempts {
+			entries[0] = deleteEntry(collectionMFAChallenges, id)
+		}
+		if ok && t.Enabled {
+			entries = append(entries, putEntry(collectionTOTP, t.UserID, t))
+		}
+		if commitErr := db.commit(entries...); commitErr != nil {
+			return LoginResp{}, commitErr
+		}
+		return LoginResp{}, err
+	}
+
+	sessionID, refreshToken, entries, err := dbStructure.newSession(user.ID, client, db.tokenKey)
+	if err != nil {
+		return LoginResp{}, err
+	}
+	tokenString, err := signAccessToken("chirpy", user.ID, sessionID, signer)
+	if err != nil {
+		return LoginResp{}, err
+	}
+	entries = append(entries,
+		deleteEntry(collectionMFAChallenges, id),
+		putEntry(collectionTOTP, user.ID, t),
+	)
+	if err := db.commit(entries...); err != nil {
+		return LoginResp{}, err
+	}
+
+	return user.loginResp(tokenString, refreshToken), nil
+}
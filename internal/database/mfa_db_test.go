@@ -0,0 +1,122 @@
+package database
+
+import (
+	"errors"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/railanbaigazy/chirpy/internal/totp"
+)
+
+// enableTestTOTP turns on two-factor authentication for userID and returns
+// the secret their authenticator app would hold.
+func enableTestTOTP(t *testing.T, store Store, userID int) string {
+	t.Helper()
+	enrollment, err := store.BeginTOTPEnrollment(userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	code, err := totp.Code(enrollment.Secret, totp.Step(time.Now()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.ConfirmTOTP(userID, code); err != nil {
+		t.Fatal(err)
+	}
+	return enrollment.Secret
+}
+
+func mfaChallenge(t *testing.T, store Store, email string) string {
+	t.Helper()
+	_, challenge, err := store.Login(email, "password", testSigner{}, SessionInfo{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if challenge == nil {
+		t.Fatal("login did not ask for a second factor")
+	}
+	return challenge.MFAToken
+}
+
+func TestMFALockoutSpansChallenges(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store Store) {
+		userID, _ := loginTestUser(t, store, "a@example.com")
+		secret := enableTestTOTP(t, store, userID)
+
+		// Two wrong codes per challenge stays under the per-challenge cap.
+		mfaToken := ""
+		for i := range maxMFAFailures {
+			if i%2 == 0 {
+				mfaToken = mfaChallenge(t, store, "a@example.com")
+			}
+			_, err := store.CompleteMFALogin(mfaToken, "000000", testSigner{}, SessionInfo{})
+			if !errors.Is(err, ErrInvalidMFACode) {
+				t.Fatalf("wrong code %d returned %v, want ErrInvalidMFACode", i+1, err)
+			}
+		}
+
+		code, err := totp.Code(secret, totp.Step(time.Now())+1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = store.CompleteMFALogin(mfaChallenge(t, store, "a@example.com"), code, testSigner{}, SessionInfo{})
+		if !errors.Is(err, ErrMFALocked) {
+			t.Fatalf("right code after %d wrong ones returned %v, want ErrMFALocked", maxMFAFailures, err)
+		}
+		if err := store.DisableTOTP(userID, code); !errors.Is(err, ErrMFALocked) {
+			t.Fatalf("DisableTOTP while locked out returned %v, want ErrMFALocked", err)
+		}
+	})
+}
+
+func TestTOTPSecretSealedAtRest(t *testing.T) {
+	db, path := newTestDB(t, 0)
+	defer db.Close()
+	userID, _ := loginTestUser(t, db, "a@example.com")
+	secret := enableTestTOTP(t, db, userID)
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(data), secret) {
+		t.Fatal("database file holds the plaintext TOTP secret")
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mode := info.Mode().Perm(); mode != 0600 {
+		t.Fatalf("database file has mode %o, want 600", mode)
+	}
+}
+
+func TestMigrateSealsTOTPSecrets(t *testing.T) {
+	const secret = "JBSWY3DPEHPK3PXP"
+	snapshot := `{"schema_version": 15, "journal_seq": 0, "sequences": {"users": 1},
+		"users": {"1": {"id": 1, "email": "a@example.com", "handle": "a"}},
+		"totp": {"1": {"user_id": 1, "secret": "` + secret + `", "enabled": true}}}`
+	path := writeTestDB(t, snapshot)
+	if err := os.Chmod(path, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	db := reopenTestDB(t, path)
+	defer db.Close()
+	sealed := db.data.TOTP[1].Secret
+	if opened, err := openSecret(testTokenKey, 1, sealed); err != nil || opened != secret {
+		t.Fatalf("migrated secret %q opens to %q, %v; want %q", sealed, opened, err, secret)
+	}
+	if _, err := openSecret(testTokenKey, 2, sealed); err == nil {
+		t.Fatal("sealed secret opened for another user")
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mode := info.Mode().Perm(); mode != 0600 {
+		t.Fatalf("migrated database file has mode %o, want 600", mode)
+	}
+}
@@ -0,0 +1,324 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"time"
+)
+
+type migration struct {
+	version     int
+	description string
+	up          func(dbStructure *DBStructure) error
+}
+
+// migrations is the ordered list of changes to the database.json layout.
+// Append new entries with the next version number; never edit old ones.
+var migrations = []migration{
+	{
+		version:     1,
+		description: "persist per-collection ID sequences",
+		up: func(dbStructure *DBStructure) error {
+			dbStructure.repairSequences()
+			return nil
+		},
+	},
+	{
+		version:     2,
+		description: "add chirp timestamps and revision history",
+		up: func(dbStructure *DBStructure) error {
+			// When older chirps were posted wasn't recorded. They are dated
+			// the Unix epoch rather than made up, which keeps them in ID
+			// order ahead of everything posted since.
+			epoch := time.Unix(0, 0).UTC()
+			for id, chirp := range dbStructure.Chirps {
+				chirp.CreatedAt = epoch
+				chirp.UpdatedAt = epoch
+				dbStructure.Chirps[id] = chirp
+			}
+			if dbStructure.Revisions == nil {
+				dbStructure.Revisions = make(map[int][]ChirpRevision)
+			}
+			return nil
+		},
+	},
+	{
+		version:     3,
+		description: "extract hashtags and mentions from chirp bodies",
+		up: func(dbStructure *DBStructure) error {
+			dbStructure.buildIndexes()
+			for id, chirp := range dbStructure.Chirps {
+				chirp.Entities = parseEntities(chirp.Body, dbStructure.resolveMentionByEmail)
+				dbStructure.Chirps[id] = chirp
+			}
+			return nil
+		},
+	},
+	{
+		version:     4,
+		description: "add reply threads",
+		up: func(dbStructure *DBStructure) error {
+			for id, chirp := range dbStructure.Chirps {
+				chirp.RootID = id
+				dbStructure.Chirps[id] = chirp
+			}
+			return nil
+		},
+	},
+	{
+		version:     5,
+		description: "add chirp likes",
+		up: func(dbStructure *DBStructure) error {
+			if dbStructure.Likes == nil {
+				dbStructure.Likes = make(map[int][]Like)
+			}
+			return nil
+		},
+	},
+	{
+		version:     6,
+		description: "add rechirps and quotes",
+		up: func(dbStructure *DBStructure) error {
+			for id, chirp := range dbStructure.Chirps {
+				chirp.Kind = ChirpKindChirp
+				dbStructure.Chirps[id] = chirp
+			}
+			return nil
+		},
+	},
+	{
+		version:     7,
+		description: "add follows",
+		up: func(dbStructure *DBStructure) error {
+			if dbStructure.Follows == nil {
+				dbStructure.Follows = make(map[int]Follow)
+			}
+			return nil
+		},
+	},
+	{
+		version:     8,
+		description: "add user profiles with unique handles",
+		up: func(dbStructure *DBStructure) error {
+			ids := make([]int, 0, len(dbStructure.Users))
+			for id := range dbStructure.Users {
+				ids = append(ids, id)
+			}
+			sort.Ints(ids)
+
+			taken := make(map[string]bool)
+			for _, id := range ids {
+				user := dbStructure.Users[id]
+				user.Handle = handleFromEmail(user.Email, func(handle string) bool { return taken[handle] })
+				taken[user.Handle] = true
+				dbStructure.Users[id] = user
+			}
+			return nil
+		},
+	},
+	{
+		version:     9,
+		description: "add media uploads",
+		up: func(dbStructure *DBStructure) error {
+			if dbStructure.Media == nil {
+				dbStructure.Media = make(map[int]Media)
+			}
+			return nil
+		},
+	},
+	{
+		version:     10,
+		description: "add drafts and scheduled chirps",
+		up: func(dbStructure *DBStructure) error {
+			if dbStructure.Drafts == nil {
+				dbStructure.Drafts = make(map[int]Draft)
+			}
+			return nil
+		},
+	},
+	{
+		version:     11,
+		description: "add bookmarks",
+		up: func(dbStructure *DBStructure) error {
+			if dbStructure.Bookmarks == nil {
+				dbStructure.Bookmarks = make(map[int][]Bookmark)
+			}
+			return nil
+		},
+	},
+	{
+		version:     12,
+		description: "move refresh tokens into sessions",
+		up: func(dbStructure *DBStructure) error {
+			if dbStructure.Sessions == nil {
+				dbStructure.Sessions = make(map[int]Session)
+			}
+			if dbStructure.RefreshTokens == nil {
+				dbStructure.RefreshTokens = make(map[int]RefreshToken)
+			}
+
+			userIDs := make([]int, 0, len(dbStructure.Users))
+			for id := range dbStructure.Users {
+				userIDs = append(userIDs, id)
+			}
+			sort.Ints(userIDs)
+
+			now := time.Now().UTC()
+			for _, id := range userIDs {
+				user := dbStructure.Users[id]
+				if user.RefreshToken != "" && user.RefreshTokenExpiry != nil && user.RefreshTokenExpiry.After(now) {
+					session := Session{
+						ID:         dbStructure.nextID(collectionSessions),
+						UserID:     user.ID,
+						CreatedAt:  now,
+						LastUsedAt: now,
+						ExpiresAt:  user.RefreshTokenExpiry.UTC(),
+					}
+					dbStructure.Sessions[session.ID] = session
+					dbStructure.bumpSequence(collectionSessions, session.ID)
+
+					token := RefreshToken{
+						ID:        dbStructure.nextID(collectionRefreshTokens),
+						SessionID: session.ID,
+						Token:     user.RefreshToken,
+						CreatedAt: now,
+					}
+					dbStructure.RefreshTokens[token.ID] = token
+					dbStructure.bumpSequence(collectionRefreshTokens, token.ID)
+				}
+				user.RefreshToken = ""
+				user.RefreshTokenExpiry = nil
+				dbStructure.Users[id] = user
+			}
+			return nil
+		},
+	},
+	{
+		version:     13,
+		description: "hash refresh tokens at rest, ending sessions with plaintext tokens",
+		up: func(dbStructure *DBStructure) error {
+			for _, token := range dbStructure.RefreshTokens {
+				if token.Token != "" {
+					delete(dbStructure.Sessions, token.SessionID)
+				}
+			}
+			for id, token := range dbStructure.RefreshTokens {
+				if _, ok := dbStructure.Sessions[token.SessionID]; !ok {
+					delete(dbStructure.RefreshTokens, id)
+				}
+			}
+			return nil
+		},
+	},
+	{
+		version:     14,
+		description: "add two-factor authentication",
+		up: func(dbStructure *DBStructure) error {
+			if dbStructure.TOTP == nil {
+				dbStructure.TOTP = make(map[int]TOTP)
+			}
+			if dbStructure.MFAChallenges == nil {
+				dbStructure.MFAChallenges = make(map[int]MFAChallenge)
+			}
+			return nil
+		},
+	},
+	{
+		version:     15,
+		description: "add password reset and email verification tokens",
+		up: func(dbStructure *DBStructure) error {
+			if dbStructure.EmailTokens == nil {
+				dbStructure.EmailTokens = make(map[int]EmailToken)
+			}
+			// Users who signed up before addresses were verified are taken
+			// at their word, so requiring verification doesn't lock them out.
+			for id, user := range dbStructure.Users {
+				user.EmailVerified = true
+				dbStructure.Users[id] = user
+			}
+			return nil
+		},
+	},
+	{
+		version:     16,
+		description: "encrypt TOTP secrets and count wrong codes per user",
+		up: func(dbStructure *DBStructure) error {
+			for userID, t := range dbStructure.TOTP {
+				sealed, err := sealSecret(dbStructure.tokenKey, userID, t.Secret)
+				if err != nil {
+					return err
+				}
+				t.Secret = sealed
+				dbStructure.TOTP[userID] = t
+			}
+			return nil
+		},
+	},
+	{
+		version:     17,
+		description: "start the journal of pre-journal snapshots at 2",
+		up: func(dbStructure *DBStructure) error {
+			// Snapshots written before the journal existed hold data that no
+			// journal covers. Skipping sequence 1 keeps recoverDB from
+			// taking their journal for the whole history of the database.
+			if dbStructure.JournalSeq == 0 {
+				dbStructure.JournalSeq = 1
+			}
+			return nil
+		},
+	},
+}
+
+func latestSchemaVersion() int {
+	return migrations[len(migrations)-1].version
+}
+
+// migrate runs every migration newer than the document's schema version and
+// returns the ones that were applied.
+func (dbStructure *DBStructure) migrate() ([]migration, error) {
+	return dbStructure.migrateTo(latestSchemaVersion())
+}
+
+// migrateTo runs the migrations from the document's schema version up to and
+// including version.
+func (dbStructure *DBStructure) migrateTo(version int) ([]migration, error) {
+	if dbStructure.SchemaVersion > latestSchemaVersion() || version > latestSchemaVersion() {
+		return nil, fmt.Errorf("database schema version %d is newer than supported version %d",
+			max(dbStructure.SchemaVersion, version), latestSchemaVersion())
+	}
+
+	applied := []migration{}
+	for _, m := range migrations {
+		if m.version <= dbStructure.SchemaVersion || m.version > version {
+			continue
+		}
+		if err := m.up(dbStructure); err != nil {
+			return applied, fmt.Errorf("migration %d (%s): %v", m.version, m.description, err)
+		}
+		dbStructure.SchemaVersion = m.version
+		applied = append(applied, m)
+	}
+	return applied, nil
+}
+
+// DryRunMigrations runs the pending migrations for the database at path
+// against an in-memory copy and describes them without writing anything.
+func DryRunMigrations(path string) ([]string, error) {
+	db := &DB{path: path}
+	dbStructure, err := db.loadDB()
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	applied, err := dbStructure.migrate()
+	descriptions := make([]string, 0, len(applied))
+	for _, m := range applied {
+		descriptions = append(descriptions, fmt.Sprintf("%d: %s", m.version, m.description))
+	}
+	return descriptions, err
+}
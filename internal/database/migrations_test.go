@@ -0,0 +1,93 @@
+package database
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// legacySnapshot is a database.json from before schema versions existed.
+const legacySnapshot = `{
+	"chirps": {"1": {"id": 1, "body": "hello @a", "author_id": 1}, "2": {"id": 2, "body": "#world", "author_id": 1}},
+	"users": {"1": {"id": 1, "email": "a@example.com", "password": "aGFzaA=="}}
+}`
+
+func TestMigrateLegacySnapshot(t *testing.T) {
+	path := writeTestDB(t, legacySnapshot)
+	db := reopenTestDB(t, path)
+	defer db.Close()
+
+	if db.data.SchemaVersion != latestSchemaVersion() {
+		t.Fatalf("schema version %d, want %d", db.data.SchemaVersion, latestSchemaVersion())
+	}
+	if db.data.JournalSeq != 1 {
+		t.Fatalf("journal sequence %d, want 1 so the journal doesn't pass for the full history", db.data.JournalSeq)
+	}
+	for id := 1; id <= 2; id++ {
+		chirp, err := db.GetChirpByID(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if chirp.Kind != ChirpKindChirp || chirp.RootID != id {
+			t.Fatalf("chirp %d migrated to kind %q, root %d", id, chirp.Kind, chirp.RootID)
+		}
+		if !chirp.CreatedAt.Equal(time.Unix(0, 0)) {
+			t.Fatalf("chirp %d dated %v, want the Unix epoch", id, chirp.CreatedAt)
+		}
+	}
+	if account, err := db.GetAccount(1); err != nil || !account.EmailVerified {
+		t.Fatalf("existing user not grandfathered as verified: %+v, %v", account, err)
+	}
+
+	chirp, err := db.CreateChirp(ChirpParams{Body: "new", AuthorID: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if chirp.ID != 3 {
+		t.Fatalf("new chirp got ID %d after migrating, want 3", chirp.ID)
+	}
+}
+
+func TestSQLiteMigrateFromSchema1(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "database.db")
+	legacy, err := openSQLite(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, stmt := range []string{
+		sqliteMigrations[0].statements,
+		`INSERT INTO users (email, password, refresh_token_expiry) VALUES ('a@example.com', 'hash', '1970-01-01 00:00:00')`,
+		`INSERT INTO chirps (body, author_id) VALUES ('hello', 1), ('world', 1)`,
+		`PRAGMA user_version = 1`,
+	} {
+		if _, err := legacy.conn.Exec(stmt); err != nil {
+			t.Fatal(err)
+		}
+	}
+	legacy.Close()
+
+	db, err := NewSQLiteDB(path, FanOutOnRead, testTokenKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	for id := 1; id <= 2; id++ {
+		chirp, err := db.GetChirpByID(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !chirp.CreatedAt.Equal(time.Unix(0, 0)) {
+			t.Fatalf("chirp %d dated %v, want the Unix epoch", id, chirp.CreatedAt)
+		}
+	}
+	if account, err := db.GetAccount(1); err != nil || !account.EmailVerified {
+		t.Fatalf("existing user not grandfathered as verified: %+v, %v", account, err)
+	}
+	user, err := db.CreateUser("b@example.com", "", []byte("hash"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if account, err := db.GetAccount(user.ID); err != nil || account.EmailVerified {
+		t.Fatalf("new user starts out verified: %+v, %v", account, err)
+	}
+}
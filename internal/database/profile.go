@@ -0,0 +1,117 @@
+package database
+
+import (
+	"net/url"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	minHandleLength      = 3
+	maxHandleLength      = 15
+	maxDisplayNameLength = 50
+	maxBioLength         = 160
+	maxAvatarURLLength   = 2048
+)
+
+var handleRegex = regexp.MustCompile(`^[a-z0-9_]+$`)
+
+// reservedHandles cannot be claimed by users, since they would be mistaken
+// for the service itself or clash with routes.
+var reservedHandles = []string{
+	"admin", "administrator", "api", "app", "chirpy", "everyone", "help",
+	"here", "me", "mod", "moderator", "null", "root", "settings", "staff",
+	"support", "system", "undefined",
+}
+
+// normalizeHandle lowercases handle and drops a leading @, so handles can
+// be looked up the way they are written in chirps.
+func normalizeHandle(handle string) string {
+	return strings.ToLower(strings.TrimPrefix(handle, "@"))
+}
+
+func validateHandle(handle string) error {
+	if len(handle) < minHandleLength || len(handle) > maxHandleLength || !handleRegex.MatchString(handle) {
+		return ErrInvalidHandle
+	}
+	if slices.Contains(reservedHandles, handle) {
+		return ErrHandleReserved
+	}
+	return nil
+}
+
+// handleFromEmail derives a valid handle from the local part of email,
+// adding a number to it if needed to get one that is long enough and not
+// taken, so "a@example.com" gets "a10".
+func handleFromEmail(email string, taken func(handle string) bool) string {
+	local, _, _ := strings.Cut(strings.ToLower(email), "@")
+	base := strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= '0' && r <= '9' || r == '_' {
+			return r
+		}
+		return '_'
+	}, local)
+
+	handle := base[:min(len(base), maxHandleLength)]
+	for n := 1; validateHandle(handle) != nil || taken(handle); n++ {
+		suffix := strconv.Itoa(n)
+		handle = base[:min(len(base), maxHandleLength-len(suffix))] + suffix
+	}
+	return handle
+}
+
+// ProfileUpdate holds the profile fields to change; nil fields are left as
+// they are.
+type ProfileUpdate struct {
+	Handle      *string
+	DisplayName *string
+	Bio         *string
+	AvatarURL   *string
+}
+
+func (p *ProfileUpdate) validate() error {
+	if p.Handle != nil {
+		handle := normalizeHandle(*p.Handle)
+		if err := validateHandle(handle); err != nil {
+			return err
+		}
+		p.Handle = &handle
+	}
+	if p.DisplayName != nil && utf8.RuneCountInString(*p.DisplayName) > maxDisplayNameLength {
+		return ErrDisplayNameTooLong
+	}
+	if p.Bio != nil && utf8.RuneCountInString(*p.Bio) > maxBioLength {
+		return ErrBioTooLong
+	}
+	if p.AvatarURL != nil && *p.AvatarURL != "" && !p.uploadedAvatar() {
+		u, err := url.Parse(*p.AvatarURL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || len(*p.AvatarURL) > maxAvatarURLLength {
+			return ErrInvalidAvatarURL
+		}
+	}
+	return nil
+}
+
+// uploadedAvatar reports whether the avatar URL points to uploaded media,
+// which has to be the user's own.
+func (p ProfileUpdate) uploadedAvatar() bool {
+	return p.AvatarURL != nil && strings.HasPrefix(*p.AvatarURL, "/media/")
+}
+
+func (p ProfileUpdate) apply(user *User) {
+	if p.Handle != nil {
+		user.Handle = *p.Handle
+	}
+	if p.DisplayName != nil {
+		user.DisplayName = *p.DisplayName
+	}
+	if p.Bio != nil {
+		user.Bio = *p.Bio
+	}
+	if p.AvatarURL != nil {
+		user.AvatarURL = *p.AvatarURL
+	}
+}
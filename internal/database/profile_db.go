@@ -0,0 +1,69 @@
+package database
+
+func (db *DB) GetUserByID(id int) (UserResp, error) {
+	db.mux.RLock()
+	defer db.mux.RUnlock()
+
+	dbStructure := db.data
+
+	user, ok := dbStructure.Users[id]
+	if !ok {
+		return UserResp{}, ErrUserNotFound
+	}
+	return user.public(), nil
+}
+
+// GetAccount returns the account of userID, for the user themselves.
+func (db *DB) GetAccount(userID int) (AccountResp, error) {
+	db.mux.RLock()
+	defer db.mux.RUnlock()
+
+	user, ok := db.data.Users[userID]
+	if !ok {
+		return AccountResp{}, ErrUserNotFound
+	}
+	return user.account(), nil
+}
+
+func (db *DB) GetUserByHandle(handle string) (UserResp, error) {
+	db.mux.RLock()
+	defer db.mux.RUnlock()
+
+	dbStructure := db.data
+
+	id, ok := dbStructure.idx.userByHandle[normalizeHandle(handle)]
+	if !ok {
+		return UserResp{}, ErrUserNotFound
+	}
+	return dbStructure.Users[id].public(), nil
+}
+
+func (db *DB) UpdateProfile(userID int, update ProfileUpdate) (AccountResp, error) {
+	if err := update.validate(); err != nil {
+		return AccountResp{}, err
+	}
+
+	db.mux.Lock()
+	defer db.mux.Unlock()
+
+	dbStructure := db.data
+
+	user, ok := dbStructure.Users[userID]
+	if !ok {
+		return AccountResp{}, ErrUserNotFound
+	}
+	if update.Handle != nil {
+		if id, ok := dbStructure.idx.userByHandle[*update.Handle]; ok && id != userID {
+			return AccountResp{}, ErrHandleTaken
+		}
+	}
+	if update.uploadedAvatar() && !dbStructure.ownsMediaURL(userID, *update.AvatarURL) {
+		return AccountResp{}, ErrInvalidAvatarURL
+	}
+
+	update.apply(&user)
+	if err := db.commit(putEntry(collectionUsers, userID, user)); err != nil {
+		return AccountResp{}, err
+	}
+	return user.account(), nil
+}
@@ -0,0 +1,78 @@
+package database
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestHandleFromEmail(t *testing.T) {
+	taken := map[string]bool{"bob": true, "admin1": true}
+	tests := []struct {
+		email string
+		want  string
+	}{
+		{"alice@example.com", "alice"},
+		{"Bob@example.com", "bob1"},
+		{"a@example.com", "a10"},
+		{"ab@example.com", "ab1"},
+		{"first.last@example.com", "first_last"},
+		{"admin@example.com", "admin2"},
+		{"averyveryverylongname@example.com", "averyveryverylo"},
+	}
+	for _, tt := range tests {
+		got := handleFromEmail(tt.email, func(handle string) bool { return taken[handle] })
+		if got != tt.want {
+			t.Errorf("handleFromEmail(%q) = %q, want %q", tt.email, got, tt.want)
+		}
+	}
+}
+
+func TestShortMentionsResolveByEmail(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store Store) {
+		user, err := store.CreateUser("a@example.com", "", []byte("hash"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if user.Handle != "a10" {
+			t.Fatalf("derived handle %q, want %q", user.Handle, "a10")
+		}
+
+		chirp, err := store.CreateChirp(ChirpParams{Body: "hi @a and @a10", AuthorID: user.ID})
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, mention := range chirp.Entities.Mentions {
+			if mention.UserID != user.ID {
+				t.Errorf("@%s resolved to user %d, want %d", mention.Name, mention.UserID, user.ID)
+			}
+		}
+	})
+}
+
+func TestAvatarMustBeOwnMedia(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store Store) {
+		alice, err := store.CreateUser("alice@example.com", "", []byte("hash"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		bob, err := store.CreateUser("bob@example.com", "", []byte("hash"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		media, err := store.CreateMedia(Media{OwnerID: alice.ID, URL: "/media/ab/ab.png", ThumbnailURL: "/media/ab/ab_thumb.png"})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for _, url := range []string{media.URL, media.ThumbnailURL} {
+			if _, err := store.UpdateProfile(alice.ID, ProfileUpdate{AvatarURL: &url}); err != nil {
+				t.Errorf("alice setting their avatar to %s: %v", url, err)
+			}
+		}
+		for _, url := range []string{media.URL, "/media/cd/cd.png"} {
+			if _, err := store.UpdateProfile(bob.ID, ProfileUpdate{AvatarURL: &url}); !errors.Is(err, ErrInvalidAvatarURL) {
+				t.Errorf("bob setting their avatar to %s returned %v, want ErrInvalidAvatarURL", url, err)
+			}
+		}
+	})
+}
@@ -0,0 +1,222 @@
+package database
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math"
+	"slices"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+var ErrEmptySearch = errors.New("empty search query")
+
+// searchIndex is what a backend provides for SearchChirps: the positions of
+// a term (or of every term starting with it) in each chirp that contains it.
+type searchIndex interface {
+	postings(term string, prefix bool) (map[int][]int, error)
+	chirpCount() (int, error)
+	chirpsByID(ids []int) (map[int]Chirp, error)
+}
+
+type searchClause struct {
+	terms  []string
+	prefix bool
+}
+
+type searchCursor struct {
+	Score float64 `json:"score"`
+	ID    int     `json:"id"`
+}
+
+type scoredChirp struct {
+	chirp Chirp
+	score float64
+}
+
+func tokenize(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// parseSearch splits q into clauses that must all match: "quoted text" is
+// a phrase, a word ending in * matches any term with that prefix and every
+// other word is a plain term.
+func parseSearch(q string) []searchClause {
+	clauses := []searchClause{}
+	parts := strings.Split(q, `"`)
+	for i, part := range parts {
+		if i%2 == 1 {
+			if terms := tokenize(part); len(terms) > 0 {
+				clauses = append(clauses, searchClause{terms: terms})
+			}
+			continue
+		}
+		for _, field := range strings.Fields(part) {
+			terms := tokenize(field)
+			for j, term := range terms {
+				prefix := j == len(terms)-1 && strings.HasSuffix(field, "*")
+				clauses = append(clauses, searchClause{terms: []string{term}, prefix: prefix})
+			}
+		}
+	}
+	return clauses
+}
+
+// matchClause returns how many times the clause occurs in each chirp.
+func matchClause(index searchIndex, clause searchClause) (map[int]int, error) {
+	first, err := index.postings(clause.terms[0], clause.prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	counts := make(map[int]int, len(first))
+	if len(clause.terms) == 1 {
+		for id, positions := range first {
+			counts[id] = len(positions)
+		}
+		return counts, nil
+	}
+
+	rest := make([]map[int][]int, 0, len(clause.terms)-1)
+	for _, term := range clause.terms[1:] {
+		p, err := index.postings(term, false)
+		if err != nil {
+			return nil, err
+		}
+		rest = append(rest, p)
+	}
+
+	for id, positions := range first {
+		n := 0
+		for _, start := range positions {
+			matched := true
+			for offset, p := range rest {
+				if !slices.Contains(p[id], start+offset+1) {
+					matched = false
+					break
+				}
+			}
+			if matched {
+				n++
+			}
+		}
+		if n > 0 {
+			counts[id] = n
+		}
+	}
+	return counts, nil
+}
+
+// searchChirps ranks the chirps matching every clause of text with a
+// saturated tf-idf score and pages through them by (score, id), highest
+// first. The filters of query apply; its sort order does not.
+func searchChirps(index searchIndex, text string, query ChirpQuery) (ChirpPage, error) {
+	clauses := parseSearch(text)
+	if len(clauses) == 0 {
+		return ChirpPage{}, ErrEmptySearch
+	}
+
+	var after *searchCursor
+	if query.Cursor != "" {
+		c, err := decodeSearchCursor(query.Cursor)
+		if err != nil {
+			return ChirpPage{}, err
+		}
+		after = &c
+		query.Cursor = ""
+	}
+	low, high, err := query.idRange()
+	if err != nil {
+		return ChirpPage{}, err
+	}
+
+	total, err := index.chirpCount()
+	if err != nil {
+		return ChirpPage{}, err
+	}
+
+	var scores map[int]float64
+	for _, clause := range clauses {
+		counts, err := matchClause(index, clause)
+		if err != nil {
+			return ChirpPage{}, err
+		}
+		idf := math.Log(1 + float64(total)/float64(len(counts)+1))
+
+		next := make(map[int]float64, len(counts))
+		for id, tf := range counts {
+			if scores != nil {
+				if _, ok := scores[id]; !ok {
+					continue
+				}
+			}
+			next[id] = scores[id] + float64(tf)/(float64(tf)+1.2)*idf
+		}
+		scores = next
+		if len(scores) == 0 {
+			break
+		}
+	}
+
+	ids := make([]int, 0, len(scores))
+	for id := range scores {
+		if id > low && id <= high {
+			ids = append(ids, id)
+		}
+	}
+	chirps, err := index.chirpsByID(ids)
+	if err != nil {
+		return ChirpPage{}, err
+	}
+
+	results := make([]scoredChirp, 0, len(chirps))
+	for id, chirp := range chirps {
+		if !query.matches(chirp) {
+			continue
+		}
+		score := scores[id]
+		if after != nil && (score > after.Score || score == after.Score && id >= after.ID) {
+			continue
+		}
+		results = append(results, scoredChirp{chirp: chirp, score: score})
+	}
+
+	sort.Slice(results, func(i, j int) bool {
+		if results[i].score != results[j].score {
+			return results[i].score > results[j].score
+		}
+		return results[i].chirp.ID > results[j].chirp.ID
+	})
+
+	page := ChirpPage{Chirps: make([]Chirp, 0, len(results))}
+	for i, result := range results {
+		if query.Limit > 0 && i == query.Limit {
+			last := results[i-1]
+			page.NextCursor = encodeSearchCursor(searchCursor{Score: last.score, ID: last.chirp.ID})
+			break
+		}
+		page.Chirps = append(page.Chirps, result.chirp)
+	}
+	return page, nil
+}
+
+func encodeSearchCursor(c searchCursor) string {
+	data, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeSearchCursor(cursor string) (searchCursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return searchCursor{}, ErrInvalidCursor
+	}
+	c := searchCursor{}
+	if err := json.Unmarshal(data, &c); err != nil || c.ID == 0 {
+		return searchCursor{}, ErrInvalidCursor
+	}
+	return c, nil
+}
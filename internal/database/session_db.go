@@ -0,0 +1,276 @@
+package database
+
+import (
+	"crypto/subtle"
+	"time"
+	"unicode/utf8"
+)
+
+// A Session is one login of a user, on one device. It lasts refreshTokenTTL
+// from the login however often it is refreshed.
+type Session struct {
+	ID         int       `json:"id"`
+	UserID     int       `json:"user_id"`
+	Device     string    `json:"device"`
+	IP         string    `json:"ip"`
+	CreatedAt  time.Time `json:"created_at"`
+	LastUsedAt time.Time `json:"last_used_at"`
+	ExpiresAt  time.Time `json:"expires_at"`
+}
+
+// A RefreshToken belongs to a session. Every refresh rotates it: the token
+// is marked rotated and a new one is issued. The last maxRotatedTokens
+// rotated tokens are kept for as long as their session, so one coming back
+// can be recognized as stolen. Only the keyed hash of a token is stored.
+type RefreshToken struct {
+	ID        int       `json:"id"`
+	SessionID int       `json:"session_id"`
+	TokenHash string    `json:"token_hash"`
+	Rotated   bool      `json:"rotated"`
+	CreatedAt time.Time `json:"created_at"`
+
+	// Token is the plaintext token stored before tokens were hashed. It is
+	// only read by the migration that ends the sessions holding one.
+	Token string `json:"token,omitempty"`
+}
+
+// SessionInfo describes the client a session is used from.
+type SessionInfo struct {
+	Device string
+	IP     string
+}
+
+const (
+	maxDeviceLength  = 200
+	maxRotatedTokens = 20
+)
+
+// device returns the device name to store, cut down to maxDeviceLength
+// bytes without splitting a character.
+func (client SessionInfo) device() string {
+	device := client.Device
+	if len(device) <= maxDeviceLength {
+		return device
+	}
+	device = device[:maxDeviceLength]
+	for len(device) > 0 && !utf8.ValidString(device) {
+		device = device[:len(device)-1]
+	}
+	return device
+}
+
+// newSession starts a session for userID and returns its ID and first
+// refresh token. The user's expired sessions are removed along the way.
+func (dbStructure *DBStructure) newSession(userID int, client SessionInfo, tokenKey []byte) (int, string, []journalEntry, error) {
+	refreshToken, err := generateToken()
+	if err != nil {
+		return 0, "", nil, err
+	}
+
+	now := time.Now().UTC()
+	entries := []journalEntry{}
+	for _, id := range dbStructure.idx.sessionsByUser[userID] {
+		if !dbStructure.Sessions[id].ExpiresAt.After(now) {
+			entries = append(entries, dbStructure.revokeSession(id)...)
+		}
+	}
+
+	session := Session{
+		ID:         dbStructure.nextID(collectionSessions),
+		UserID:     userID,
+		Device:     client.device(),
+		IP:         client.IP,
+		CreatedAt:  now,
+		LastUsedAt: now,
+		ExpiresAt:  now.Add(refreshTokenTTL),
+	}
+	token := RefreshToken{
+		ID:        dbStructure.nextID(collectionRefreshTokens),
+		SessionID: session.ID,
+		TokenHash: hashToken(tokenKey, refreshToken),
+		CreatedAt: now,
+	}
+	entries = append(entries,
+		putEntry(collectionSessions, session.ID, session),
+		putEntry(collectionRefreshTokens, token.ID, token),
+	)
+	return session.ID, refreshToken, entries, nil
+}
+
+// revokeSession returns the entries that delete a session and its tokens.
+func (dbStructure *DBStructure) revokeSession(sessionID int) []journalEntry {
+	entries := []journalEntry{}
+	for _, id := range dbStructure.idx.refreshTokensBySession[sessionID] {
+		entries = append(entries, deleteEntry(collectionRefreshTokens, id))
+	}
+	return append(entries, deleteEntry(collectionSessions, sessionID))
+}
+
+func (dbStructure *DBStructure) refreshTokenByValue(refreshToken string, tokenKey []byte) (RefreshToken, bool) {
+	tokenHash := hashToken(tokenKey, refreshToken)
+	id, ok := dbStructure.idx.refreshTokenByHash[tokenHash]
+	if !ok {
+		return RefreshToken{}, false
+	}
+	token := dbStructure.RefreshTokens[id]
+	if subtle.ConstantTimeCompare([]byte(token.TokenHash), []byte(tokenHash)) != 1 {
+		return RefreshToken{}, false
+	}
+	return token, true
+}
+
+// RefreshAccessToken signs a new access token and rotates refreshToken.
+// Presenting a token that was already rotated revokes its session, since
+// either the client or whoever copied the token is not supposed to have it.
+func (db *DB) RefreshAccessToken(refreshToken string, signer TokenSigner, ip string) (RefreshResp, error) {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+
+	dbStructure := db.data
+
+	token, ok := dbStructure.refreshTokenByValue(refreshToken, db.tokenKey)
+	if !ok {
+		return RefreshResp{}, ErrRefreshTokenNotFound
+	}
+	session := dbStructure.Sessions[token.SessionID]
+	if token.Rotated {
+		if err := db.commit(dbStructure.revokeSession(session.ID)...); err != nil {
+			return RefreshResp{}, err
+		}
+		return RefreshResp{}, ErrRefreshTokenReused
+	}
+	now := time.Now().UTC()
+	if !session.ExpiresAt.After(now) {
+		return RefreshResp{}, ErrRefreshTokenExpired
+	}
+
+	tokenString, err := signAccessToken("chirpy-refresh", session.UserID, session.ID, signer)
+	if err != nil {
+		return RefreshResp{}, err
+	}
+	newRefreshToken, err := generateToken()
+	if err != nil {
+		return RefreshResp{}, err
+	}
+
+	token.Rotated = true
+	newToken := RefreshToken{
+		ID:        dbStructure.nextID(collectionRefreshTokens),
+		SessionID: session.ID,
+		TokenHash: hashToken(db.tokenKey, newRefreshToken),
+		CreatedAt: now,
+	}
+	session.LastUsedAt = now
+	if ip != "" {
+		session.IP = ip
+	}
+	entries := []journalEntry{
+		putEntry(collectionRefreshTokens, token.ID, token),
+		putEntry(collectionRefreshTokens, newToken.ID, newToken),
+		putEntry(collectionSessions, session.ID, session),
+	}
+	// Every token of the session but the new one has been rotated now.
+	rotated := dbStructure.idx.refreshTokensBySession[session.ID]
+	for _, id := range rotated[:max(len(rotated)-maxRotatedTokens, 0)] {
+		entries = append(entries, deleteEntry(collectionRefreshTokens, id))
+	}
+	if err := db.commit(entries...); err != nil {
+		return RefreshResp{}, err
+	}
+
+	return RefreshResp{Token: tokenString, RefreshToken: newRefreshToken}, nil
+}
+
+// RevokeRefreshToken ends the session refreshToken belongs to.
+func (db *DB) RevokeRefreshToken(refreshToken string) error {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+
+	dbStructure := db.data
+
+	token, ok := dbStructure.refreshTokenByValue(refreshToken, db.tokenKey)
+	if !ok {
+		return ErrRefreshTokenNotFound
+	}
+	return db.commit(dbStructure.revokeSession(token.SessionID)...)
+}
+
+// GetSessions returns the unexpired sessions of userID, oldest first.
+func (db *DB) GetSessions(userID int) ([]Session, error) {
+	db.mux.RLock()
+	defer db.mux.RUnlock()
+
+	now := time.Now().UTC()
+	sessions := []Session{}
+	for _, id := range db.data.idx.sessionsByUser[userID] {
+		if session := db.data.Sessions[id]; session.ExpiresAt.After(now) {
+			sessions = append(sessions, session)
+		}
+	}
+	return sessions, nil
+}
+
+func (db *DB) RevokeSession(sessionID int, userID int) error {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+
+	dbStructure := db.data
+
+	session, ok := dbStructure.Sessions[sessionID]
+	if !ok || session.UserID != userID {
+		return ErrSessionNotFound
+	}
+	return db.commit(dbStructure.revokeSession(sessionID)...)
+}
+
+// revokeOtherSessions returns the entries that end every session of userID
+// except keepSessionID.
+func (dbStructure *DBStructure) revokeOtherSessions(userID int, keepSessionID int) []journalEntry {
+	entries := []journalEntry{}
+	for _, id := range dbStructure.idx.sessionsByUser[userID] {
+		if id != keepSessionID {
+			entries = append(entries, dbStructure.revokeSession(id)...)
+		}
+	}
+	return entries
+}
+
+// PruneExpiredSessions deletes the sessions that expired by now and returns
+// how many there were.
+func (db *DB) PruneExpiredSessions(now time.Time) (int, error) {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+
+	dbStructure := db.data
+
+	entries := []journalEntry{}
+	pruned := 0
+	for _, expiring := range dbStructure.idx.sessionsByExpiry {
+		if expiring.at.After(now) {
+			break
+		}
+		entries = append(entries, dbStructure.revokeSession(expiring.id)...)
+		pruned++
+	}
+	if pruned == 0 {
+		return 0, nil
+	}
+	if err := db.commit(entries...); err != nil {
+		return 0, err
+	}
+	return pruned, nil
+}
+
+// RevokeSessions ends every session of userID.
+func (db *DB) RevokeSessions(userID int) error {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+
+	dbStructure := db.data
+
+	entries := dbStructure.revokeOtherSessions(userID, 0)
+	if len(entries) == 0 {
+		return nil
+	}
+	return db.commit(entries...)
+}
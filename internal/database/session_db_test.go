@@ -0,0 +1,151 @@
+package database
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// testSigner signs access tokens with testTokenKey.
+type testSigner struct{}
+
+func (testSigner) Sign(claims jwt.Claims) (string, error) {
+	return jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(testTokenKey)
+}
+
+// loginTestUser creates a user and logs them in, returning the user's ID
+// and first refresh token.
+func loginTestUser(t testing.TB, store Store, email string) (int, string) {
+	t.Helper()
+	hash, err := bcrypt.GenerateFromPassword([]byte("password"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	user, err := store.CreateUser(email, "", hash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return user.ID, login(t, store, email)
+}
+
+func login(t testing.TB, store Store, email string) string {
+	t.Helper()
+	resp, challenge, err := store.Login(email, "password", testSigner{}, SessionInfo{Device: "test"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if challenge != nil {
+		t.Fatal("login asked for a second factor")
+	}
+	return resp.RefreshToken
+}
+
+func TestRotatedRefreshTokensAreCapped(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store Store) {
+		_, first := loginTestUser(t, store, "a@example.com")
+
+		tokens := []string{first}
+		for range maxRotatedTokens + 1 {
+			resp, err := store.RefreshAccessToken(tokens[len(tokens)-1], testSigner{}, "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			tokens = append(tokens, resp.RefreshToken)
+		}
+
+		if _, err := store.RefreshAccessToken(first, testSigner{}, ""); !errors.Is(err, ErrRefreshTokenNotFound) {
+			t.Fatalf("refreshing with a token rotated out of the history returned %v, want ErrRefreshTokenNotFound", err)
+		}
+		if _, err := store.RefreshAccessToken(tokens[1], testSigner{}, ""); !errors.Is(err, ErrRefreshTokenReused) {
+			t.Fatalf("refreshing with a remembered rotated token returned %v, want ErrRefreshTokenReused", err)
+		}
+	})
+}
+
+func TestPruneExpiredSessions(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store Store) {
+		userID, refreshToken := loginTestUser(t, store, "a@example.com")
+
+		if pruned, err := store.PruneExpiredSessions(time.Now()); err != nil || pruned != 0 {
+			t.Fatalf("PruneExpiredSessions before expiry = %d, %v; want 0", pruned, err)
+		}
+		pruned, err := store.PruneExpiredSessions(time.Now().Add(refreshTokenTTL + time.Hour))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if pruned != 1 {
+			t.Fatalf("pruned %d sessions, want 1", pruned)
+		}
+		if _, err := store.RefreshAccessToken(refreshToken, testSigner{}, ""); !errors.Is(err, ErrRefreshTokenNotFound) {
+			t.Fatalf("refreshing a pruned session returned %v, want ErrRefreshTokenNotFound", err)
+		}
+		if sessions, err := store.GetSessions(userID); err != nil || len(sessions) != 0 {
+			t.Fatalf("GetSessions after pruning = %v, %v", sessions, err)
+		}
+	})
+}
+
+func TestPasswordChangeEndsOtherSessions(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store Store) {
+		userID, current := loginTestUser(t, store, "a@example.com")
+		other := login(t, store, "a@example.com")
+
+		sessions, err := store.GetSessions(userID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(sessions) != 2 {
+			t.Fatalf("user has %d sessions, want 2", len(sessions))
+		}
+		if _, err := store.UpdateUser(userID, "a@example.com", []byte("new"), sessions[0].ID); err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := store.RefreshAccessToken(other, testSigner{}, ""); !errors.Is(err, ErrRefreshTokenNotFound) {
+			t.Fatalf("refreshing another session after a password change returned %v, want ErrRefreshTokenNotFound", err)
+		}
+		if _, err := store.RefreshAccessToken(current, testSigner{}, ""); err != nil {
+			t.Fatalf("the session the password was changed from was ended: %v", err)
+		}
+	})
+}
+
+func TestRefreshRotatesToken(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store Store) {
+		_, first := loginTestUser(t, store, "a@example.com")
+
+		resp, err := store.RefreshAccessToken(first, testSigner{}, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.Token == "" || resp.RefreshToken == "" || resp.RefreshToken == first {
+			t.Fatalf("refresh returned %+v, want an access token and a new refresh token", resp)
+		}
+		if _, err := store.RefreshAccessToken(resp.RefreshToken, testSigner{}, ""); err != nil {
+			t.Fatalf("refreshing with the rotated-in token: %v", err)
+		}
+	})
+}
+
+func TestReusedRefreshTokenEndsSession(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store Store) {
+		userID, first := loginTestUser(t, store, "a@example.com")
+		resp, err := store.RefreshAccessToken(first, testSigner{}, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := store.RefreshAccessToken(first, testSigner{}, ""); !errors.Is(err, ErrRefreshTokenReused) {
+			t.Fatalf("reusing a rotated token returned %v, want ErrRefreshTokenReused", err)
+		}
+		if _, err := store.RefreshAccessToken(resp.RefreshToken, testSigner{}, ""); !errors.Is(err, ErrRefreshTokenNotFound) {
+			t.Fatalf("refreshing after reuse returned %v, want ErrRefreshTokenNotFound", err)
+		}
+		if sessions, err := store.GetSessions(userID); err != nil || len(sessions) != 0 {
+			t.Fatalf("GetSessions after reuse = %v, %v; want none", sessions, err)
+		}
+	})
+}
@@ -0,0 +1,600 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+type sqliteMigration struct {
+	version     int
+	description string
+	statements  string
+	up          func(tx *sql.Tx, tokenKey []byte) error
+}
+
+// sqliteMigrations is the ordered list of schema changes for the SQLite
+// backend, tracked with PRAGMA user_version. Never edit applied entries.
+var sqliteMigrations = []sqliteMigration{
+	{
+		version:     1,
+		description: "create users and chirps tables",
+		statements: `
+CREATE TABLE IF NOT EXISTS users (
+	id                   INTEGER PRIMARY KEY AUTOINCREMENT,
+	email                TEXT NOT NULL UNIQUE,
+	password             BLOB NOT NULL,
+	refresh_token        TEXT NOT NULL DEFAULT '',
+	refresh_token_expiry TIMESTAMP NOT NULL,
+	is_chirpy_red        BOOLEAN NOT NULL DEFAULT FALSE
+);
+
+CREATE INDEX IF NOT EXISTS idx_users_refresh_token ON users (refresh_token);
+
+CREATE TABLE IF NOT EXISTS chirps (
+	id        INTEGER PRIMARY KEY AUTOINCREMENT,
+	body      TEXT NOT NULL,
+	author_id INTEGER NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_chirps_author_id ON chirps (author_id);
+`,
+	},
+	{
+		version:     2,
+		description: "add chirp timestamps and revision history",
+		// Existing chirps keep the Unix epoch default: when they were posted
+		// wasn't recorded, and the epoch keeps them in ID order ahead of
+		// everything posted since.
+		statements: `
+ALTER TABLE chirps ADD COLUMN created_at TIMESTAMP NOT NULL DEFAULT '1970-01-01 00:00:00';
+ALTER TABLE chirps ADD COLUMN updated_at TIMESTAMP NOT NULL DEFAULT '1970-01-01 00:00:00';
+
+CREATE TABLE chirp_revisions (
+	chirp_id   INTEGER NOT NULL REFERENCES chirps (id) ON DELETE CASCADE,
+	revision   INTEGER NOT NULL,
+	body       TEXT NOT NULL,
+	created_at TIMESTAMP NOT NULL,
+	PRIMARY KEY (chirp_id, revision)
+);
+`,
+	},
+	{
+		version:     3,
+		description: "add full-text search index over chirp bodies",
+		statements: `
+CREATE TABLE chirp_terms (
+	term     TEXT NOT NULL,
+	chirp_id INTEGER NOT NULL REFERENCES chirps (id) ON DELETE CASCADE,
+	position INTEGER NOT NULL,
+	PRIMARY KEY (term, chirp_id, position)
+) WITHOUT ROWID;
+
+CREATE INDEX idx_chirp_terms_chirp_id ON chirp_terms (chirp_id);
+`,
+		up: func(tx *sql.Tx, _ []byte) error {
+			chirps, err := sqliteChirpBodies(tx)
+			if err != nil {
+				return err
+			}
+
+			stmt, err := tx.Prepare(`INSERT OR IGNORE INTO chirp_terms (term, chirp_id, position) VALUES (?, ?, ?)`)
+			if err != nil {
+				return err
+			}
+			defer stmt.Close()
+			for _, chirp := range chirps {
+				for position, term := range tokenize(chirp.Body) {
+					if _, err := stmt.Exec(term, chirp.ID, position); err != nil {
+						return err
+					}
+				}
+			}
+			return nil
+		},
+	},
+	{
+		version:     4,
+		description: "extract hashtags and mentions from chirp bodies",
+		statements: `
+ALTER TABLE chirps ADD COLUMN entities TEXT NOT NULL DEFAULT '{"hashtags":[],"mentions":[]}';
+
+CREATE TABLE chirp_hashtags (
+	tag      TEXT NOT NULL,
+	chirp_id INTEGER NOT NULL REFERENCES chirps (id) ON DELETE CASCADE,
+	PRIMARY KEY (tag, chirp_id)
+) WITHOUT ROWID;
+
+CREATE INDEX idx_chirp_hashtags_chirp_id ON chirp_hashtags (chirp_id);
+
+CREATE TABLE chirp_mentions (
+	user_id  INTEGER NOT NULL,
+	chirp_id INTEGER NOT NULL REFERENCES chirps (id) ON DELETE CASCADE,
+	PRIMARY KEY (user_id, chirp_id)
+) WITHOUT ROWID;
+
+CREATE INDEX idx_chirp_mentions_chirp_id ON chirp_mentions (chirp_id);
+`,
+		up: func(tx *sql.Tx, _ []byte) error {
+			chirps, err := sqliteChirpBodies(tx)
+			if err != nil {
+				return err
+			}
+			resolve := sqliteEmailMentionResolver(tx)
+			for _, chirp := range chirps {
+				chirp.Entities = parseEntities(chirp.Body, resolve)
+				if _, err := tx.Exec(`UPDATE chirps SET entities = ? WHERE id = ?`, chirp.Entities, chirp.ID); err != nil {
+					return err
+				}
+				for _, tag := range chirpHashtags(chirp) {
+					if _, err := tx.Exec(`INSERT INTO chirp_hashtags (tag, chirp_id) VALUES (?, ?)`, tag, chirp.ID); err != nil {
+						return err
+					}
+				}
+				for _, userID := range chirpMentions(chirp) {
+					if _, err := tx.Exec(`INSERT INTO chirp_mentions (user_id, chirp_id) VALUES (?, ?)`, userID, chirp.ID); err != nil {
+						return err
+					}
+				}
+			}
+			return nil
+		},
+	},
+	{
+		version:     5,
+		description: "add reply threads",
+		statements: `
+ALTER TABLE chirps ADD COLUMN parent_id INTEGER REFERENCES chirps (id);
+ALTER TABLE chirps ADD COLUMN root_id INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE chirps ADD COLUMN deleted BOOLEAN NOT NULL DEFAULT FALSE;
+UPDATE chirps SET root_id = id;
+
+CREATE INDEX idx_chirps_parent_id ON chirps (parent_id);
+CREATE INDEX idx_chirps_root_id ON chirps (root_id);
+`,
+	},
+	{
+		version:     6,
+		description: "add chirp likes",
+		statements: `
+ALTER TABLE chirps ADD COLUMN like_count INTEGER NOT NULL DEFAULT 0;
+
+CREATE TABLE chirp_likes (
+	chirp_id   INTEGER NOT NULL REFERENCES chirps (id) ON DELETE CASCADE,
+	user_id    INTEGER NOT NULL,
+	created_at TIMESTAMP NOT NULL,
+	PRIMARY KEY (chirp_id, user_id)
+) WITHOUT ROWID;
+
+CREATE INDEX idx_chirp_likes_user_id ON chirp_likes (user_id, chirp_id);
+`,
+	},
+	{
+		version:     7,
+		description: "add rechirps and quotes",
+		statements: `
+ALTER TABLE chirps ADD COLUMN kind TEXT NOT NULL DEFAULT 'chirp';
+ALTER TABLE chirps ADD COLUMN original_id INTEGER;
+ALTER TABLE chirps ADD COLUMN rechirp_count INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE chirps ADD COLUMN quote_count INTEGER NOT NULL DEFAULT 0;
+
+CREATE INDEX idx_chirps_original_id ON chirps (original_id, kind);
+`,
+	},
+	{
+		version:     8,
+		description: "add follows and home timelines",
+		statements: `
+CREATE TABLE follows (
+	id          INTEGER PRIMARY KEY AUTOINCREMENT,
+	follower_id INTEGER NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+	followee_id INTEGER NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+	created_at  TIMESTAMP NOT NULL,
+	UNIQUE (follower_id, followee_id)
+);
+
+CREATE INDEX idx_follows_followee_id ON follows (followee_id, follower_id);
+
+CREATE TABLE timeline_entries (
+	user_id  INTEGER NOT NULL,
+	chirp_id INTEGER NOT NULL REFERENCES chirps (id) ON DELETE CASCADE,
+	PRIMARY KEY (user_id, chirp_id)
+) WITHOUT ROWID;
+
+CREATE INDEX idx_timeline_entries_chirp_id ON timeline_entries (chirp_id);
+
+CREATE TABLE settings (
+	name  TEXT PRIMARY KEY,
+	value TEXT NOT NULL
+);
+`,
+	},
+	{
+		version:     9,
+		description: "add user profiles with unique handles",
+		statements: `
+ALTER TABLE users ADD COLUMN handle TEXT;
+ALTER TABLE users ADD COLUMN display_name TEXT NOT NULL DEFAULT '';
+ALTER TABLE users ADD COLUMN bio TEXT NOT NULL DEFAULT '';
+ALTER TABLE users ADD COLUMN avatar_url TEXT NOT NULL DEFAULT '';
+
+CREATE UNIQUE INDEX idx_users_handle ON users (handle);
+`,
+		up: func(tx *sql.Tx, _ []byte) error {
+			rows, err := tx.Query(`SELECT id, email FROM users ORDER BY id`)
+			if err != nil {
+				return err
+			}
+			users := []User{}
+			for rows.Next() {
+				user := User{}
+				if err := rows.Scan(&user.ID, &user.Email); err != nil {
+					rows.Close()
+					return err
+				}
+				users = append(users, user)
+			}
+			rows.Close()
+			if err := rows.Err(); err != nil {
+				return err
+			}
+
+			taken := make(map[string]bool)
+			for _, user := range users {
+				handle := handleFromEmail(user.Email, func(handle string) bool { return taken[handle] })
+				taken[handle] = true
+				if _, err := tx.Exec(`UPDATE users SET handle = ? WHERE id = ?`, handle, user.ID); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+	},
+	{
+		version:     10,
+		description: "add media uploads",
+		statements: `
+CREATE TABLE media (
+	id            INTEGER PRIMARY KEY AUTOINCREMENT,
+	owner_id      INTEGER NOT NULL REFERENCES users (id),
+	hash          TEXT NOT NULL,
+	content_type  TEXT NOT NULL,
+	size          INTEGER NOT NULL,
+	width         INTEGER NOT NULL,
+	height        INTEGER NOT NULL,
+	url           TEXT NOT NULL,
+	thumbnail_url TEXT NOT NULL,
+	created_at    DATETIME NOT NULL
+);
+
+ALTER TABLE chirps ADD COLUMN media TEXT NOT NULL DEFAULT '[]';
+`,
+	},
+	{
+		version:     11,
+		description: "add moderation flags to chirps",
+		statements: `
+ALTER TABLE chirps ADD COLUMN flagged BOOLEAN NOT NULL DEFAULT FALSE;
+
+CREATE INDEX idx_chirps_flagged ON chirps (id) WHERE flagged;
+`,
+	},
+	{
+		version:     12,
+		description: "add drafts and scheduled chirps",
+		statements: `
+CREATE TABLE drafts (
+	id          INTEGER PRIMARY KEY AUTOINCREMENT,
+	author_id   INTEGER NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+	body        TEXT NOT NULL,
+	parent_id   INTEGER,
+	kind        TEXT NOT NULL,
+	original_id INTEGER,
+	media_ids   TEXT NOT NULL DEFAULT '[]',
+	flagged     BOOLEAN NOT NULL DEFAULT FALSE,
+	status      TEXT NOT NULL,
+	publish_at  DATETIME,
+	created_at  DATETIME NOT NULL
+);
+
+CREATE INDEX idx_drafts_author ON drafts (author_id, id);
+CREATE INDEX idx_drafts_due ON drafts (publish_at) WHERE status = 'scheduled';
+`,
+	},
+	{
+		version:     13,
+		description: "add bookmarks",
+		statements: `
+CREATE TABLE chirp_bookmarks (
+	chirp_id   INTEGER NOT NULL REFERENCES chirps (id) ON DELETE CASCADE,
+	user_id    INTEGER NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+	created_at TIMESTAMP NOT NULL,
+	PRIMARY KEY (chirp_id, user_id)
+) WITHOUT ROWID;
+
+CREATE INDEX idx_chirp_bookmarks_user_id ON chirp_bookmarks (user_id, chirp_id);
+`,
+	},
+	{
+		version:     14,
+		description: "move refresh tokens into sessions",
+		statements: `
+CREATE TABLE sessions (
+	id           INTEGER PRIMARY KEY AUTOINCREMENT,
+	user_id      INTEGER NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+	device       TEXT NOT NULL DEFAULT '',
+	ip           TEXT NOT NULL DEFAULT '',
+	created_at   TIMESTAMP NOT NULL,
+	last_used_at TIMESTAMP NOT NULL,
+	expires_at   TIMESTAMP NOT NULL
+);
+
+CREATE INDEX idx_sessions_user_id ON sessions (user_id);
+
+CREATE TABLE refresh_tokens (
+	token      TEXT PRIMARY KEY,
+	session_id INTEGER NOT NULL REFERENCES sessions (id) ON DELETE CASCADE,
+	rotated    BOOLEAN NOT NULL DEFAULT FALSE,
+	created_at TIMESTAMP NOT NULL
+) WITHOUT ROWID;
+
+CREATE INDEX idx_refresh_tokens_session_id ON refresh_tokens (session_id);
+
+INSERT INTO sessions (user_id, created_at, last_used_at, expires_at)
+SELECT id, datetime('now'), datetime('now'), refresh_token_expiry FROM users
+WHERE refresh_token != '' ORDER BY id;
+
+INSERT INTO refresh_tokens (token, session_id, created_at)
+SELECT users.refresh_token, sessions.id, sessions.created_at FROM users
+JOIN sessions ON sessions.user_id = users.id;
+
+DROP INDEX idx_users_refresh_token;
+ALTER TABLE users DROP COLUMN refresh_token;
+ALTER TABLE users DROP COLUMN refresh_token_expiry;
+`,
+	},
+	{
+		version:     15,
+		description: "hash refresh tokens at rest, ending sessions with plaintext tokens",
+		statements: `
+DELETE FROM sessions;
+ALTER TABLE refresh_tokens RENAME COLUMN token TO token_hash;
+`,
+	},
+	{
+		version:     16,
+		description: "add two-factor authentication",
+		statements: `
+CREATE TABLE totp (
+	user_id        INTEGER PRIMARY KEY REFERENCES users (id) ON DELETE CASCADE,
+	secret         TEXT NOT NULL,
+	enabled        BOOLEAN NOT NULL DEFAULT FALSE,
+	last_step      INTEGER NOT NULL DEFAULT 0,
+	recovery_codes TEXT NOT NULL DEFAULT '[]',
+	created_at     TIMESTAMP NOT NULL
+);
+
+CREATE TABLE mfa_challenges (
+	id         INTEGER PRIMARY KEY AUTOINCREMENT,
+	user_id    INTEGER NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+	token_hash TEXT NOT NULL UNIQUE,
+	attempts   INTEGER NOT NULL DEFAULT 0,
+	expires_at TIMESTAMP NOT NULL
+);
+
+CREATE INDEX idx_mfa_challenges_expires_at ON mfa_challenges (expires_at);
+`,
+	},
+	{
+		version:     17,
+		description: "add password reset and email verification tokens",
+		statements: `
+ALTER TABLE users ADD COLUMN email_verified BOOLEAN NOT NULL DEFAULT FALSE;
+
+-- Users who signed up before addresses were verified are taken at their
+-- word, so requiring verification doesn't lock them out.
+UPDATE users SET email_verified = TRUE;
+
+CREATE TABLE email_tokens (
+	id         INTEGER PRIMARY KEY AUTOINCREMENT,
+	user_id    INTEGER NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+	purpose    TEXT NOT NULL,
+	email      TEXT NOT NULL,
+	token_hash TEXT NOT NULL UNIQUE,
+	expires_at TIMESTAMP NOT NULL
+);
+
+CREATE INDEX idx_email_tokens_user_id ON email_tokens (user_id, purpose);
+`,
+	},
+	{
+		version:     18,
+		description: "index media by owner for avatar checks",
+		statements: `
+CREATE INDEX idx_media_owner_id ON media (owner_id);
+`,
+	},
+	{
+		version:     19,
+		description: "index sessions by expiry for pruning",
+		statements: `
+CREATE INDEX idx_sessions_expires_at ON sessions (expires_at);
+`,
+	},
+	{
+		version:     20,
+		description: "encrypt TOTP secrets and count wrong codes per user",
+		statements: `
+ALTER TABLE totp ADD COLUMN failures INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE totp ADD COLUMN locked_until TIMESTAMP;
+`,
+		up: func(tx *sql.Tx, tokenKey []byte) error {
+			rows, err := tx.Query(`SELECT user_id, secret FROM totp`)
+			if err != nil {
+				return err
+			}
+			secrets := map[int]string{}
+			for rows.Next() {
+				var userID int
+				var secret string
+				if err := rows.Scan(&userID, &secret); err != nil {
+					rows.Close()
+					return err
+				}
+				secrets[userID] = secret
+			}
+			rows.Close()
+			if err := rows.Err(); err != nil {
+				return err
+			}
+
+			for userID, secret := range secrets {
+				sealed, err := sealSecret(tokenKey, userID, secret)
+				if err != nil {
+					return err
+				}
+				if _, err := tx.Exec(`UPDATE totp SET secret = ? WHERE user_id = ?`, sealed, userID); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+	},
+}
+
+type SQLiteDB struct {
+	conn         *sql.DB
+	timelineMode TimelineMode
+	tokenKey     []byte
+}
+
+func NewSQLiteDB(path string, timelineMode TimelineMode, tokenKey []byte) (*SQLiteDB, error) {
+	db, err := openSQLite(path)
+	if err != nil {
+		return nil, err
+	}
+	db.timelineMode = timelineMode
+	db.tokenKey = tokenKey
+	if err := db.ensureDB(); err != nil {
+		db.Close()
+		return nil, err
+	}
+	if err := db.syncTimelines(); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return db, nil
+}
+
+func openSQLite(path string) (*SQLiteDB, error) {
+	dsn := "file:" + path + "?_busy_timeout=5000&_journal_mode=WAL&_foreign_keys=on&_txlock=immediate"
+	conn, err := sql.Open("sqlite3", dsn)
+	if err != nil {
+		return nil, err
+	}
+	return &SQLiteDB{conn: conn}, nil
+}
+
+func (db *SQLiteDB) Close() error {
+	return db.conn.Close()
+}
+
+func (db *SQLiteDB) ensureDB() error {
+	applied, err := db.migrate(false)
+	if err != nil {
+		return err
+	}
+	for _, m := range applied {
+		log.Printf("applied database migration %d: %s", m.version, m.description)
+	}
+	return nil
+}
+
+// migrate applies pending migrations in a single transaction, which is
+// rolled back instead of committed when dryRun is set.
+func (db *SQLiteDB) migrate(dryRun bool) ([]sqliteMigration, error) {
+	latest := sqliteMigrations[len(sqliteMigrations)-1].version
+
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var version int
+	if err := tx.QueryRow(`PRAGMA user_version`).Scan(&version); err != nil {
+		return nil, err
+	}
+	if version > latest {
+		return nil, fmt.Errorf("database schema version %d is newer than supported version %d", version, latest)
+	}
+
+	applied := []sqliteMigration{}
+	for _, m := range sqliteMigrations {
+		if m.version <= version {
+			continue
+		}
+		if _, err := tx.Exec(m.statements); err != nil {
+			return applied, fmt.Errorf("migration %d (%s): %v", m.version, m.description, err)
+		}
+		if m.up != nil {
+			if err := m.up(tx, db.tokenKey); err != nil {
+				return applied, fmt.Errorf("migration %d (%s): %v", m.version, m.description, err)
+			}
+		}
+		if _, err := tx.Exec(fmt.Sprintf(`PRAGMA user_version = %d`, m.version)); err != nil {
+			return applied, err
+		}
+		applied = append(applied, m)
+	}
+
+	if dryRun {
+		return applied, nil
+	}
+	return applied, tx.Commit()
+}
+
+// DryRunSQLiteMigrations runs the pending migrations for the SQLite database
+// at path inside a transaction that is rolled back, and describes them.
+func DryRunSQLiteMigrations(path string) ([]string, error) {
+	if _, err := os.Stat(path); errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+
+	db, err := openSQLite(path)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	applied, err := db.migrate(true)
+	descriptions := make([]string, 0, len(applied))
+	for _, m := range applied {
+		descriptions = append(descriptions, fmt.Sprintf("%d: %s", m.version, m.description))
+	}
+	return descriptions, err
+}
+
+// sqliteChirpBodies loads only the ID and body of every chirp, for
+// migrations that must not depend on columns added after them.
+func sqliteChirpBodies(tx *sql.Tx) ([]Chirp, error) {
+	rows, err := tx.Query(`SELECT id, body FROM chirps`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	chirps := []Chirp{}
+	for rows.Next() {
+		chirp := Chirp{}
+		if err := rows.Scan(&chirp.ID, &chirp.Body); err != nil {
+			return nil, err
+		}
+		chirps = append(chirps, chirp)
+	}
+	return chirps, rows.Err()
+}
@@ -0,0 +1,42 @@
+package database
+
+import "time"
+
+func (db *SQLiteDB) BookmarkChirp(chirpID int, userID int) error {
+	return db.updateBookmark(chirpID,
+		`INSERT OR IGNORE INTO chirp_bookmarks (chirp_id, user_id, created_at) VALUES (?, ?, ?)`,
+		chirpID, userID, time.Now().UTC(),
+	)
+}
+
+func (db *SQLiteDB) UnbookmarkChirp(chirpID int, userID int) error {
+	return db.updateBookmark(chirpID, `DELETE FROM chirp_bookmarks WHERE chirp_id = ? AND user_id = ?`, chirpID, userID)
+}
+
+// updateBookmark runs stmt against chirp_bookmarks if chirpID exists.
+func (db *SQLiteDB) updateBookmark(chirpID int, stmt string, args ...any) error {
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var exists bool
+	err = tx.QueryRow(`SELECT EXISTS (SELECT 1 FROM chirps WHERE id = ? AND NOT deleted)`, chirpID).Scan(&exists)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return ErrChirpNotFound
+	}
+
+	if _, err = tx.Exec(stmt, args...); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (db *SQLiteDB) GetBookmarks(userID int, query ChirpQuery) (ChirpPage, error) {
+	query.bookmarkedByUserID = userID
+	return db.GetChirps(query)
+}
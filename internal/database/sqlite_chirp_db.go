@@ -0,0 +1,429 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+)
+
+const sqliteChirpColumns = `id, body, author_id, entities, created_at, updated_at, COALESCE(parent_id, 0), root_id, deleted, kind, COALESCE(original_id, 0), media, flagged, like_count, rechirp_count, quote_count`
+
+func scanChirp(row interface{ Scan(...any) error }) (Chirp, error) {
+	chirp := Chirp{}
+	err := row.Scan(
+		&chirp.ID,
+		&chirp.Body,
+		&chirp.AuthorID,
+		&chirp.Entities,
+		&chirp.CreatedAt,
+		&chirp.UpdatedAt,
+		&chirp.ParentID,
+		&chirp.RootID,
+		&chirp.Deleted,
+		&chirp.Kind,
+		&chirp.OriginalID,
+		&chirp.Media,
+		&chirp.Flagged,
+		&chirp.LikeCount,
+		&chirp.RechirpCount,
+		&chirp.QuoteCount,
+	)
+	return chirp, err
+}
+
+// scanChirps reads every row of a chirp query. It takes the error from the
+// query as well so calls can be written as scanChirps(db.conn.Query(...)).
+func scanChirps(rows *sql.Rows, err error) ([]Chirp, error) {
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	chirps := []Chirp{}
+	for rows.Next() {
+		chirp, err := scanChirp(rows)
+		if err != nil {
+			return nil, err
+		}
+		chirps = append(chirps, chirp)
+	}
+	return chirps, rows.Err()
+}
+
+// sqliteIndexChirp fills the lookup tables derived from a chirp's body:
+// search terms, hashtags and resolved mentions.
+func sqliteIndexChirp(tx *sql.Tx, chirp Chirp) error {
+	stmt, err := tx.Prepare(`INSERT OR IGNORE INTO chirp_terms (term, chirp_id, position) VALUES (?, ?, ?)`)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	for position, term := range tokenize(chirp.Body) {
+		if _, err := stmt.Exec(term, chirp.ID, position); err != nil {
+			return err
+		}
+	}
+	for _, tag := range chirpHashtags(chirp) {
+		if _, err := tx.Exec(`INSERT INTO chirp_hashtags (tag, chirp_id) VALUES (?, ?)`, tag, chirp.ID); err != nil {
+			return err
+		}
+	}
+	for _, userID := range chirpMentions(chirp) {
+		if _, err := tx.Exec(`INSERT INTO chirp_mentions (user_id, chirp_id) VALUES (?, ?)`, userID, chirp.ID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func sqliteUnindexChirp(tx *sql.Tx, chirpID int) error {
+	for _, table := range []string{"chirp_terms", "chirp_hashtags", "chirp_mentions"} {
+		if _, err := tx.Exec(`DELETE FROM `+table+` WHERE chirp_id = ?`, chirpID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// sqliteMentionResolver resolves mentions by handle, falling back to
+// sqliteEmailMentionResolver for names too short to be a handle.
+func sqliteMentionResolver(tx *sql.Tx) func(name string) (int, bool) {
+	byEmail := sqliteEmailMentionResolver(tx)
+	return func(name string) (int, bool) {
+		if len(name) < minHandleLength {
+			return byEmail(name)
+		}
+		var id int
+		if err := tx.QueryRow(`SELECT id FROM users WHERE handle = ?`, normalizeHandle(name)).Scan(&id); err != nil {
+			return 0, false
+		}
+		return id, true
+	}
+}
+
+// sqliteEmailMentionResolver resolves mentions against the local part of
+// user emails, ignoring names shared by more than one user. Mentions worked
+// this way before users had handles, and migrations written back then rely
+// on it.
+func sqliteEmailMentionResolver(tx *sql.Tx) func(name string) (int, bool) {
+	return func(name string) (int, bool) {
+		rows, err := tx.Query(
+			`SELECT id FROM users WHERE lower(substr(email, 1, instr(email, '@') - 1)) = ? LIMIT 2`,
+			strings.ToLower(name),
+		)
+		if err != nil {
+			return 0, false
+		}
+		defer rows.Close()
+
+		ids := []int{}
+		for rows.Next() {
+			var id int
+			if err := rows.Scan(&id); err != nil {
+				return 0, false
+			}
+			ids = append(ids, id)
+		}
+		if len(ids) != 1 {
+			return 0, false
+		}
+		return ids[0], true
+	}
+}
+
+// sqliteSharedChirp looks up the chirp that replies, rechirps and quotes of
+// id refer to: the chirp itself, or its original if it is a rechirp. It
+// returns the ID and root ID of that chirp.
+func sqliteSharedChirp(tx *sql.Tx, id int) (int, int, error) {
+	var sharedID, rootID int
+	err := tx.QueryRow(
+		`SELECT id, root_id FROM chirps
+		WHERE id = (SELECT CASE kind WHEN 'rechirp' THEN original_id ELSE id END FROM chirps WHERE id = ?)
+		AND NOT deleted`,
+		id,
+	).Scan(&sharedID, &rootID)
+	return sharedID, rootID, err
+}
+
+func (db *SQLiteDB) CreateChirp(params ChirpParams) (Chirp, error) {
+	if err := params.validate(); err != nil {
+		return Chirp{}, err
+	}
+
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return Chirp{}, err
+	}
+	defer tx.Rollback()
+
+	chirp, err := db.insertChirp(tx, params)
+	if err != nil {
+		return Chirp{}, err
+	}
+	if err = tx.Commit(); err != nil {
+		return Chirp{}, err
+	}
+	return chirp, nil
+}
+
+// insertChirp stores the chirp described by params, which must already be
+// validated, as part of tx.
+func (db *SQLiteDB) insertChirp(tx *sql.Tx, params ChirpParams) (Chirp, error) {
+	var err error
+	now := time.Now().UTC()
+	chirp := Chirp{
+		Body:      params.Body,
+		AuthorID:  params.AuthorID,
+		Entities:  parseEntities(params.Body, sqliteMentionResolver(tx)),
+		CreatedAt: now,
+		UpdatedAt: now,
+		Kind:      params.Kind,
+		Flagged:   params.Flagged,
+	}
+
+	if chirp.Media, err = sqliteChirpMedia(tx, params.MediaIDs, params.AuthorID); err != nil {
+		return Chirp{}, err
+	}
+
+	var parent any
+	if params.ParentID != 0 {
+		chirp.ParentID, chirp.RootID, err = sqliteSharedChirp(tx, params.ParentID)
+		if errors.Is(err, sql.ErrNoRows) {
+			return Chirp{}, ErrParentNotFound
+		}
+		if err != nil {
+			return Chirp{}, err
+		}
+		parent = chirp.ParentID
+	}
+
+	var original any
+	if params.Kind != ChirpKindChirp {
+		chirp.OriginalID, _, err = sqliteSharedChirp(tx, params.OriginalID)
+		if errors.Is(err, sql.ErrNoRows) {
+			return Chirp{}, ErrOriginalNotFound
+		}
+		if err != nil {
+			return Chirp{}, err
+		}
+		original = chirp.OriginalID
+
+		counter := "quote_count"
+		if params.Kind == ChirpKindRechirp {
+			var rechirped bool
+			err = tx.QueryRow(
+				`SELECT EXISTS (SELECT 1 FROM chirps WHERE kind = ? AND original_id = ? AND author_id = ?)`,
+				ChirpKindRechirp, chirp.OriginalID, chirp.AuthorID,
+			).Scan(&rechirped)
+			if err != nil {
+				return Chirp{}, err
+			}
+			if rechirped {
+				return Chirp{}, ErrAlreadyRechirped
+			}
+			counter = "rechirp_count"
+		}
+		if _, err = tx.Exec(`UPDATE chirps SET `+counter+` = `+counter+` + 1 WHERE id = ?`, chirp.OriginalID); err != nil {
+			return Chirp{}, err
+		}
+	}
+
+	res, err := tx.Exec(
+		`INSERT INTO chirps (body, author_id, entities, created_at, updated_at, parent_id, root_id, kind, original_id, media, flagged)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		chirp.Body, chirp.AuthorID, chirp.Entities, chirp.CreatedAt, chirp.UpdatedAt, parent, chirp.RootID, chirp.Kind, original, chirp.Media, chirp.Flagged,
+	)
+	if err != nil {
+		return Chirp{}, err
+	}
+
+	id, err := res.LastInsertId()
+	if err != nil {
+		return Chirp{}, err
+	}
+	chirp.ID = int(id)
+
+	if chirp.RootID == 0 {
+		chirp.RootID = chirp.ID
+		if _, err = tx.Exec(`UPDATE chirps SET root_id = id WHERE id = ?`, chirp.ID); err != nil {
+			return Chirp{}, err
+		}
+	}
+
+	if err = sqliteIndexChirp(tx, chirp); err != nil {
+		return Chirp{}, err
+	}
+	if db.timelineMode == FanOutOnWrite {
+		_, err = tx.Exec(
+			`INSERT INTO timeline_entries (user_id, chirp_id)
+			SELECT ?, ? UNION SELECT follower_id, ? FROM follows WHERE followee_id = ?`,
+			chirp.AuthorID, chirp.ID, chirp.ID, chirp.AuthorID,
+		)
+		if err != nil {
+			return Chirp{}, err
+		}
+	}
+	return chirp, nil
+}
+
+func (db *SQLiteDB) GetChirps(query ChirpQuery) (ChirpPage, error) {
+	low, high, err := query.idRange()
+	if err != nil {
+		return ChirpPage{}, err
+	}
+
+	stmt := `SELECT ` + sqliteChirpColumns + ` FROM chirps WHERE id > ? AND id <= ? AND NOT deleted`
+	args := []any{low, high}
+	if query.timelineUserID != 0 && db.timelineMode == FanOutOnWrite {
+		stmt += ` AND id IN (SELECT chirp_id FROM timeline_entries WHERE user_id = ?)`
+		args = append(args, query.timelineUserID)
+	} else if query.timelineUserID != 0 {
+		stmt += ` AND (author_id = ? OR author_id IN (SELECT followee_id FROM follows WHERE follower_id = ?))`
+		args = append(args, query.timelineUserID, query.timelineUserID)
+	}
+	if query.bookmarkedByUserID != 0 {
+		stmt += ` AND id IN (SELECT chirp_id FROM chirp_bookmarks WHERE user_id = ?)`
+		args = append(args, query.bookmarkedByUserID)
+	}
+	if query.AuthorID != 0 {
+		stmt += ` AND author_id = ?`
+		args = append(args, query.AuthorID)
+	}
+	if query.LikedByUserID != 0 {
+		stmt += ` AND id IN (SELECT chirp_id FROM chirp_likes WHERE user_id = ?)`
+		args = append(args, query.LikedByUserID)
+	}
+	if query.Hashtag != "" {
+		stmt += ` AND id IN (SELECT chirp_id FROM chirp_hashtags WHERE tag = ?)`
+		args = append(args, normalizeTag(query.Hashtag))
+	}
+	if query.MentionedUserID != 0 {
+		stmt += ` AND id IN (SELECT chirp_id FROM chirp_mentions WHERE user_id = ?)`
+		args = append(args, query.MentionedUserID)
+	}
+	if query.Flagged {
+		stmt += ` AND flagged`
+	}
+	if !query.CreatedAfter.IsZero() {
+		stmt += ` AND created_at >= ?`
+		args = append(args, query.CreatedAfter.UTC())
+	}
+	if !query.CreatedBefore.IsZero() {
+		stmt += ` AND created_at < ?`
+		args = append(args, query.CreatedBefore.UTC())
+	}
+	if query.Descending {
+		stmt += ` ORDER BY id DESC`
+	} else {
+		stmt += ` ORDER BY id ASC`
+	}
+	if query.Limit > 0 {
+		stmt += ` LIMIT ?`
+		args = append(args, query.Limit+1)
+	}
+
+	chirps, err := scanChirps(db.conn.Query(stmt, args...))
+	if err != nil {
+		return ChirpPage{}, err
+	}
+	return query.newPage(chirps), nil
+}
+
+func (db *SQLiteDB) GetChirpByID(id int) (Chirp, error) {
+	chirp, err := scanChirp(db.conn.QueryRow(`SELECT `+sqliteChirpColumns+` FROM chirps WHERE id = ? AND NOT deleted`, id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return Chirp{}, errors.New("id not found")
+	}
+	if err != nil {
+		return Chirp{}, err
+	}
+	return chirp, nil
+}
+
+func (db *SQLiteDB) DeleteChirp(chirpID int, userID int) error {
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var authorID, originalID, replies int
+	var kind string
+	err = tx.QueryRow(
+		`SELECT author_id, kind, COALESCE(original_id, 0), (SELECT COUNT(*) FROM chirps WHERE parent_id = ?)
+		FROM chirps WHERE id = ? AND NOT deleted`,
+		chirpID, chirpID,
+	).Scan(&authorID, &kind, &originalID, &replies)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrChirpNotFound
+	}
+	if err != nil {
+		return err
+	}
+
+	if authorID != userID {
+		return ErrAccessDenied
+	}
+
+	if kind != ChirpKindChirp {
+		counter := "quote_count"
+		if kind == ChirpKindRechirp {
+			counter = "rechirp_count"
+		}
+		if _, err = tx.Exec(`UPDATE chirps SET `+counter+` = `+counter+` - 1 WHERE id = ? AND NOT deleted`, originalID); err != nil {
+			return err
+		}
+	}
+	if _, err = tx.Exec(`DELETE FROM chirps WHERE kind = ? AND original_id = ?`, ChirpKindRechirp, chirpID); err != nil {
+		return err
+	}
+
+	if replies > 0 {
+		_, err = tx.Exec(
+			`UPDATE chirps SET body = '', entities = ?, updated_at = ?, deleted = TRUE, media = '[]', flagged = FALSE,
+			like_count = 0, rechirp_count = 0, quote_count = 0 WHERE id = ?`,
+			ChirpEntities{Hashtags: []Hashtag{}, Mentions: []Mention{}}, time.Now().UTC(), chirpID,
+		)
+		if err != nil {
+			return err
+		}
+		if err = sqliteUnindexChirp(tx, chirpID); err != nil {
+			return err
+		}
+		for _, table := range []string{"chirp_revisions", "chirp_likes", "chirp_bookmarks", "timeline_entries"} {
+			if _, err = tx.Exec(`DELETE FROM `+table+` WHERE chirp_id = ?`, chirpID); err != nil {
+				return err
+			}
+		}
+		return tx.Commit()
+	}
+
+	for {
+		var parentID sql.NullInt64
+		if err = tx.QueryRow(`SELECT parent_id FROM chirps WHERE id = ?`, chirpID).Scan(&parentID); err != nil {
+			return err
+		}
+		if _, err = tx.Exec(`DELETE FROM chirps WHERE id = ?`, chirpID); err != nil {
+			return err
+		}
+		if !parentID.Valid {
+			break
+		}
+
+		var deleted bool
+		err = tx.QueryRow(
+			`SELECT deleted AND NOT EXISTS (SELECT 1 FROM chirps WHERE parent_id = ?) FROM chirps WHERE id = ?`,
+			parentID.Int64, parentID.Int64,
+		).Scan(&deleted)
+		if err != nil {
+			return err
+		}
+		if !deleted {
+			break
+		}
+		chirpID = int(parentID.Int64)
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,102 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+)
+
+func (db *SQLiteDB) LikeChirp(chirpID int, userID int) (Chirp, error) {
+	return db.updateLike(chirpID,
+		`INSERT OR IGNORE INTO chirp_likes (chirp_id, user_id, created_at) VALUES (?, ?, ?)`,
+		chirpID, userID, time.Now().UTC(),
+	)
+}
+
+func (db *SQLiteDB) UnlikeChirp(chirpID int, userID int) (Chirp, error) {
+	return db.updateLike(chirpID, `DELETE FROM chirp_likes WHERE chirp_id = ? AND user_id = ?`, chirpID, userID)
+}
+
+// updateLike runs stmt against chirp_likes and, if it changed anything,
+// recounts the likes of chirpID.
+func (db *SQLiteDB) updateLike(chirpID int, stmt string, args ...any) (Chirp, error) {
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return Chirp{}, err
+	}
+	defer tx.Rollback()
+
+	var exists bool
+	err = tx.QueryRow(`SELECT EXISTS (SELECT 1 FROM chirps WHERE id = ? AND NOT deleted)`, chirpID).Scan(&exists)
+	if err != nil {
+		return Chirp{}, err
+	}
+	if !exists {
+		return Chirp{}, ErrChirpNotFound
+	}
+
+	res, err := tx.Exec(stmt, args...)
+	if err != nil {
+		return Chirp{}, err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return Chirp{}, err
+	}
+	if n > 0 {
+		_, err = tx.Exec(
+			`UPDATE chirps SET like_count = (SELECT COUNT(*) FROM chirp_likes WHERE chirp_id = ?) WHERE id = ?`,
+			chirpID, chirpID,
+		)
+		if err != nil {
+			return Chirp{}, err
+		}
+	}
+
+	chirp, err := scanChirp(tx.QueryRow(`SELECT `+sqliteChirpColumns+` FROM chirps WHERE id = ?`, chirpID))
+	if errors.Is(err, sql.ErrNoRows) {
+		return Chirp{}, ErrChirpNotFound
+	}
+	if err != nil {
+		return Chirp{}, err
+	}
+	if err = tx.Commit(); err != nil {
+		return Chirp{}, err
+	}
+	return chirp, nil
+}
+
+func (db *SQLiteDB) LikedChirps(userID int, chirpIDs []int) (map[int]bool, error) {
+	liked := make(map[int]bool, len(chirpIDs))
+	for start := 0; start < len(chirpIDs); start += sqliteMaxParams {
+		batch := chirpIDs[start:min(start+sqliteMaxParams, len(chirpIDs))]
+		args := []any{userID}
+		for _, id := range batch {
+			liked[id] = false
+			args = append(args, id)
+		}
+
+		placeholders := strings.TrimSuffix(strings.Repeat("?,", len(batch)), ",")
+		rows, err := db.conn.Query(
+			`SELECT chirp_id FROM chirp_likes WHERE user_id = ? AND chirp_id IN (`+placeholders+`)`,
+			args...,
+		)
+		if err != nil {
+			return nil, err
+		}
+		for rows.Next() {
+			var id int
+			if err := rows.Scan(&id); err != nil {
+				rows.Close()
+				return nil, err
+			}
+			liked[id] = true
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return nil, err
+		}
+	}
+	return liked, nil
+}
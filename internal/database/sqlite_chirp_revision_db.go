@@ -0,0 +1,108 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+)
+
+func (db *SQLiteDB) UpdateChirp(chirpID int, userID int, body string, flagged bool, editWindow time.Duration) (Chirp, error) {
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return Chirp{}, err
+	}
+	defer tx.Rollback()
+
+	chirp, err := scanChirp(tx.QueryRow(`SELECT `+sqliteChirpColumns+` FROM chirps WHERE id = ? AND NOT deleted`, chirpID))
+	if errors.Is(err, sql.ErrNoRows) {
+		return Chirp{}, ErrChirpNotFound
+	}
+	if err != nil {
+		return Chirp{}, err
+	}
+
+	if chirp.AuthorID != userID {
+		return Chirp{}, ErrAccessDenied
+	}
+
+	if chirp.Kind == ChirpKindRechirp {
+		return Chirp{}, ErrRechirpNotEditable
+	}
+
+	now := time.Now().UTC()
+	if now.Sub(chirp.CreatedAt) > editWindow {
+		return Chirp{}, ErrEditWindowExpired
+	}
+
+	_, err = tx.Exec(
+		`INSERT INTO chirp_revisions (chirp_id, revision, body, created_at)
+		SELECT ?, COUNT(*) + 1, ?, ? FROM chirp_revisions WHERE chirp_id = ?`,
+		chirpID, chirp.Body, chirp.UpdatedAt, chirpID,
+	)
+	if err != nil {
+		return Chirp{}, err
+	}
+
+	chirp.Body = body
+	chirp.Entities = parseEntities(body, sqliteMentionResolver(tx))
+	chirp.UpdatedAt = now
+	chirp.Flagged = flagged
+
+	_, err = tx.Exec(
+		`UPDATE chirps SET body = ?, entities = ?, updated_at = ?, flagged = ? WHERE id = ?`,
+		chirp.Body, chirp.Entities, chirp.UpdatedAt, chirp.Flagged, chirpID,
+	)
+	if err != nil {
+		return Chirp{}, err
+	}
+
+	if err = sqliteUnindexChirp(tx, chirpID); err != nil {
+		return Chirp{}, err
+	}
+	if err = sqliteIndexChirp(tx, chirp); err != nil {
+		return Chirp{}, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return Chirp{}, err
+	}
+	return chirp, nil
+}
+
+func (db *SQLiteDB) GetChirpHistory(chirpID int) ([]ChirpRevision, error) {
+	chirp, err := scanChirp(db.conn.QueryRow(`SELECT `+sqliteChirpColumns+` FROM chirps WHERE id = ? AND NOT deleted`, chirpID))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrChirpNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := db.conn.Query(
+		`SELECT revision, body, created_at FROM chirp_revisions WHERE chirp_id = ? ORDER BY revision`,
+		chirpID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	revisions := []ChirpRevision{}
+	for rows.Next() {
+		revision := ChirpRevision{}
+		if err := rows.Scan(&revision.Revision, &revision.Body, &revision.CreatedAt); err != nil {
+			return nil, err
+		}
+		revisions = append(revisions, revision)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	revisions = append(revisions, ChirpRevision{
+		Revision:  len(revisions) + 1,
+		Body:      chirp.Body,
+		CreatedAt: chirp.UpdatedAt,
+	})
+	return revisions, nil
+}
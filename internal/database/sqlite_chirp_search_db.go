@@ -0,0 +1,71 @@
+package database
+
+import (
+	"database/sql"
+	"strings"
+)
+
+const sqliteMaxParams = 500
+
+func (db *SQLiteDB) SearchChirps(text string, query ChirpQuery) (ChirpPage, error) {
+	return searchChirps(db, text, query)
+}
+
+func (db *SQLiteDB) postings(term string, prefix bool) (map[int][]int, error) {
+	var rows *sql.Rows
+	var err error
+	if prefix {
+		upper := []byte(term)
+		upper[len(upper)-1]++
+		rows, err = db.conn.Query(
+			`SELECT chirp_id, position FROM chirp_terms WHERE term >= ? AND term < ?`,
+			term, string(upper),
+		)
+	} else {
+		rows, err = db.conn.Query(`SELECT chirp_id, position FROM chirp_terms WHERE term = ?`, term)
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	postings := make(map[int][]int)
+	for rows.Next() {
+		var chirpID, position int
+		if err := rows.Scan(&chirpID, &position); err != nil {
+			return nil, err
+		}
+		postings[chirpID] = append(postings[chirpID], position)
+	}
+	return postings, rows.Err()
+}
+
+func (db *SQLiteDB) chirpCount() (int, error) {
+	var n int
+	err := db.conn.QueryRow(`SELECT COUNT(*) FROM chirps WHERE NOT deleted`).Scan(&n)
+	return n, err
+}
+
+func (db *SQLiteDB) chirpsByID(ids []int) (map[int]Chirp, error) {
+	chirps := make(map[int]Chirp, len(ids))
+	for start := 0; start < len(ids); start += sqliteMaxParams {
+		batch := ids[start:min(start+sqliteMaxParams, len(ids))]
+		args := make([]any, len(batch))
+		for i, id := range batch {
+			args[i] = id
+		}
+
+		placeholders := strings.TrimSuffix(strings.Repeat("?,", len(batch)), ",")
+		found, err := scanChirps(db.conn.Query(
+			`SELECT `+sqliteChirpColumns+` FROM chirps WHERE id IN (`+placeholders+`)`,
+			args...,
+		))
+		if err != nil {
+			return nil, err
+		}
+		for _, chirp := range found {
+			chirps[chirp.ID] = chirp
+		}
+	}
+	return chirps, nil
+}
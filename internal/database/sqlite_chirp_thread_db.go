@@ -0,0 +1,40 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+)
+
+func (db *SQLiteDB) GetThread(chirpID int, depth int) (Thread, error) {
+	var rootID int
+	err := db.conn.QueryRow(`SELECT root_id FROM chirps WHERE id = ?`, chirpID).Scan(&rootID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return Thread{}, ErrChirpNotFound
+	}
+	if err != nil {
+		return Thread{}, err
+	}
+
+	chirps, err := scanChirps(db.conn.Query(
+		`SELECT `+sqliteChirpColumns+` FROM chirps WHERE root_id = ? ORDER BY id`,
+		rootID,
+	))
+	if err != nil {
+		return Thread{}, err
+	}
+
+	var root Chirp
+	children := make(map[int][]Chirp)
+	for _, chirp := range chirps {
+		if chirp.ID == rootID {
+			root = chirp
+			continue
+		}
+		children[chirp.ParentID] = append(children[chirp.ParentID], chirp)
+	}
+
+	replies := func(parentID int) []Chirp {
+		return children[parentID]
+	}
+	return buildThread(root, depth, replies), nil
+}
@@ -0,0 +1,262 @@
+package database
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"time"
+)
+
+const sqliteDraftColumns = `id, author_id, body, COALESCE(parent_id, 0), kind, COALESCE(original_id, 0), media_ids, flagged, status, publish_at, created_at`
+
+func scanDraft(row interface{ Scan(...any) error }) (Draft, error) {
+	draft := Draft{}
+	var mediaIDs string
+	var publishAt sql.NullTime
+	err := row.Scan(
+		&draft.ID,
+		&draft.AuthorID,
+		&draft.Body,
+		&draft.ParentID,
+		&draft.Kind,
+		&draft.OriginalID,
+		&mediaIDs,
+		&draft.Flagged,
+		&draft.Status,
+		&publishAt,
+		&draft.CreatedAt,
+	)
+	if err != nil {
+		return Draft{}, err
+	}
+	if publishAt.Valid {
+		draft.PublishAt = &publishAt.Time
+	}
+	return draft, json.Unmarshal([]byte(mediaIDs), &draft.MediaIDs)
+}
+
+func scanDrafts(rows *sql.Rows, err error) ([]Draft, error) {
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	drafts := []Draft{}
+	for rows.Next() {
+		draft, err := scanDraft(rows)
+		if err != nil {
+			return nil, err
+		}
+		drafts = append(drafts, draft)
+	}
+	return drafts, rows.Err()
+}
+
+// nullableID stores a zero ID as NULL, for the optional references drafts
+// hold.
+func nullableID(id int) any {
+	if id == 0 {
+		return nil
+	}
+	return id
+}
+
+func (db *SQLiteDB) CreateDraft(params ChirpParams, publishAt time.Time) (Draft, error) {
+	draft, err := newDraft(params, publishAt)
+	if err != nil {
+		return Draft{}, err
+	}
+
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return Draft{}, err
+	}
+	defer tx.Rollback()
+
+	if _, err = sqliteChirpMedia(tx, draft.MediaIDs, draft.AuthorID); err != nil {
+		return Draft{}, err
+	}
+	if draft.ParentID != 0 {
+		_, _, err = sqliteSharedChirp(tx, draft.ParentID)
+		if errors.Is(err, sql.ErrNoRows) {
+			return Draft{}, ErrParentNotFound
+		}
+		if err != nil {
+			return Draft{}, err
+		}
+	}
+	if draft.OriginalID != 0 {
+		_, _, err = sqliteSharedChirp(tx, draft.OriginalID)
+		if errors.Is(err, sql.ErrNoRows) {
+			return Draft{}, ErrOriginalNotFound
+		}
+		if err != nil {
+			return Draft{}, err
+		}
+	}
+
+	mediaIDs, err := json.Marshal(draft.MediaIDs)
+	if err != nil {
+		return Draft{}, err
+	}
+	res, err := tx.Exec(
+		`INSERT INTO drafts (author_id, body, parent_id, kind, original_id, media_ids, flagged, status, publish_at, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		draft.AuthorID, draft.Body, nullableID(draft.ParentID), draft.Kind, nullableID(draft.OriginalID),
+		string(mediaIDs), draft.Flagged, draft.Status, draft.PublishAt, draft.CreatedAt,
+	)
+	if err != nil {
+		return Draft{}, err
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return Draft{}, err
+	}
+	draft.ID = int(id)
+
+	if err = tx.Commit(); err != nil {
+		return Draft{}, err
+	}
+	return draft, nil
+}
+
+func (db *SQLiteDB) GetDrafts(authorID int) ([]Draft, error) {
+	return scanDrafts(db.conn.Query(
+		`SELECT `+sqliteDraftColumns+` FROM drafts WHERE author_id = ? ORDER BY id`,
+		authorID,
+	))
+}
+
+func (db *SQLiteDB) DeleteDraft(draftID int, authorID int) error {
+	res, err := db.conn.Exec(`DELETE FROM drafts WHERE id = ? AND author_id = ?`, draftID, authorID)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrDraftNotFound
+	}
+	return nil
+}
+
+func (db *SQLiteDB) PublishDraft(draftID int, authorID int, review DraftReview) (Chirp, error) {
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return Chirp{}, err
+	}
+	defer tx.Rollback()
+
+	draft, err := scanDraft(tx.QueryRow(
+		`SELECT `+sqliteDraftColumns+` FROM drafts WHERE id = ? AND author_id = ?`,
+		draftID, authorID,
+	))
+	if errors.Is(err, sql.ErrNoRows) {
+		return Chirp{}, ErrDraftNotFound
+	}
+	if err != nil {
+		return Chirp{}, err
+	}
+
+	chirp, err := db.publishDraft(tx, draft, review)
+	if err != nil {
+		return Chirp{}, err
+	}
+	if err = tx.Commit(); err != nil {
+		return Chirp{}, err
+	}
+	return chirp, nil
+}
+
+// PublishDueDrafts publishes every scheduled draft whose time has come by
+// now, in the order they were scheduled for. A draft that can no longer be
+// published is turned back into a plain draft so its author can deal with
+// it.
+func (db *SQLiteDB) PublishDueDrafts(now time.Time, review DraftReview) ([]Chirp, error) {
+	due, err := scanDrafts(db.conn.Query(
+		`SELECT `+sqliteDraftColumns+` FROM drafts WHERE status = ? AND publish_at <= ? ORDER BY publish_at, id`,
+		DraftStatusScheduled, now.UTC(),
+	))
+	if err != nil {
+		return nil, err
+	}
+
+	published := []Chirp{}
+	for _, draft := range due {
+		chirp, err := db.publishDueDraft(draft, review)
+		if err != nil {
+			return published, err
+		}
+		if chirp != nil {
+			published = append(published, *chirp)
+		}
+	}
+	return published, nil
+}
+
+// publishDueDraft publishes draft in a transaction of its own, so one
+// draft that fails doesn't hold back the others. It returns nil if the
+// draft had to be turned back into a plain draft instead.
+func (db *SQLiteDB) publishDueDraft(draft Draft, review DraftReview) (*Chirp, error) {
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	// The draft may have been published or deleted since it was listed.
+	var status string
+	err = tx.QueryRow(`SELECT status FROM drafts WHERE id = ?`, draft.ID).Scan(&status)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if status != DraftStatusScheduled {
+		return nil, nil
+	}
+
+	chirp, err := db.publishDraft(tx, draft, review)
+	if isChirpValidationError(err) {
+		log.Printf("could not publish scheduled draft %d: %v", draft.ID, err)
+		tx.Rollback()
+		_, err = db.conn.Exec(
+			`UPDATE drafts SET status = ?, publish_at = NULL WHERE id = ?`,
+			DraftStatusDraft, draft.ID,
+		)
+		return nil, err
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err = tx.Commit(); err != nil {
+		return nil, err
+	}
+	return &chirp, nil
+}
+
+func (db *SQLiteDB) publishDraft(tx *sql.Tx, draft Draft, review DraftReview) (Chirp, error) {
+	author, err := scanUser(tx.QueryRow(`SELECT `+sqliteUserColumns+` FROM users WHERE id = ?`, draft.AuthorID))
+	if err != nil {
+		return Chirp{}, err
+	}
+	params, err := draft.reviewed(author.account(), review)
+	if err != nil {
+		return Chirp{}, err
+	}
+	if err := params.validate(); err != nil {
+		return Chirp{}, err
+	}
+	chirp, err := db.insertChirp(tx, params)
+	if err != nil {
+		return Chirp{}, err
+	}
+	if _, err = tx.Exec(`DELETE FROM drafts WHERE id = ?`, draft.ID); err != nil {
+		return Chirp{}, err
+	}
+	return chirp, nil
+}
@@ -0,0 +1,162 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// sqliteNewEmailToken returns a token for purpose, stored in place of the
+// user's previous tokens for the same purpose.
+func sqliteNewEmailToken(tx *sql.Tx, user User, purpose string, ttl time.Duration, tokenKey []byte) (string, error) {
+	expiresAt := time.Now().UTC().Add(ttl)
+	token, err := signEmailToken(tokenKey, purpose, user.ID, expiresAt)
+	if err != nil {
+		return "", err
+	}
+
+	if err = sqliteDeleteEmailTokens(tx, user.ID, purpose); err != nil {
+		return "", err
+	}
+	_, err = tx.Exec(
+		`INSERT INTO email_tokens (user_id, purpose, email, token_hash, expires_at) VALUES (?, ?, ?, ?, ?)`,
+		user.ID, purpose, user.Email, hashToken(tokenKey, token), expiresAt,
+	)
+	if err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// sqliteDeleteEmailTokens deletes the user's tokens for purpose, along with
+// any of their tokens that have expired.
+func sqliteDeleteEmailTokens(tx *sql.Tx, userID int, purpose string) error {
+	_, err := tx.Exec(
+		`DELETE FROM email_tokens WHERE user_id = ? AND (purpose = ? OR expires_at <= ?)`,
+		userID, purpose, time.Now().UTC(),
+	)
+	return err
+}
+
+// sqliteUserByEmailToken returns the user a token was mailed to for
+// purpose, if the token is still good.
+func sqliteUserByEmailToken(tx *sql.Tx, token string, purpose string, tokenKey []byte) (User, error) {
+	userID, ok := verifyEmailToken(tokenKey, purpose, token, time.Now())
+	if !ok {
+		return User{}, ErrInvalidEmailToken
+	}
+	user, err := scanUser(tx.QueryRow(
+		`SELECT `+sqliteUserColumns+` FROM users WHERE id = (
+			SELECT user_id FROM email_tokens
+			WHERE token_hash = ? AND user_id = ? AND purpose = ? AND email = users.email
+		)`,
+		hashToken(tokenKey, token), userID, purpose,
+	))
+	if errors.Is(err, sql.ErrNoRows) {
+		return User{}, ErrInvalidEmailToken
+	}
+	return user, err
+}
+
+func (db *SQLiteDB) CreatePasswordResetToken(email string) (string, AccountResp, error) {
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return "", AccountResp{}, err
+	}
+	defer tx.Rollback()
+
+	ok, user, err := sqliteUserByEmail(tx, email)
+	if err != nil {
+		return "", AccountResp{}, err
+	}
+	if !ok {
+		return "", AccountResp{}, ErrUserNotFound
+	}
+	token, err := sqliteNewEmailToken(tx, user, PurposePasswordReset, PasswordResetTokenTTL, db.tokenKey)
+	if err != nil {
+		return "", AccountResp{}, err
+	}
+	if err = tx.Commit(); err != nil {
+		return "", AccountResp{}, err
+	}
+	return token, user.account(), nil
+}
+
+func (db *SQLiteDB) ResetPassword(token string, password []byte) error {
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	user, err := sqliteUserByEmailToken(tx, token, PurposePasswordReset, db.tokenKey)
+	if err != nil {
+		return err
+	}
+
+	if _, err = tx.Exec(`UPDATE users SET password = ?, email_verified = TRUE WHERE id = ?`, password, user.ID); err != nil {
+		return err
+	}
+	if err = sqliteDeleteEmailTokens(tx, user.ID, PurposePasswordReset); err != nil {
+		return err
+	}
+	if _, err = tx.Exec(`DELETE FROM sessions WHERE user_id = ?`, user.ID); err != nil {
+		return err
+	}
+	if _, err = tx.Exec(`DELETE FROM mfa_challenges WHERE user_id = ?`, user.ID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (db *SQLiteDB) CreateEmailVerificationToken(userID int) (string, AccountResp, error) {
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return "", AccountResp{}, err
+	}
+	defer tx.Rollback()
+
+	user, err := scanUser(tx.QueryRow(`SELECT `+sqliteUserColumns+` FROM users WHERE id = ?`, userID))
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", AccountResp{}, ErrUserNotFound
+	}
+	if err != nil {
+		return "", AccountResp{}, err
+	}
+	if user.EmailVerified {
+		return "", AccountResp{}, ErrEmailAlreadyVerified
+	}
+	token, err := sqliteNewEmailToken(tx, user, PurposeEmailVerification, EmailVerificationTokenTTL, db.tokenKey)
+	if err != nil {
+		return "", AccountResp{}, err
+	}
+	if err = tx.Commit(); err != nil {
+		return "", AccountResp{}, err
+	}
+	return token, user.account(), nil
+}
+
+func (db *SQLiteDB) VerifyEmail(token string) (AccountResp, error) {
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return AccountResp{}, err
+	}
+	defer tx.Rollback()
+
+	user, err := sqliteUserByEmailToken(tx, token, PurposeEmailVerification, db.tokenKey)
+	if err != nil {
+		return AccountResp{}, err
+	}
+
+	if _, err = tx.Exec(`UPDATE users SET email_verified = TRUE WHERE id = ?`, user.ID); err != nil {
+		return AccountResp{}, err
+	}
+	if err = sqliteDeleteEmailTokens(tx, user.ID, PurposeEmailVerification); err != nil {
+		return AccountResp{}, err
+	}
+	if err = tx.Commit(); err != nil {
+		return AccountResp{}, err
+	}
+	user.EmailVerified = true
+	return user.account(), nil
+}
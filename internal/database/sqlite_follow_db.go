@@ -0,0 +1,127 @@
+package database
+
+import (
+	"database/sql"
+	"time"
+)
+
+func (db *SQLiteDB) FollowUser(followerID int, followeeID int) error {
+	if followerID == followeeID {
+		return ErrCannotFollowSelf
+	}
+
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err = sqliteUserExists(tx, followeeID); err != nil {
+		return err
+	}
+
+	res, err := tx.Exec(
+		`INSERT OR IGNORE INTO follows (follower_id, followee_id, created_at) VALUES (?, ?, ?)`,
+		followerID, followeeID, time.Now().UTC(),
+	)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n > 0 && db.timelineMode == FanOutOnWrite {
+		_, err = tx.Exec(
+			`INSERT OR IGNORE INTO timeline_entries (user_id, chirp_id)
+			SELECT ?, id FROM chirps WHERE author_id = ? AND NOT deleted`,
+			followerID, followeeID,
+		)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func (db *SQLiteDB) UnfollowUser(followerID int, followeeID int) error {
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err = sqliteUserExists(tx, followeeID); err != nil {
+		return err
+	}
+
+	res, err := tx.Exec(`DELETE FROM follows WHERE follower_id = ? AND followee_id = ?`, followerID, followeeID)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n > 0 && db.timelineMode == FanOutOnWrite {
+		_, err = tx.Exec(
+			`DELETE FROM timeline_entries WHERE user_id = ? AND chirp_id IN (SELECT id FROM chirps WHERE author_id = ?)`,
+			followerID, followeeID,
+		)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func (db *SQLiteDB) GetFollowers(userID int) ([]Follow, error) {
+	return db.follows(userID, `followee_id`)
+}
+
+func (db *SQLiteDB) GetFollowing(userID int) ([]Follow, error) {
+	return db.follows(userID, `follower_id`)
+}
+
+// follows returns the follows whose column equals userID, oldest first.
+func (db *SQLiteDB) follows(userID int, column string) ([]Follow, error) {
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	if err = sqliteUserExists(tx, userID); err != nil {
+		return nil, err
+	}
+
+	rows, err := tx.Query(
+		`SELECT id, follower_id, followee_id, created_at FROM follows WHERE `+column+` = ? ORDER BY id`,
+		userID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	follows := []Follow{}
+	for rows.Next() {
+		follow := Follow{}
+		if err := rows.Scan(&follow.ID, &follow.FollowerID, &follow.FolloweeID, &follow.CreatedAt); err != nil {
+			return nil, err
+		}
+		follows = append(follows, follow)
+	}
+	return follows, rows.Err()
+}
+
+func sqliteUserExists(tx *sql.Tx, userID int) error {
+	var exists bool
+	if err := tx.QueryRow(`SELECT EXISTS (SELECT 1 FROM users WHERE id = ?)`, userID).Scan(&exists); err != nil {
+		return err
+	}
+	if !exists {
+		return ErrUserNotFound
+	}
+	return nil
+}
@@ -0,0 +1,90 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+)
+
+const sqliteMediaColumns = `id, owner_id, hash, content_type, size, width, height, url, thumbnail_url, created_at`
+
+func scanMedia(row interface{ Scan(...any) error }) (Media, error) {
+	media := Media{}
+	err := row.Scan(
+		&media.ID,
+		&media.OwnerID,
+		&media.Hash,
+		&media.ContentType,
+		&media.Size,
+		&media.Width,
+		&media.Height,
+		&media.URL,
+		&media.ThumbnailURL,
+		&media.CreatedAt,
+	)
+	return media, err
+}
+
+func (db *SQLiteDB) CreateMedia(media Media) (Media, error) {
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return Media{}, err
+	}
+	defer tx.Rollback()
+
+	if err = sqliteUserExists(tx, media.OwnerID); err != nil {
+		return Media{}, err
+	}
+
+	media.CreatedAt = time.Now().UTC()
+	res, err := tx.Exec(
+		`INSERT INTO media (owner_id, hash, content_type, size, width, height, url, thumbnail_url, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		media.OwnerID, media.Hash, media.ContentType, media.Size, media.Width, media.Height,
+		media.URL, media.ThumbnailURL, media.CreatedAt,
+	)
+	if err != nil {
+		return Media{}, err
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return Media{}, err
+	}
+	media.ID = int(id)
+
+	if err = tx.Commit(); err != nil {
+		return Media{}, err
+	}
+	return media, nil
+}
+
+func (db *SQLiteDB) GetMedia(id int) (Media, error) {
+	media, err := scanMedia(db.conn.QueryRow(`SELECT `+sqliteMediaColumns+` FROM media WHERE id = ?`, id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return Media{}, ErrMediaNotFound
+	}
+	return media, err
+}
+
+// sqliteChirpMedia looks up the media to attach to a chirp by authorID.
+// Media uploaded by someone else is treated as missing.
+func sqliteChirpMedia(tx *sql.Tx, ids []int, authorID int) (ChirpMedia, error) {
+	if len(ids) == 0 {
+		return nil, nil
+	}
+	attached := ChirpMedia{}
+	for _, id := range ids {
+		media, err := scanMedia(tx.QueryRow(
+			`SELECT `+sqliteMediaColumns+` FROM media WHERE id = ? AND owner_id = ?`,
+			id, authorID,
+		))
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrMediaNotFound
+		}
+		if err != nil {
+			return nil, err
+		}
+		attached = append(attached, media)
+	}
+	return attached, nil
+}
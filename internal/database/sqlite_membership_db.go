@@ -0,0 +1,19 @@
+package database
+
+import "errors"
+
+func (db *SQLiteDB) UpgradeUser(userID int) error {
+	res, err := db.conn.Exec(`UPDATE users SET is_chirpy_red = TRUE WHERE id = ?`, userID)
+	if err != nil {
+		return err
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return errors.New("user doesn't exist")
+	}
+	return nil
+}
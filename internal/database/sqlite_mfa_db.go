@@ -0,0 +1,239 @@
+package database
+
+import (
+	"crypto/subtle"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"time"
+)
+
+func sqliteTOTP(tx *sql.Tx, userID int) (TOTP, bool, error) {
+	t := TOTP{}
+	var recoveryCodes string
+	err := tx.QueryRow(
+		`SELECT user_id, secret, enabled, last_step, recovery_codes, failures, locked_until, created_at
+		FROM totp WHERE user_id = ?`,
+		userID,
+	).Scan(&t.UserID, &t.Secret, &t.Enabled, &t.LastStep, &recoveryCodes, &t.Failures, &t.LockedUntil, &t.CreatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return TOTP{}, false, nil
+	}
+	if err != nil {
+		return TOTP{}, false, err
+	}
+	return t, true, json.Unmarshal([]byte(recoveryCodes), &t.RecoveryCodes)
+}
+
+func sqlitePutTOTP(tx *sql.Tx, t TOTP) error {
+	recoveryCodes, err := json.Marshal(t.RecoveryCodes)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(
+		`INSERT OR REPLACE INTO totp (user_id, secret, enabled, last_step, recovery_codes, failures, locked_until, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+		t.UserID, t.Secret, t.Enabled, t.LastStep, string(recoveryCodes), t.Failures, t.LockedUntil, t.CreatedAt,
+	)
+	return err
+}
+
+// sqliteNewMFAChallenge starts a login challenge for userID. Expired
+// challenges are removed along the way.
+func sqliteNewMFAChallenge(tx *sql.Tx, userID int, tokenKey []byte) (MFAChallengeResp, error) {
+	mfaToken, err := generateToken()
+	if err != nil {
+		return MFAChallengeResp{}, err
+	}
+
+	now := time.Now().UTC()
+	if _, err = tx.Exec(`DELETE FROM mfa_challenges WHERE expires_at <= ?`, now); err != nil {
+		return MFAChallengeResp{}, err
+	}
+	expiresAt := now.Add(mfaChallengeTTL)
+	_, err = tx.Exec(
+		`INSERT INTO mfa_challenges (user_id, token_hash, expires_at) VALUES (?, ?, ?)`,
+		userID, hashToken(tokenKey, mfaToken), expiresAt,
+	)
+	if err != nil {
+		return MFAChallengeResp{}, err
+	}
+	return MFAChallengeResp{MFARequired: true, MFAToken: mfaToken, ExpiresAt: expiresAt}, nil
+}
+
+func (db *SQLiteDB) BeginTOTPEnrollment(userID int) (TOTPEnrollment, error) {
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return TOTPEnrollment{}, err
+	}
+	defer tx.Rollback()
+
+	var email string
+	err = tx.QueryRow(`SELECT email FROM users WHERE id = ?`, userID).Scan(&email)
+	if errors.Is(err, sql.ErrNoRows) {
+		return TOTPEnrollment{}, ErrUserNotFound
+	}
+	if err != nil {
+		return TOTPEnrollment{}, err
+	}
+	existing, ok, err := sqliteTOTP(tx, userID)
+	if err != nil {
+		return TOTPEnrollment{}, err
+	}
+	if ok && existing.Enabled {
+		return TOTPEnrollment{}, ErrTOTPAlreadyEnabled
+	}
+
+	t, enrollment, err := newTOTP(userID, email, db.tokenKey)
+	if err != nil {
+		return TOTPEnrollment{}, err
+	}
+	if err = sqlitePutTOTP(tx, t); err != nil {
+		return TOTPEnrollment{}, err
+	}
+	if err = tx.Commit(); err != nil {
+		return TOTPEnrollment{}, err
+	}
+	return enrollment, nil
+}
+
+func (db *SQLiteDB) ConfirmTOTP(userID int, code string) ([]string, error) {
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	t, ok, err := sqliteTOTP(tx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, ErrTOTPNotEnrolled
+	}
+	codes, err := t.confirm(code, db.tokenKey)
+	if err != nil {
+		return nil, err
+	}
+	if err = sqlitePutTOTP(tx, t); err != nil {
+		return nil, err
+	}
+	if err = tx.Commit(); err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+func (db *SQLiteDB) DisableTOTP(userID int, code string) error {
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	t, ok, err := sqliteTOTP(tx, userID)
+	if err != nil {
+		return err
+	}
+	if !ok || !t.Enabled {
+		return ErrTOTPNotEnabled
+	}
+	if checkErr := t.check(code, db.tokenKey, time.Now()); checkErr != nil {
+		if err = sqlitePutTOTP(tx, t); err != nil {
+			return err
+		}
+		if err = tx.Commit(); err != nil {
+			return err
+		}
+		return checkErr
+	}
+	if _, err = tx.Exec(`DELETE FROM totp WHERE user_id = ?`, userID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (db *SQLiteDB) CompleteMFALogin(mfaToken string, code string, signer TokenSigner, client SessionInfo) (LoginResp, error) {
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return LoginResp{}, err
+	}
+	defer tx.Rollback()
+
+	tokenHash := hashToken(db.tokenKey, mfaToken)
+	challenge := MFAChallenge{}
+	err = tx.QueryRow(
+		`SELECT id, user_id, token_hash, attempts, expires_at FROM mfa_challenges WHERE token_hash = ?`,
+		tokenHash,
+	).Scan(&challenge.ID, &challenge.UserID, &challenge.TokenHash, &challenge.Attempts, &challenge.ExpiresAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return LoginResp{}, ErrMFAChallengeNotFound
+	}
+	if err != nil {
+		return LoginResp{}, err
+	}
+	if subtle.ConstantTimeCompare([]byte(challenge.TokenHash), []byte(tokenHash)) != 1 {
+		return LoginResp{}, ErrMFAChallengeNotFound
+	}
+	if !challenge.ExpiresAt.After(time.Now()) {
+		if _, err = tx.Exec(`DELETE FROM mfa_challenges WHERE id = ?`, challenge.ID); err != nil {
+			return LoginResp{}, err
+		}
+		if err = tx.Commit(); err != nil {
+			return LoginResp{}, err
+		}
+		return LoginResp{}, ErrMFAChallengeNotFound
+	}
+
+	t, ok, err := sqliteTOTP(tx, challenge.UserID)
+	if err != nil {
+		return LoginResp{}, err
+	}
+	checkErr := ErrInvalidMFACode
+	if ok && t.Enabled {
+		checkErr = t.check(code, db.tokenKey, time.Now())
+	}
+	if checkErr != nil {
+		if ok && t.Enabled {
+			if err = sqlitePutTOTP(tx, t); err != nil {
+				return LoginResp{}, err
+			}
+		}
+		if challenge.Attempts+1 >= maxMFAAttempts {
+			_, err = tx.Exec(`DELETE FROM mfa_challenges WHERE id = ?`, challenge.ID)
+		} else {
+			_, err = tx.Exec(`UPDATE mfa_challenges SET attempts = attempts + 1 WHERE id = ?`, challenge.ID)
+		}
+		if err != nil {
+			return LoginResp{}, err
+		}
+		if err = tx.Commit(); err != nil {
+			return LoginResp{}, err
+		}
+		return LoginResp{}, checkErr
+	}
+
+	user, err := scanUser(tx.QueryRow(`SELECT `+sqliteUserColumns+` FROM users WHERE id = ?`, challenge.UserID))
+	if err != nil {
+		return LoginResp{}, err
+	}
+	sessionID, refreshToken, err := sqliteNewSession(tx, user.ID, client, db.tokenKey)
+	if err != nil {
+		return LoginResp{}, err
+	}
+	tokenString, err := signAccessToken("chirpy", user.ID, sessionID, signer)
+	if err != nil {
+		return LoginResp{}, err
+	}
+	if _, err = tx.Exec(`DELETE FROM mfa_challenges WHERE id = ?`, challenge.ID); err != nil {
+		return LoginResp{}, err
+	}
+	if err = sqlitePutTOTP(tx, t); err != nil {
+		return LoginResp{}, err
+	}
+	if err = tx.Commit(); err != nil {
+		return LoginResp{}, err
+	}
+
+	return user.loginResp(tokenString, refreshToken), nil
+}
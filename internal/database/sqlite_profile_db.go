@@ -0,0 +1,98 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+)
+
+func (db *SQLiteDB) GetUserByID(id int) (UserResp, error) {
+	user, err := scanUser(db.conn.QueryRow(`SELECT `+sqliteUserColumns+` FROM users WHERE id = ?`, id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return UserResp{}, ErrUserNotFound
+	}
+	if err != nil {
+		return UserResp{}, err
+	}
+	return user.public(), nil
+}
+
+func (db *SQLiteDB) GetAccount(userID int) (AccountResp, error) {
+	user, err := scanUser(db.conn.QueryRow(`SELECT `+sqliteUserColumns+` FROM users WHERE id = ?`, userID))
+	if errors.Is(err, sql.ErrNoRows) {
+		return AccountResp{}, ErrUserNotFound
+	}
+	if err != nil {
+		return AccountResp{}, err
+	}
+	return user.account(), nil
+}
+
+func (db *SQLiteDB) GetUserByHandle(handle string) (UserResp, error) {
+	user, err := scanUser(db.conn.QueryRow(
+		`SELECT `+sqliteUserColumns+` FROM users WHERE handle = ?`,
+		normalizeHandle(handle),
+	))
+	if errors.Is(err, sql.ErrNoRows) {
+		return UserResp{}, ErrUserNotFound
+	}
+	if err != nil {
+		return UserResp{}, err
+	}
+	return user.public(), nil
+}
+
+func (db *SQLiteDB) UpdateProfile(userID int, update ProfileUpdate) (AccountResp, error) {
+	if err := update.validate(); err != nil {
+		return AccountResp{}, err
+	}
+
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return AccountResp{}, err
+	}
+	defer tx.Rollback()
+
+	user, err := scanUser(tx.QueryRow(`SELECT `+sqliteUserColumns+` FROM users WHERE id = ?`, userID))
+	if errors.Is(err, sql.ErrNoRows) {
+		return AccountResp{}, ErrUserNotFound
+	}
+	if err != nil {
+		return AccountResp{}, err
+	}
+	if update.Handle != nil {
+		var taken bool
+		err = tx.QueryRow(`SELECT EXISTS (SELECT 1 FROM users WHERE handle = ? AND id != ?)`, *update.Handle, userID).Scan(&taken)
+		if err != nil {
+			return AccountResp{}, err
+		}
+		if taken {
+			return AccountResp{}, ErrHandleTaken
+		}
+	}
+	if update.uploadedAvatar() {
+		var owned bool
+		err = tx.QueryRow(
+			`SELECT EXISTS (SELECT 1 FROM media WHERE owner_id = ? AND (url = ? OR thumbnail_url = ?))`,
+			userID, *update.AvatarURL, *update.AvatarURL,
+		).Scan(&owned)
+		if err != nil {
+			return AccountResp{}, err
+		}
+		if !owned {
+			return AccountResp{}, ErrInvalidAvatarURL
+		}
+	}
+
+	update.apply(&user)
+	_, err = tx.Exec(
+		`UPDATE users SET handle = ?, display_name = ?, bio = ?, avatar_url = ? WHERE id = ?`,
+		user.Handle, user.DisplayName, user.Bio, user.AvatarURL, userID,
+	)
+	if err != nil {
+		return AccountResp{}, err
+	}
+	if err = tx.Commit(); err != nil {
+		return AccountResp{}, err
+	}
+	return user.account(), nil
+}
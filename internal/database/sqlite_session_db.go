@@ -0,0 +1,220 @@
+package database
+
+import (
+	"crypto/subtle"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+const sqliteSessionColumns = `id, user_id, device, ip, created_at, last_used_at, expires_at`
+
+func scanSession(row interface{ Scan(...any) error }) (Session, error) {
+	session := Session{}
+	err := row.Scan(
+		&session.ID,
+		&session.UserID,
+		&session.Device,
+		&session.IP,
+		&session.CreatedAt,
+		&session.LastUsedAt,
+		&session.ExpiresAt,
+	)
+	return session, err
+}
+
+// sqliteNewSession starts a session for userID and returns its ID and
+// first refresh token. The user's expired sessions are removed along the
+// way.
+func sqliteNewSession(tx *sql.Tx, userID int, client SessionInfo, tokenKey []byte) (int, string, error) {
+	refreshToken, err := generateToken()
+	if err != nil {
+		return 0, "", err
+	}
+
+	now := time.Now().UTC()
+	if _, err = tx.Exec(`DELETE FROM sessions WHERE user_id = ? AND expires_at <= ?`, userID, now); err != nil {
+		return 0, "", err
+	}
+	res, err := tx.Exec(
+		`INSERT INTO sessions (user_id, device, ip, created_at, last_used_at, expires_at) VALUES (?, ?, ?, ?, ?, ?)`,
+		userID, client.device(), client.IP, now, now, now.Add(refreshTokenTTL),
+	)
+	if err != nil {
+		return 0, "", err
+	}
+	sessionID, err := res.LastInsertId()
+	if err != nil {
+		return 0, "", err
+	}
+	_, err = tx.Exec(
+		`INSERT INTO refresh_tokens (token_hash, session_id, created_at) VALUES (?, ?, ?)`,
+		hashToken(tokenKey, refreshToken), sessionID, now,
+	)
+	if err != nil {
+		return 0, "", err
+	}
+	return int(sessionID), refreshToken, nil
+}
+
+func (db *SQLiteDB) RefreshAccessToken(refreshToken string, signer TokenSigner, ip string) (RefreshResp, error) {
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return RefreshResp{}, err
+	}
+	defer tx.Rollback()
+
+	tokenHash := hashToken(db.tokenKey, refreshToken)
+	sessionID, rotated, err := sqliteRefreshTokenByHash(tx, tokenHash)
+	if err != nil {
+		return RefreshResp{}, err
+	}
+	if rotated {
+		if _, err = tx.Exec(`DELETE FROM sessions WHERE id = ?`, sessionID); err != nil {
+			return RefreshResp{}, err
+		}
+		if err = tx.Commit(); err != nil {
+			return RefreshResp{}, err
+		}
+		return RefreshResp{}, ErrRefreshTokenReused
+	}
+
+	session, err := scanSession(tx.QueryRow(`SELECT `+sqliteSessionColumns+` FROM sessions WHERE id = ?`, sessionID))
+	if err != nil {
+		return RefreshResp{}, err
+	}
+	now := time.Now().UTC()
+	if !session.ExpiresAt.After(now) {
+		return RefreshResp{}, ErrRefreshTokenExpired
+	}
+
+	tokenString, err := signAccessToken("chirpy-refresh", session.UserID, session.ID, signer)
+	if err != nil {
+		return RefreshResp{}, err
+	}
+	newRefreshToken, err := generateToken()
+	if err != nil {
+		return RefreshResp{}, err
+	}
+
+	if _, err = tx.Exec(`UPDATE refresh_tokens SET rotated = TRUE WHERE token_hash = ?`, tokenHash); err != nil {
+		return RefreshResp{}, err
+	}
+	_, err = tx.Exec(
+		`DELETE FROM refresh_tokens WHERE session_id = ? AND rotated AND token_hash NOT IN (
+			SELECT token_hash FROM refresh_tokens WHERE session_id = ? AND rotated
+			ORDER BY created_at DESC, token_hash LIMIT ?
+		)`,
+		session.ID, session.ID, maxRotatedTokens,
+	)
+	if err != nil {
+		return RefreshResp{}, err
+	}
+	_, err = tx.Exec(
+		`INSERT INTO refresh_tokens (token_hash, session_id, created_at) VALUES (?, ?, ?)`,
+		hashToken(db.tokenKey, newRefreshToken), session.ID, now,
+	)
+	if err != nil {
+		return RefreshResp{}, err
+	}
+	if ip == "" {
+		ip = session.IP
+	}
+	if _, err = tx.Exec(`UPDATE sessions SET last_used_at = ?, ip = ? WHERE id = ?`, now, ip, session.ID); err != nil {
+		return RefreshResp{}, err
+	}
+	if err = tx.Commit(); err != nil {
+		return RefreshResp{}, err
+	}
+
+	return RefreshResp{Token: tokenString, RefreshToken: newRefreshToken}, nil
+}
+
+func (db *SQLiteDB) RevokeRefreshToken(refreshToken string) error {
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	sessionID, _, err := sqliteRefreshTokenByHash(tx, hashToken(db.tokenKey, refreshToken))
+	if err != nil {
+		return err
+	}
+	if _, err = tx.Exec(`DELETE FROM sessions WHERE id = ?`, sessionID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// sqliteRefreshTokenByHash looks up the session of a refresh token and
+// whether the token was rotated already.
+func sqliteRefreshTokenByHash(tx *sql.Tx, tokenHash string) (int, bool, error) {
+	var storedHash string
+	var sessionID int
+	var rotated bool
+	err := tx.QueryRow(
+		`SELECT token_hash, session_id, rotated FROM refresh_tokens WHERE token_hash = ?`,
+		tokenHash,
+	).Scan(&storedHash, &sessionID, &rotated)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, false, ErrRefreshTokenNotFound
+	}
+	if err != nil {
+		return 0, false, err
+	}
+	if subtle.ConstantTimeCompare([]byte(storedHash), []byte(tokenHash)) != 1 {
+		return 0, false, ErrRefreshTokenNotFound
+	}
+	return sessionID, rotated, nil
+}
+
+func (db *SQLiteDB) GetSessions(userID int) ([]Session, error) {
+	rows, err := db.conn.Query(
+		`SELECT `+sqliteSessionColumns+` FROM sessions WHERE user_id = ? AND expires_at > ? ORDER BY id`,
+		userID, time.Now().UTC(),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	sessions := []Session{}
+	for rows.Next() {
+		session, err := scanSession(rows)
+		if err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, session)
+	}
+	return sessions, rows.Err()
+}
+
+func (db *SQLiteDB) RevokeSession(sessionID int, userID int) error {
+	res, err := db.conn.Exec(`DELETE FROM sessions WHERE id = ? AND user_id = ?`, sessionID, userID)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrSessionNotFound
+	}
+	return nil
+}
+
+func (db *SQLiteDB) PruneExpiredSessions(now time.Time) (int, error) {
+	res, err := db.conn.Exec(`DELETE FROM sessions WHERE expires_at <= ?`, now.UTC())
+	if err != nil {
+		return 0, err
+	}
+	n, err := res.RowsAffected()
+	return int(n), err
+}
+
+func (db *SQLiteDB) RevokeSessions(userID int) error {
+	_, err := db.conn.Exec(`DELETE FROM sessions WHERE user_id = ?`, userID)
+	return err
+}
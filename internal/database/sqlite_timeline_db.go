@@ -0,0 +1,63 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"log"
+)
+
+func (db *SQLiteDB) GetTimeline(userID int, query ChirpQuery) (ChirpPage, error) {
+	query.timelineUserID = userID
+	return db.GetChirps(query)
+}
+
+// syncTimelines makes timeline_entries match the configured mode. The table
+// is rebuilt when switching to fan-out-on-write and emptied when switching
+// away from it, so it is never read after missing writes.
+func (db *SQLiteDB) syncTimelines() error {
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var stored string
+	err = tx.QueryRow(`SELECT value FROM settings WHERE name = 'timeline_mode'`).Scan(&stored)
+	if errors.Is(err, sql.ErrNoRows) {
+		stored = string(FanOutOnRead)
+	} else if err != nil {
+		return err
+	}
+	if stored == string(db.timelineMode) {
+		return nil
+	}
+
+	if _, err = tx.Exec(`DELETE FROM timeline_entries`); err != nil {
+		return err
+	}
+	if db.timelineMode == FanOutOnWrite {
+		_, err = tx.Exec(`
+INSERT INTO timeline_entries (user_id, chirp_id)
+SELECT author_id, id FROM chirps WHERE NOT deleted
+UNION
+SELECT follows.follower_id, chirps.id FROM chirps
+JOIN follows ON follows.followee_id = chirps.author_id
+WHERE NOT chirps.deleted`)
+		if err != nil {
+			return err
+		}
+	}
+	_, err = tx.Exec(
+		`INSERT INTO settings (name, value) VALUES ('timeline_mode', ?)
+		ON CONFLICT (name) DO UPDATE SET value = excluded.value`,
+		string(db.timelineMode),
+	)
+	if err != nil {
+		return err
+	}
+	if err = tx.Commit(); err != nil {
+		return err
+	}
+	log.Printf("switched home timelines from fan-out-on-%s to fan-out-on-%s", stored, db.timelineMode)
+	return nil
+}
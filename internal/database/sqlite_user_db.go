@@ -0,0 +1,210 @@
+package database
+
+import (
+	"bytes"
+	"database/sql"
+	"errors"
+	"strings"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+const sqliteUserColumns = `id, email, password, is_chirpy_red,
+	COALESCE(handle, ''), display_name, bio, avatar_url, email_verified`
+
+func scanUser(row interface{ Scan(...any) error }) (User, error) {
+	user := User{}
+	err := row.Scan(
+		&user.ID,
+		&user.Email,
+		&user.Password,
+		&user.IsChirpyRed,
+		&user.Handle,
+		&user.DisplayName,
+		&user.Bio,
+		&user.AvatarURL,
+		&user.EmailVerified,
+	)
+	return user, err
+}
+
+func (db *SQLiteDB) CreateUser(email string, handle string, password []byte) (AccountResp, error) {
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return AccountResp{}, err
+	}
+	defer tx.Rollback()
+
+	if ok, _, err := sqliteUserByEmail(tx, email); err != nil {
+		return AccountResp{}, err
+	} else if ok {
+		return AccountResp{}, ErrEmailTaken
+	}
+
+	var queryErr error
+	handleTaken := func(handle string) bool {
+		var taken bool
+		if err := tx.QueryRow(`SELECT EXISTS (SELECT 1 FROM users WHERE handle = ?)`, handle).Scan(&taken); err != nil {
+			queryErr = err
+		}
+		return taken
+	}
+	if handle == "" {
+		handle = handleFromEmail(email, handleTaken)
+	} else {
+		handle = normalizeHandle(handle)
+		if err := validateHandle(handle); err != nil {
+			return AccountResp{}, err
+		}
+		if handleTaken(handle) {
+			return AccountResp{}, ErrHandleTaken
+		}
+	}
+	if queryErr != nil {
+		return AccountResp{}, queryErr
+	}
+
+	user := User{
+		Email:    strings.ToLower(email),
+		Password: password,
+		Handle:   handle,
+	}
+	res, err := tx.Exec(
+		`INSERT INTO users (email, password, handle) VALUES (?, ?, ?)`,
+		user.Email, user.Password, user.Handle,
+	)
+	if err != nil {
+		return AccountResp{}, err
+	}
+
+	id, err := res.LastInsertId()
+	if err != nil {
+		return AccountResp{}, err
+	}
+	user.ID = int(id)
+
+	if err = tx.Commit(); err != nil {
+		return AccountResp{}, err
+	}
+
+	return user.account(), nil
+}
+
+func (db *SQLiteDB) Login(email string, password string, signer TokenSigner, client SessionInfo) (LoginResp, *MFAChallengeResp, error) {
+	ok, user, err := sqliteUserByEmail(db.conn, email)
+	if err != nil {
+		return LoginResp{}, nil, err
+	}
+	if !ok {
+		return LoginResp{}, nil, errors.New("no such user found")
+	}
+
+	// bcrypt is slow on purpose, so the hash is compared before the write
+	// transaction starts.
+	err = bcrypt.CompareHashAndPassword(user.Password, []byte(password))
+	if err != nil {
+		return LoginResp{}, nil, errors.New("incorrect password")
+	}
+
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return LoginResp{}, nil, err
+	}
+	defer tx.Rollback()
+
+	// The user may have been deleted or changed their password while the
+	// hash was being compared.
+	current, err := scanUser(tx.QueryRow(`SELECT `+sqliteUserColumns+` FROM users WHERE id = ?`, user.ID))
+	if errors.Is(err, sql.ErrNoRows) {
+		return LoginResp{}, nil, errors.New("no such user found")
+	}
+	if err != nil {
+		return LoginResp{}, nil, err
+	}
+	if !bytes.Equal(current.Password, user.Password) {
+		return LoginResp{}, nil, errors.New("incorrect password")
+	}
+	user = current
+
+	t, ok, err := sqliteTOTP(tx, user.ID)
+	if err != nil {
+		return LoginResp{}, nil, err
+	}
+	if ok && t.Enabled {
+		challenge, err := sqliteNewMFAChallenge(tx, user.ID, db.tokenKey)
+		if err != nil {
+			return LoginResp{}, nil, err
+		}
+		if err = tx.Commit(); err != nil {
+			return LoginResp{}, nil, err
+		}
+		return LoginResp{}, &challenge, nil
+	}
+
+	sessionID, refreshToken, err := sqliteNewSession(tx, user.ID, client, db.tokenKey)
+	if err != nil {
+		return LoginResp{}, nil, err
+	}
+
+	tokenString, err := signAccessToken("chirpy", user.ID, sessionID, signer)
+	if err != nil {
+		return LoginResp{}, nil, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return LoginResp{}, nil, err
+	}
+
+	return user.loginResp(tokenString, refreshToken), nil, nil
+}
+
+// sqliteUserByEmail looks a user up by email, ignoring case.
+func sqliteUserByEmail(q interface {
+	QueryRow(query string, args ...any) *sql.Row
+}, email string) (bool, User, error) {
+	user, err := scanUser(q.QueryRow(`SELECT `+sqliteUserColumns+` FROM users WHERE email = ?`, strings.ToLower(email)))
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, User{}, nil
+	}
+	if err != nil {
+		return false, User{}, err
+	}
+	return true, user, nil
+}
+
+func (db *SQLiteDB) UpdateUser(id int, newEmail string, newPassword []byte, keepSessionID int) (AccountResp, error) {
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return AccountResp{}, err
+	}
+	defer tx.Rollback()
+
+	ok, other, err := sqliteUserByEmail(tx, newEmail)
+	if err != nil {
+		return AccountResp{}, err
+	}
+	if ok && other.ID != id {
+		return AccountResp{}, ErrEmailTaken
+	}
+
+	user, err := scanUser(tx.QueryRow(
+		`UPDATE users SET email = ?, password = ?, email_verified = email_verified AND email = ?
+		WHERE id = ? RETURNING `+sqliteUserColumns,
+		strings.ToLower(newEmail), newPassword, strings.ToLower(newEmail), id,
+	))
+	if errors.Is(err, sql.ErrNoRows) {
+		return AccountResp{}, errors.New("user not found")
+	}
+	if err != nil {
+		return AccountResp{}, err
+	}
+
+	if _, err = tx.Exec(`DELETE FROM sessions WHERE user_id = ? AND id != ?`, id, keepSessionID); err != nil {
+		return AccountResp{}, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return AccountResp{}, err
+	}
+	return user.account(), nil
+}
@@ -0,0 +1,66 @@
+package database
+
+import "time"
+
+type Store interface {
+	CreateChirp(params ChirpParams) (Chirp, error)
+	GetChirps(query ChirpQuery) (ChirpPage, error)
+	GetChirpByID(id int) (Chirp, error)
+	DeleteChirp(chirpID int, userID int) error
+	UpdateChirp(chirpID int, userID int, body string, flagged bool, editWindow time.Duration) (Chirp, error)
+	GetChirpHistory(chirpID int) ([]ChirpRevision, error)
+	SearchChirps(text string, query ChirpQuery) (ChirpPage, error)
+	GetThread(chirpID int, depth int) (Thread, error)
+	LikeChirp(chirpID int, userID int) (Chirp, error)
+	UnlikeChirp(chirpID int, userID int) (Chirp, error)
+	LikedChirps(userID int, chirpIDs []int) (map[int]bool, error)
+	BookmarkChirp(chirpID int, userID int) error
+	UnbookmarkChirp(chirpID int, userID int) error
+	GetBookmarks(userID int, query ChirpQuery) (ChirpPage, error)
+
+	CreateMedia(media Media) (Media, error)
+	GetMedia(id int) (Media, error)
+
+	CreateDraft(params ChirpParams, publishAt time.Time) (Draft, error)
+	GetDrafts(authorID int) ([]Draft, error)
+	DeleteDraft(draftID int, authorID int) error
+	PublishDraft(draftID int, authorID int, review DraftReview) (Chirp, error)
+	PublishDueDrafts(now time.Time, review DraftReview) ([]Chirp, error)
+
+	CreateUser(email string, handle string, password []byte) (AccountResp, error)
+	Login(email string, password string, signer TokenSigner, client SessionInfo) (LoginResp, *MFAChallengeResp, error)
+	CompleteMFALogin(mfaToken string, code string, signer TokenSigner, client SessionInfo) (LoginResp, error)
+	BeginTOTPEnrollment(userID int) (TOTPEnrollment, error)
+	ConfirmTOTP(userID int, code string) ([]string, error)
+	DisableTOTP(userID int, code string) error
+	UpdateUser(id int, newEmail string, newPassword []byte, keepSessionID int) (AccountResp, error)
+	GetUserByID(id int) (UserResp, error)
+	GetAccount(userID int) (AccountResp, error)
+	GetUserByHandle(handle string) (UserResp, error)
+	UpdateProfile(userID int, update ProfileUpdate) (AccountResp, error)
+	RefreshAccessToken(refreshToken string, signer TokenSigner, ip string) (RefreshResp, error)
+	RevokeRefreshToken(refreshToken string) error
+	GetSessions(userID int) ([]Session, error)
+	RevokeSession(sessionID int, userID int) error
+	RevokeSessions(userID int) error
+	PruneExpiredSessions(now time.Time) (int, error)
+	CreatePasswordResetToken(email string) (string, AccountResp, error)
+	ResetPassword(token string, password []byte) error
+	CreateEmailVerificationToken(userID int) (string, AccountResp, error)
+	VerifyEmail(token string) (AccountResp, error)
+
+	UpgradeUser(userID int) error
+
+	FollowUser(followerID int, followeeID int) error
+	UnfollowUser(followerID int, followeeID int) error
+	GetFollowers(userID int) ([]Follow, error)
+	GetFollowing(userID int) ([]Follow, error)
+	GetTimeline(userID int, query ChirpQuery) (ChirpPage, error)
+
+	Close() error
+}
+
+var (
+	_ Store = (*DB)(nil)
+	_ Store = (*SQLiteDB)(nil)
+)
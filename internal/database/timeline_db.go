@@ -0,0 +1,85 @@
+package database
+
+import (
+	"fmt"
+	"sort"
+)
+
+// TimelineMode selects how home timelines are assembled: by merging the
+// chirps of followed users when a timeline is read, or by adding each chirp
+// to the timelines of its author's followers when it is written.
+type TimelineMode string
+
+const (
+	FanOutOnRead  TimelineMode = "read"
+	FanOutOnWrite TimelineMode = "write"
+)
+
+func ParseTimelineMode(s string) (TimelineMode, error) {
+	switch mode := TimelineMode(s); mode {
+	case FanOutOnRead, FanOutOnWrite:
+		return mode, nil
+	default:
+		return "", fmt.Errorf("unknown timeline mode %q", s)
+	}
+}
+
+// GetTimeline returns the chirps of userID and every user they follow.
+func (db *DB) GetTimeline(userID int, query ChirpQuery) (ChirpPage, error) {
+	query.timelineUserID = userID
+	return db.GetChirps(query)
+}
+
+// timelineSources returns the sorted chirp ID lists that make up the
+// timeline of userID.
+func (dbStructure *DBStructure) timelineSources(userID int) [][]int {
+	if dbStructure.idx.timelines != nil {
+		return [][]int{dbStructure.idx.timelines[userID]}
+	}
+	sources := [][]int{dbStructure.idx.chirpsByAuthor[userID]}
+	for _, followeeID := range dbStructure.idx.following[userID] {
+		sources = append(sources, dbStructure.idx.chirpsByAuthor[followeeID])
+	}
+	return sources
+}
+
+// walkMerged visits the IDs in (low, high] across the ascending lists in
+// sources, in ascending or descending order, until visit returns false.
+// The lists must not share IDs.
+func walkMerged(sources [][]int, low int, high int, descending bool, visit func(id int) bool) {
+	positions := make([]int, len(sources))
+	for i, ids := range sources {
+		if descending {
+			positions[i] = sort.Search(len(ids), func(j int) bool { return ids[j] > high }) - 1
+		} else {
+			positions[i] = sort.SearchInts(ids, low+1)
+		}
+	}
+
+	for {
+		next := -1
+		for i, ids := range sources {
+			pos := positions[i]
+			if pos < 0 || pos >= len(ids) || ids[pos] <= low || ids[pos] > high {
+				continue
+			}
+			if next == -1 || (descending && ids[pos] > sources[next][positions[next]]) ||
+				(!descending && ids[pos] < sources[next][positions[next]]) {
+				next = i
+			}
+		}
+		if next == -1 {
+			return
+		}
+
+		id := sources[next][positions[next]]
+		if descending {
+			positions[next]--
+		} else {
+			positions[next]++
+		}
+		if !visit(id) {
+			return
+		}
+	}
+}
@@ -0,0 +1,67 @@
+package database
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	AccessTokenTTL  = time.Hour
+	refreshTokenTTL = 60 * 24 * time.Hour
+)
+
+// A TokenSigner signs access tokens; it picks the key and algorithm.
+type TokenSigner interface {
+	Sign(claims jwt.Claims) (string, error)
+}
+
+// AccessClaims are the claims of an access token. SessionID names the
+// session the token was issued for; tokens from before sessions were named
+// leave it zero.
+type AccessClaims struct {
+	jwt.RegisteredClaims
+	SessionID int `json:"sid,omitempty"`
+}
+
+func signAccessToken(issuer string, userID int, sessionID int, signer TokenSigner) (string, error) {
+	now := time.Now().UTC()
+	claims := AccessClaims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    issuer,
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(AccessTokenTTL)),
+			Subject:   strconv.Itoa(userID),
+		},
+		SessionID: sessionID,
+	}
+
+	tokenString, err := signer.Sign(claims)
+	if err != nil {
+		return "", errors.New("error signing the token")
+	}
+	return tokenString, nil
+}
+
+// hashToken returns the keyed hash that refresh tokens and other secrets
+// are stored as, so that reading the database is not enough to use them.
+func hashToken(key []byte, token string) string {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(token))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func generateToken() (string, error) {
+	bytes := make([]byte, 32)
+	_, err := rand.Read(bytes)
+	if err != nil {
+		return "", errors.New("error generating random bytes")
+	}
+	return hex.EncodeToString(bytes), nil
+}
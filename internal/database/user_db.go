@@ -1,260 +1,221 @@
 package database
 
 import (
-	"encoding/hex"
+	"bytes"
 	"errors"
-	"strconv"
 	"strings"
 	"time"
 
-	"crypto/rand"
-
-	"github.com/golang-jwt/jwt/v5"
 	"golang.org/x/crypto/bcrypt"
 )
 
 type User struct {
-	ID                 int       `json:"id"`
-	Email              string    `json:"email"`
-	Password           []byte    `json:"password"`
-	RefreshToken       string    `json:"refresh_token"`
-	RefreshTokenExpiry time.Time `json:"refresh_token_expiry"`
-	IsChirpyRed        bool      `json:"is_chirpy_red"`
+	ID          int    `json:"id"`
+	Email       string `json:"email"`
+	Password    []byte `json:"password"`
+	IsChirpyRed bool   `json:"is_chirpy_red"`
+	Handle      string `json:"handle"`
+	DisplayName string `json:"display_name"`
+	Bio         string `json:"bio"`
+	AvatarURL   string `json:"avatar_url"`
+
+	EmailVerified bool `json:"email_verified"`
+
+	// RefreshToken and RefreshTokenExpiry predate sessions and are only
+	// read by the migration that turns them into one.
+	RefreshToken       string     `json:"refresh_token,omitempty"`
+	RefreshTokenExpiry *time.Time `json:"refresh_token_expiry,omitempty"`
 }
 
+// UserResp is the public profile of a user and is safe to show to anyone.
 type UserResp struct {
 	ID          int    `json:"id"`
-	Email       string `json:"email"`
+	Handle      string `json:"handle"`
+	DisplayName string `json:"display_name"`
+	Bio         string `json:"bio"`
+	AvatarURL   string `json:"avatar_url"`
 	IsChirpyRed bool   `json:"is_chirpy_red"`
 }
 
+// AccountResp is returned to users about their own account.
+type AccountResp struct {
+	UserResp
+	Email         string `json:"email"`
+	EmailVerified bool   `json:"email_verified"`
+}
+
 type LoginResp struct {
 	ID           int    `json:"id"`
 	Email        string `json:"email"`
+	Handle       string `json:"handle"`
 	Token        string `json:"token"`
 	RefreshToken string `json:"refresh_token"`
 	IsChirpyRed  bool   `json:"is_chirpy_red"`
 }
 
 type RefreshResp struct {
-	Token string `json:"token"`
+	Token        string `json:"token"`
+	RefreshToken string `json:"refresh_token"`
+}
+
+func (user User) public() UserResp {
+	return UserResp{
+		ID:          user.ID,
+		Handle:      user.Handle,
+		DisplayName: user.DisplayName,
+		Bio:         user.Bio,
+		AvatarURL:   user.AvatarURL,
+		IsChirpyRed: user.IsChirpyRed,
+	}
+}
+
+func (user User) account() AccountResp {
+	return AccountResp{UserResp: user.public(), Email: user.Email, EmailVerified: user.EmailVerified}
 }
 
-func (db *DB) CreateUser(email string, password []byte) (UserResp, error) {
+// CreateUser registers a new user. An empty handle is derived from the
+// email address.
+func (db *DB) CreateUser(email string, handle string, password []byte) (AccountResp, error) {
 	db.mux.Lock()
 	defer db.mux.Unlock()
 
-	dbStructure, err := db.loadDB()
-	if err != nil {
-		return UserResp{}, err
-	}
+	dbStructure := db.data
 
 	if ok, _ := dbStructure.userExists(email); ok {
-		return UserResp{}, errors.New("user already exists")
+		return AccountResp{}, ErrEmailTaken
 	}
 
-	id := len(dbStructure.Users) + 1
+	handleTaken := func(handle string) bool {
+		_, ok := dbStructure.idx.userByHandle[handle]
+		return ok
+	}
+	if handle == "" {
+		handle = handleFromEmail(email, handleTaken)
+	} else {
+		handle = normalizeHandle(handle)
+		if err := validateHandle(handle); err != nil {
+			return AccountResp{}, err
+		}
+		if handleTaken(handle) {
+			return AccountResp{}, ErrHandleTaken
+		}
+	}
+
+	id := dbStructure.nextID(collectionUsers)
 	user := User{
 		ID:          id,
 		Email:       strings.ToLower(email),
 		Password:    password,
 		IsChirpyRed: false,
-	}
-	userResp := UserResp{
-		ID:          id,
-		Email:       strings.ToLower(email),
-		IsChirpyRed: false,
+		Handle:      handle,
 	}
 
-	dbStructure.Users[id] = user
-	if err = db.writeDB(dbStructure); err != nil {
-		return UserResp{}, err
+	if err := db.commit(putEntry(collectionUsers, id, user)); err != nil {
+		return AccountResp{}, err
 	}
 
-	return userResp, nil
+	return user.account(), nil
 }
 
-func (db *DB) Login(email string, password string, secretKey []byte) (LoginResp, error) {
+// Login checks the user's password. Users with two-factor authentication
+// get a challenge instead of tokens, to be completed by CompleteMFALogin.
+func (db *DB) Login(email string, password string, signer TokenSigner, client SessionInfo) (LoginResp, *MFAChallengeResp, error) {
 	db.mux.RLock()
-	defer db.mux.RUnlock()
+	ok, user := db.data.userExists(email)
+	db.mux.RUnlock()
+	if !ok {
+		return LoginResp{}, nil, errors.New("no such user found")
+	}
 
-	dbStructure, err := db.loadDB()
+	// bcrypt is slow on purpose, so the hash is compared without holding
+	// the lock.
+	err := bcrypt.CompareHashAndPassword(user.Password, []byte(password))
 	if err != nil {
-		return LoginResp{}, err
+		return LoginResp{}, nil, errors.New("incorrect password")
 	}
 
-	ok, user := dbStructure.userExists(email)
+	db.mux.Lock()
+	defer db.mux.Unlock()
+
+	dbStructure := db.data
+
+	// The user may have been deleted or changed their password while the
+	// hash was being compared.
+	current, ok := dbStructure.Users[user.ID]
 	if !ok {
-		return LoginResp{}, errors.New("no such user found")
+		return LoginResp{}, nil, errors.New("no such user found")
 	}
-
-	err = bcrypt.CompareHashAndPassword(user.Password, []byte(password))
-	if err != nil {
-		return LoginResp{}, errors.New("incorrect password")
+	if !bytes.Equal(current.Password, user.Password) {
+		return LoginResp{}, nil, errors.New("incorrect password")
 	}
+	user = current
 
-	now := time.Now().UTC()
-	claims := jwt.RegisteredClaims{
-		Issuer:    "chirpy",
-		IssuedAt:  jwt.NewNumericDate(now),
-		ExpiresAt: jwt.NewNumericDate(now.Add(time.Hour)),
-		Subject:   strconv.Itoa(user.ID),
+	if dbStructure.TOTP[user.ID].Enabled {
+		challenge, entries, err := dbStructure.newMFAChallenge(user.ID, db.tokenKey)
+		if err != nil {
+			return LoginResp{}, nil, err
+		}
+		if err = db.commit(entries...); err != nil {
+			return LoginResp{}, nil, err
+		}
+		return LoginResp{}, &challenge, nil
 	}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	tokenString, err := token.SignedString(secretKey)
+	sessionID, refreshToken, entries, err := dbStructure.newSession(user.ID, client, db.tokenKey)
 	if err != nil {
-		return LoginResp{}, errors.New("error signing the token")
+		return LoginResp{}, nil, err
 	}
 
-	bytes := make([]byte, 32)
-	_, err = rand.Read(bytes)
+	tokenString, err := signAccessToken("chirpy", user.ID, sessionID, signer)
 	if err != nil {
-		return LoginResp{}, errors.New("error generating random bytes")
+		return LoginResp{}, nil, err
 	}
-	refreshToken := hex.EncodeToString(bytes)
-	refreshTokenExpiry := now.Add(60 * 24 * time.Hour)
 
-	user.RefreshToken = refreshToken
-	user.RefreshTokenExpiry = refreshTokenExpiry
-	dbStructure.Users[user.ID] = user
-
-	err = db.writeDB(dbStructure)
+	err = db.commit(entries...)
 	if err != nil {
-		return LoginResp{}, err
-	}
-
-	loginResp := LoginResp{
-		ID:           user.ID,
-		Email:        user.Email,
-		Token:        tokenString,
-		RefreshToken: refreshToken,
-		IsChirpyRed:  user.IsChirpyRed,
+		return LoginResp{}, nil, err
 	}
 
-	return loginResp, nil
+	return user.loginResp(tokenString, refreshToken), nil, nil
 }
 
+// userExists looks a user up by email, ignoring case.
 func (dbStructure *DBStructure) userExists(email string) (bool, User) {
-	for _, userVal := range dbStructure.Users {
-		if email == userVal.Email {
-			return true, userVal
-		}
+	id, ok := dbStructure.idx.userByEmail[strings.ToLower(email)]
+	if !ok {
+		return false, User{}
 	}
-	return false, User{}
+	return true, dbStructure.Users[id]
 }
 
-func (db *DB) UpdateUser(id int, newEmail string, newPassword []byte) (UserResp, error) {
+// UpdateUser sets the user's email and password. A new password ends every
+// session but keepSessionID, the one it was changed from.
+func (db *DB) UpdateUser(id int, newEmail string, newPassword []byte, keepSessionID int) (AccountResp, error) {
 	db.mux.Lock()
 	defer db.mux.Unlock()
 
-	dbStructure, err := db.loadDB()
-	if err != nil {
-		return UserResp{}, err
-	}
+	dbStructure := db.data
 
-	userBody, exists := dbStructure.Users[id]
+	user, exists := dbStructure.Users[id]
 	if !exists {
-		return UserResp{}, errors.New("user not found")
-	}
-
-	user := User{
-		ID:                 id,
-		Email:              strings.ToLower(newEmail),
-		Password:           newPassword,
-		RefreshToken:       userBody.RefreshToken,
-		RefreshTokenExpiry: userBody.RefreshTokenExpiry,
-		IsChirpyRed:        userBody.IsChirpyRed,
-	}
-
-	dbStructure.Users[id] = user
-	if err := db.writeDB(dbStructure); err != nil {
-		return UserResp{}, err
+		return AccountResp{}, errors.New("user not found")
 	}
 
-	userResp := UserResp{
-		ID:    user.ID,
-		Email: user.Email,
+	newEmail = strings.ToLower(newEmail)
+	if ok, other := dbStructure.userExists(newEmail); ok && other.ID != id {
+		return AccountResp{}, ErrEmailTaken
 	}
-
-	return userResp, nil
-}
-
-func (db *DB) RefreshAccessToken(refreshToken string, secretKey []byte) (RefreshResp, error) {
-	db.mux.RLock()
-	defer db.mux.RUnlock()
-
-	dbStructure, err := db.loadDB()
-	if err != nil {
-		return RefreshResp{}, err
+	if newEmail != user.Email {
+		user.EmailVerified = false
 	}
+	user.Email = newEmail
+	user.Password = newPassword
 
-	userID := 0
-	exists := false
-	for _, user := range dbStructure.Users {
-		if user.RefreshToken == refreshToken {
-			if time.Now().After(user.RefreshTokenExpiry) {
-				return RefreshResp{}, errors.New("expired refresh token")
-			} else {
-				userID = user.ID
-				exists = true
-				break
-			}
-		}
-	}
-	if !exists {
-		return RefreshResp{}, errors.New("refresh token not found")
+	entries := dbStructure.revokeOtherSessions(id, keepSessionID)
+	entries = append(entries, putEntry(collectionUsers, id, user))
+	if err := db.commit(entries...); err != nil {
+		return AccountResp{}, err
 	}
 
-	now := time.Now().UTC()
-	claims := jwt.RegisteredClaims{
-		Issuer:    "chirpy-refresh",
-		IssuedAt:  jwt.NewNumericDate(now),
-		ExpiresAt: jwt.NewNumericDate(now.Add(time.Hour)),
-		Subject:   strconv.Itoa(userID),
-	}
-
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	tokenString, err := token.SignedString(secretKey)
-	if err != nil {
-		return RefreshResp{}, errors.New("error signing the token")
-	}
-
-	return RefreshResp{Token: tokenString}, nil
-}
-
-func (db *DB) RevokeRefreshToken(refreshToken string) error {
-	db.mux.Lock()
-	defer db.mux.Unlock()
-
-	dbStructure, err := db.loadDB()
-	if err != nil {
-		return err
-	}
-
-	userBody := User{}
-	exists := false
-	for _, user := range dbStructure.Users {
-		if user.RefreshToken == refreshToken {
-			if time.Now().After(user.RefreshTokenExpiry) {
-				return errors.New("expired refresh token")
-			} else {
-				userBody = user
-				exists = true
-				break
-			}
-		}
-	}
-	if !exists {
-		return errors.New("refresh token not found")
-	}
-
-	userBody.RefreshToken = ""
-	userBody.RefreshTokenExpiry = time.Time{}
-	dbStructure.Users[userBody.ID] = userBody
-
-	if err = db.writeDB(dbStructure); err != nil {
-		return err
-	}
-	return nil
+	return user.account(), nil
 }
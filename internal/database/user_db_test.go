@@ -0,0 +1,58 @@
+package database
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestUpdateUserRefusesTakenEmail(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store Store) {
+		a, err := store.CreateUser("a@example.com", "", []byte("hash"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := store.CreateUser("b@example.com", "", []byte("hash"))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := store.UpdateUser(b.ID, "A@example.com", []byte("hash"), 0); !errors.Is(err, ErrEmailTaken) {
+			t.Fatalf("UpdateUser to a taken email returned %v, want ErrEmailTaken", err)
+		}
+		if _, err := store.UpdateUser(a.ID, "a@example.com", []byte("new"), 0); err != nil {
+			t.Fatalf("UpdateUser keeping the same email: %v", err)
+		}
+		account, err := store.GetAccount(a.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if account.Email != "a@example.com" {
+			t.Fatalf("user a has email %q after b tried to take it", account.Email)
+		}
+	})
+}
+
+func TestCreateUserRefusesEmailInOtherCase(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store Store) {
+		if _, err := store.CreateUser("a@example.com", "", []byte("hash")); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := store.CreateUser("A@Example.com", "", []byte("hash")); !errors.Is(err, ErrEmailTaken) {
+			t.Fatalf("CreateUser with a taken email in other case returned %v, want ErrEmailTaken", err)
+		}
+	})
+}
+
+func TestLoginIgnoresEmailCase(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store Store) {
+		userID, _ := loginTestUser(t, store, "a@example.com")
+
+		resp, _, err := store.Login("A@Example.com", "password", testSigner{}, SessionInfo{})
+		if err != nil {
+			t.Fatalf("Login with the email in other case: %v", err)
+		}
+		if resp.ID != userID {
+			t.Fatalf("Login returned user %d, want %d", resp.ID, userID)
+		}
+	})
+}
@@ -0,0 +1,102 @@
+package jwtkeys
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+)
+
+// JWK is the public half of a key as a JSON Web Key (RFC 7517).
+type JWK struct {
+	KeyType   string `json:"kty"`
+	KeyID     string `json:"kid,omitempty"`
+	Use       string `json:"use,omitempty"`
+	Algorithm string `json:"alg,omitempty"`
+	Curve     string `json:"crv,omitempty"`
+	N         string `json:"n,omitempty"`
+	E         string `json:"e,omitempty"`
+	X         string `json:"x,omitempty"`
+	Y         string `json:"y,omitempty"`
+}
+
+type JWKSet struct {
+	Keys []JWK `json:"keys"`
+}
+
+// JWKS returns the public keys that tokens may currently be signed with.
+func (ks *KeySet) JWKS() JWKSet {
+	ks.mux.RLock()
+	defer ks.mux.RUnlock()
+
+	set := JWKSet{Keys: make([]JWK, 0, len(ks.keys))}
+	for _, key := range ks.keys {
+		jwk, _ := publicJWK(key.signer.Public())
+		jwk.KeyID = key.ID
+		jwk.Use = "sig"
+		jwk.Algorithm = key.Algorithm
+		set.Keys = append(set.Keys, jwk)
+	}
+	return set
+}
+
+func publicJWK(public any) (JWK, error) {
+	switch public := public.(type) {
+	case *rsa.PublicKey:
+		return JWK{
+			KeyType: "RSA",
+			N:       encode(public.N.Bytes()),
+			E:       encode(big.NewInt(int64(public.E)).Bytes()),
+		}, nil
+	case *ecdsa.PublicKey:
+		size := (public.Curve.Params().BitSize + 7) / 8
+		return JWK{
+			KeyType: "EC",
+			Curve:   public.Curve.Params().Name,
+			X:       encode(public.X.FillBytes(make([]byte, size))),
+			Y:       encode(public.Y.FillBytes(make([]byte, size))),
+		}, nil
+	case ed25519.PublicKey:
+		return JWK{KeyType: "OKP", Curve: "Ed25519", X: encode(public)}, nil
+	default:
+		return JWK{}, errors.New("unsupported key type")
+	}
+}
+
+// thumbprint returns the JWK thumbprint (RFC 7638): the hash of the
+// required members only, in lexical order and without whitespace.
+func (jwk JWK) thumbprint() string {
+	var members any
+	switch jwk.KeyType {
+	case "RSA":
+		members = struct {
+			E   string `json:"e"`
+			Kty string `json:"kty"`
+			N   string `json:"n"`
+		}{jwk.E, jwk.KeyType, jwk.N}
+	case "EC":
+		members = struct {
+			Crv string `json:"crv"`
+			Kty string `json:"kty"`
+			X   string `json:"x"`
+			Y   string `json:"y"`
+		}{jwk.Curve, jwk.KeyType, jwk.X, jwk.Y}
+	default:
+		members = struct {
+			Crv string `json:"crv"`
+			Kty string `json:"kty"`
+			X   string `json:"x"`
+		}{jwk.Curve, jwk.KeyType, jwk.X}
+	}
+	data, _ := json.Marshal(members)
+	sum := sha256.Sum256(data)
+	return encode(sum[:])
+}
+
+func encode(data []byte) string {
+	return base64.RawURLEncoding.EncodeToString(data)
+}
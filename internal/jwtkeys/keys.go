@@ -0,0 +1,364 @@
+package jwtkeys
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	RS256 = "RS256"
+	ES256 = "ES256"
+	EdDSA = "EdDSA"
+)
+
+// Algorithms lists the signing algorithms tokens are accepted with. The
+// algorithm of each token must also match the key named by its kid.
+var Algorithms = []string{RS256, ES256, EdDSA}
+
+// manifestName is the file in the key directory that records when each key
+// was created, by key ID. File modification times can't be trusted for
+// that, since copying or restoring the directory resets them.
+const manifestName = "keys.json"
+
+var (
+	ErrUnknownKey        = errors.New("unknown signing key")
+	ErrAlgorithmMismatch = errors.New("token algorithm does not match its key")
+)
+
+// A Key is a private signing key loaded from a PEM file. Its ID is the JWK
+// thumbprint of its public half, so it doesn't depend on the file name.
+type Key struct {
+	ID        string
+	Algorithm string
+	Created   time.Time
+
+	signer crypto.Signer
+	path   string
+}
+
+func (k *Key) method() jwt.SigningMethod {
+	return jwt.GetSigningMethod(k.Algorithm)
+}
+
+// A KeySet holds the keys in a directory. The newest key signs new tokens;
+// older keys have been rotated out and only verify tokens signed before
+// that, until those tokens have expired too.
+type KeySet struct {
+	dir      string
+	tokenTTL time.Duration
+
+	mux  sync.RWMutex
+	keys []*Key
+}
+
+// Load reads every .pem file in dir, creating the directory and a first key
+// using algorithm if there are none. If the newest key uses another
+// algorithm, because algorithm was changed since it was created, a key
+// using algorithm is generated to sign new tokens and the others are kept
+// to verify the tokens they signed. tokenTTL is how long the tokens signed
+// by a key stay valid, and so how long the key is kept after rotation.
+func Load(dir string, algorithm string, tokenTTL time.Duration) (*KeySet, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	paths, err := filepath.Glob(filepath.Join(dir, "*.pem"))
+	if err != nil {
+		return nil, err
+	}
+
+	created, err := readManifest(filepath.Join(dir, manifestName))
+	if err != nil {
+		return nil, err
+	}
+
+	ks := &KeySet{dir: dir, tokenTTL: tokenTTL}
+	recorded := true
+	for _, path := range paths {
+		key, err := readKey(path)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", path, err)
+		}
+		if t, ok := created[key.ID]; ok {
+			key.Created = t
+		} else {
+			recorded = false
+		}
+		ks.keys = append(ks.keys, key)
+	}
+	sort.Slice(ks.keys, func(i, j int) bool {
+		if !ks.keys[i].Created.Equal(ks.keys[j].Created) {
+			return ks.keys[i].Created.Before(ks.keys[j].Created)
+		}
+		return ks.keys[i].ID < ks.keys[j].ID
+	})
+
+	if !recorded || len(created) != len(ks.keys) {
+		if err := ks.writeManifest(); err != nil {
+			return nil, err
+		}
+	}
+
+	if len(ks.keys) == 0 || ks.keys[len(ks.keys)-1].Algorithm != algorithm {
+		if _, err := ks.Rotate(algorithm); err != nil {
+			return nil, err
+		}
+	}
+	if _, err := ks.Prune(time.Now()); err != nil {
+		return nil, err
+	}
+	return ks, nil
+}
+
+// Active returns the key new tokens are signed with.
+func (ks *KeySet) Active() Key {
+	ks.mux.RLock()
+	defer ks.mux.RUnlock()
+	return *ks.keys[len(ks.keys)-1]
+}
+
+// Sign signs claims with the active key and names it in the kid header.
+func (ks *KeySet) Sign(claims jwt.Claims) (string, error) {
+	ks.mux.RLock()
+	key := ks.keys[len(ks.keys)-1]
+	ks.mux.RUnlock()
+
+	token := jwt.NewWithClaims(key.method(), claims)
+	token.Header["kid"] = key.ID
+	return token.SignedString(key.signer)
+}
+
+// Keyfunc returns the public key a token claims to be signed with, for use
+// with jwt.Parse. The token must name a known key and use that key's
+// algorithm, so a token can't pick how it is verified.
+func (ks *KeySet) Keyfunc(token *jwt.Token) (any, error) {
+	kid, ok := token.Header["kid"].(string)
+	if !ok {
+		return nil, ErrUnknownKey
+	}
+	key, ok := ks.lookup(kid)
+	if !ok {
+		return nil, ErrUnknownKey
+	}
+	if token.Method.Alg() != key.Algorithm {
+		return nil, ErrAlgorithmMismatch
+	}
+	return key.signer.Public(), nil
+}
+
+func (ks *KeySet) lookup(kid string) (*Key, bool) {
+	ks.mux.RLock()
+	defer ks.mux.RUnlock()
+	for _, key := range ks.keys {
+		if key.ID == kid {
+			return key, true
+		}
+	}
+	return nil, false
+}
+
+// Rotate generates a new key using algorithm, stores it and makes it the
+// active key. The previous key keeps verifying until Prune removes it.
+func (ks *KeySet) Rotate(algorithm string) (Key, error) {
+	signer, err := generate(algorithm)
+	if err != nil {
+		return Key{}, err
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(signer)
+	if err != nil {
+		return Key{}, err
+	}
+	key, err := newKey(signer)
+	if err != nil {
+		return Key{}, err
+	}
+	key.Created = time.Now().UTC()
+	key.path = filepath.Join(ks.dir, key.ID+".pem")
+
+	if err := writeFile(key.path, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})); err != nil {
+		return Key{}, err
+	}
+
+	ks.mux.Lock()
+	defer ks.mux.Unlock()
+	ks.keys = append(ks.keys, key)
+	if err := ks.writeManifest(); err != nil {
+		return Key{}, err
+	}
+	return *key, nil
+}
+
+// Prune deletes the keys that were rotated out long enough before now that
+// every token they signed has expired, and returns their IDs.
+func (ks *KeySet) Prune(now time.Time) ([]string, error) {
+	ks.mux.Lock()
+	defer ks.mux.Unlock()
+
+	pruned := []string{}
+	for len(ks.keys) > 1 && !now.Before(ks.keys[1].Created.Add(ks.tokenTTL)) {
+		if err := os.Remove(ks.keys[0].path); err != nil && !os.IsNotExist(err) {
+			return pruned, err
+		}
+		pruned = append(pruned, ks.keys[0].ID)
+		ks.keys = ks.keys[1:]
+	}
+	if len(pruned) > 0 {
+		if err := ks.writeManifest(); err != nil {
+			return pruned, err
+		}
+	}
+	return pruned, nil
+}
+
+func readManifest(path string) (map[string]time.Time, error) {
+	created := map[string]time.Time{}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return created, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &created); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return created, nil
+}
+
+// writeManifest records when each key in the set was created. Callers
+// hold ks.mux or have the set to themselves.
+func (ks *KeySet) writeManifest() error {
+	created := make(map[string]time.Time, len(ks.keys))
+	for _, key := range ks.keys {
+		created[key.ID] = key.Created
+	}
+	data, err := json.MarshalIndent(created, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFile(filepath.Join(ks.dir, manifestName), data)
+}
+
+func generate(algorithm string) (crypto.Signer, error) {
+	switch algorithm {
+	case RS256:
+		return rsa.GenerateKey(rand.Reader, 2048)
+	case ES256:
+		return ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	case EdDSA:
+		_, private, err := ed25519.GenerateKey(rand.Reader)
+		return private, err
+	default:
+		return nil, fmt.Errorf("unsupported signing algorithm %q", algorithm)
+	}
+}
+
+// readKey loads a PKCS #8, PKCS #1 or SEC 1 private key. Until the key is
+// in the manifest, the file's modification time stands in for when it was
+// created.
+func readKey(path string) (*Key, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no PEM data found")
+	}
+
+	var parsed any
+	switch block.Type {
+	case "PRIVATE KEY":
+		parsed, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	case "RSA PRIVATE KEY":
+		parsed, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		parsed, err = x509.ParseECPrivateKey(block.Bytes)
+	default:
+		return nil, fmt.Errorf("unsupported PEM block %q", block.Type)
+	}
+	if err != nil {
+		return nil, err
+	}
+	signer, ok := parsed.(crypto.Signer)
+	if !ok {
+		return nil, errors.New("unsupported key type")
+	}
+
+	key, err := newKey(signer)
+	if err != nil {
+		return nil, err
+	}
+	key.Created = info.ModTime().UTC()
+	key.path = path
+	return key, nil
+}
+
+func newKey(signer crypto.Signer) (*Key, error) {
+	key := &Key{signer: signer}
+	switch public := signer.Public().(type) {
+	case *rsa.PublicKey:
+		if public.N.BitLen() < 2048 {
+			return nil, errors.New("RSA keys must be at least 2048 bits")
+		}
+		key.Algorithm = RS256
+	case *ecdsa.PublicKey:
+		if public.Curve != elliptic.P256() {
+			return nil, errors.New("ECDSA keys must use P-256")
+		}
+		key.Algorithm = ES256
+	case ed25519.PublicKey:
+		key.Algorithm = EdDSA
+	default:
+		return nil, errors.New("unsupported key type")
+	}
+
+	jwk, err := publicJWK(signer.Public())
+	if err != nil {
+		return nil, err
+	}
+	key.ID = jwk.thumbprint()
+	return key, nil
+}
+
+// writeFile writes a private key or the manifest readable by its owner
+// only, replacing path atomically so a crash never leaves half a file
+// behind.
+func writeFile(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err = tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err = tmp.Chmod(0600); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
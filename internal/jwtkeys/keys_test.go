@@ -0,0 +1,125 @@
+package jwtkeys
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+func TestCreationTimeSurvivesCopy(t *testing.T) {
+	dir := t.TempDir()
+	ks, err := Load(dir, EdDSA, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	first := ks.Active()
+	second, err := ks.Rotate(EdDSA)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Copying the directory gives the older key the newer modification
+	// time; it must not become the active key again.
+	future := time.Now().Add(time.Minute)
+	if err := os.Chtimes(filepath.Join(dir, first.ID+".pem"), future, future); err != nil {
+		t.Fatal(err)
+	}
+
+	ks, err = Load(dir, EdDSA, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if active := ks.Active(); active.ID != second.ID {
+		t.Fatalf("active key is %s after reloading, want %s", active.ID, second.ID)
+	}
+	if keys := ks.JWKS().Keys; len(keys) != 2 || keys[0].KeyID != first.ID {
+		t.Fatalf("reloaded keys %+v, want %s then %s", keys, first.ID, second.ID)
+	}
+}
+
+func TestPruneDropsKeysFromManifest(t *testing.T) {
+	dir := t.TempDir()
+	ks, err := Load(dir, ES256, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	first := ks.Active()
+	if _, err := ks.Rotate(ES256); err != nil {
+		t.Fatal(err)
+	}
+
+	pruned, err := ks.Prune(time.Now().Add(2 * time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pruned) != 1 || pruned[0] != first.ID {
+		t.Fatalf("pruned %v, want [%s]", pruned, first.ID)
+	}
+	created, err := readManifest(filepath.Join(dir, manifestName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := created[first.ID]; ok || len(created) != 1 {
+		t.Fatalf("manifest after pruning: %v", created)
+	}
+}
+
+func TestLoadRecordsUnknownKeys(t *testing.T) {
+	dir := t.TempDir()
+	ks, err := Load(dir, EdDSA, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key := ks.Active()
+	if err := os.Remove(filepath.Join(dir, manifestName)); err != nil {
+		t.Fatal(err)
+	}
+	mtime := time.Now().Add(-time.Hour).Truncate(time.Second).UTC()
+	if err := os.Chtimes(filepath.Join(dir, key.ID+".pem"), mtime, mtime); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := Load(dir, EdDSA, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	created, err := readManifest(filepath.Join(dir, manifestName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !created[key.ID].Equal(mtime) {
+		t.Fatalf("key recorded as created %v, want its modification time %v", created[key.ID], mtime)
+	}
+}
+
+func TestLoadRotatesToChangedAlgorithm(t *testing.T) {
+	dir := t.TempDir()
+	ks, err := Load(dir, EdDSA, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	token, err := ks.Sign(jwt.RegisteredClaims{Subject: "1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ks, err = Load(dir, ES256, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if active := ks.Active(); active.Algorithm != ES256 {
+		t.Fatalf("active key uses %s after changing the algorithm, want %s", active.Algorithm, ES256)
+	}
+	if _, err := jwt.Parse(token, ks.Keyfunc, jwt.WithValidMethods(Algorithms)); err != nil {
+		t.Fatalf("token signed before the change no longer verifies: %v", err)
+	}
+
+	if ks, err = Load(dir, ES256, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	if keys := ks.JWKS().Keys; len(keys) != 2 {
+		t.Fatalf("reloading with the same algorithm left %d keys, want 2", len(keys))
+	}
+}
@@ -0,0 +1,97 @@
+package mailer
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/smtp"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// A Mailer delivers messages to users.
+type Mailer interface {
+	Send(msg Message) error
+}
+
+// format returns msg as a plain text email. Line breaks are stripped from
+// the headers so that user input can't add headers of its own.
+func format(from string, msg Message, date time.Time) []byte {
+	header := strings.NewReplacer("\r", "", "\n", "")
+	var b strings.Builder
+	fmt.Fprintf(&b, "From: %s\r\n", header.Replace(from))
+	fmt.Fprintf(&b, "To: %s\r\n", header.Replace(msg.To))
+	fmt.Fprintf(&b, "Subject: %s\r\n", header.Replace(msg.Subject))
+	fmt.Fprintf(&b, "Date: %s\r\n", date.Format(time.RFC1123Z))
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=utf-8\r\n\r\n")
+	b.WriteString(strings.ReplaceAll(msg.Body, "\n", "\r\n"))
+	return []byte(b.String())
+}
+
+// SMTP sends messages through an SMTP server, authenticating with PLAIN
+// auth when a username is set.
+type SMTP struct {
+	addr string
+	from string
+	auth smtp.Auth
+}
+
+func NewSMTP(addr string, from string, username string, password string) (*SMTP, error) {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	m := &SMTP{addr: addr, from: from}
+	if username != "" {
+		m.auth = smtp.PlainAuth("", username, password, host)
+	}
+	return m, nil
+}
+
+func (m *SMTP) Send(msg Message) error {
+	return smtp.SendMail(m.addr, m.auth, m.from, []string{msg.To}, format(m.from, msg, time.Now()))
+}
+
+// Writer writes messages to w instead of sending them, for development and
+// for deployments without a mail server.
+type Writer struct {
+	from string
+	mux  sync.Mutex
+	w    io.Writer
+}
+
+func NewWriter(w io.Writer, from string) *Writer {
+	return &Writer{from: from, w: w}
+}
+
+// NewFile returns a Writer appending messages to the file at path.
+func NewFile(path string, from string) (*Writer, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return nil, err
+	}
+	return NewWriter(f, from), nil
+}
+
+// NewLog returns a Writer printing messages to the standard logger.
+func NewLog(from string) *Writer {
+	return NewWriter(log.Writer(), from)
+}
+
+func (m *Writer) Send(msg Message) error {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+
+	_, err := fmt.Fprintf(m.w, "%s\r\n.\r\n", format(m.from, msg, time.Now()))
+	return err
+}
@@ -0,0 +1,165 @@
+package media
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"image"
+	_ "image/gif"
+	"image/jpeg"
+	"image/png"
+	"net/http"
+	"os"
+	"path/filepath"
+)
+
+const (
+	thumbnailSize = 320
+	maxPixels     = 24_000_000
+
+	// maxDecodes bounds how many images are decoded at once. A decoded image
+	// takes up to 8 bytes per pixel, for 16-bit PNGs, and making its
+	// thumbnail adds little on top, so uploads use at most about
+	// maxDecodes*maxPixels*8 bytes, 384MB, for decoding.
+	maxDecodes = 2
+)
+
+var decodeSlots = make(chan struct{}, maxDecodes)
+
+var (
+	ErrUnsupportedType = errors.New("only JPEG, PNG and GIF images are supported")
+	ErrInvalidImage    = errors.New("invalid image")
+)
+
+var extensions = map[string]string{
+	"image/jpeg": ".jpg",
+	"image/png":  ".png",
+	"image/gif":  ".gif",
+}
+
+// Store keeps uploaded images on local disk under the SHA-256 of their
+// contents, so uploading the same image twice stores it once.
+type Store struct {
+	dir string
+}
+
+// Image describes a stored image. Name and ThumbnailName are paths
+// relative to the store directory.
+type Image struct {
+	Hash          string
+	ContentType   string
+	Size          int64
+	Width         int
+	Height        int
+	Name          string
+	ThumbnailName string
+}
+
+func NewStore(dir string) (*Store, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	return &Store{dir: dir}, nil
+}
+
+func (s *Store) Dir() string {
+	return s.dir
+}
+
+// Save validates data as an image, then writes it without its metadata and
+// a thumbnail that fits in a thumbnailSize square.
+func (s *Store) Save(data []byte) (Image, error) {
+	contentType := http.DetectContentType(data)
+	ext, ok := extensions[contentType]
+	if !ok {
+		return Image{}, ErrUnsupportedType
+	}
+
+	// Check the dimensions before decoding, so a small file claiming a huge
+	// image can't make us allocate it.
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil || config.Width <= 0 || config.Height <= 0 || config.Width*config.Height > maxPixels {
+		return Image{}, ErrInvalidImage
+	}
+	// Strip metadata first, so the stored original never has it and the
+	// hash identifies the image rather than the camera settings.
+	data, err = stripMetadata(data, contentType)
+	if err != nil {
+		return Image{}, err
+	}
+	thumbnail, thumbnailExt, err := makeThumbnail(data, contentType)
+	if err != nil {
+		return Image{}, err
+	}
+
+	sum := sha256.Sum256(data)
+	hash := hex.EncodeToString(sum[:])
+	stored := Image{
+		Hash:          hash,
+		ContentType:   contentType,
+		Size:          int64(len(data)),
+		Width:         config.Width,
+		Height:        config.Height,
+		Name:          filepath.Join(hash[:2], hash+ext),
+		ThumbnailName: filepath.Join(hash[:2], hash+"_thumb"+thumbnailExt),
+	}
+
+	if err := s.write(stored.Name, data); err != nil {
+		return Image{}, err
+	}
+	if err := s.write(stored.ThumbnailName, thumbnail.Bytes()); err != nil {
+		return Image{}, err
+	}
+	return stored, nil
+}
+
+// makeThumbnail decodes data and encodes a thumbnail of it, returning the
+// thumbnail and its file extension. It waits for a free decode slot first.
+func makeThumbnail(data []byte, contentType string) (*bytes.Buffer, string, error) {
+	decodeSlots <- struct{}{}
+	defer func() { <-decodeSlots }()
+
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, "", ErrInvalidImage
+	}
+
+	thumbnail := &bytes.Buffer{}
+	if contentType == "image/jpeg" {
+		err = jpeg.Encode(thumbnail, resize(img, thumbnailSize), &jpeg.Options{Quality: 85})
+		return thumbnail, ".jpg", err
+	}
+	err = png.Encode(thumbnail, resize(img, thumbnailSize))
+	return thumbnail, ".png", err
+}
+
+// write stores data at name unless it is already there. Since names are
+// derived from the contents, an existing file never needs replacing.
+func (s *Store) write(name string, data []byte) error {
+	path := filepath.Join(s.dir, name)
+	if _, err := os.Stat(path); err == nil {
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err = tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err = tmp.Chmod(0644); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
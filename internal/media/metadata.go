@@ -0,0 +1,193 @@
+package media
+
+import (
+	"bytes"
+	"encoding/binary"
+)
+
+const (
+	jpegEOI   = 0xd9
+	jpegSOS   = 0xda
+	jpegAPP0  = 0xe0
+	jpegAPP1  = 0xe1
+	jpegAPP2  = 0xe2
+	jpegAPP14 = 0xee
+	jpegAPP15 = 0xef
+	jpegCOM   = 0xfe
+
+	exifOrientation = 0x0112
+)
+
+var exifHeader = []byte("Exif\x00\x00")
+
+// stripMetadata removes metadata from an image of the given content type.
+// Photos in particular carry EXIF, which can reveal where they were taken.
+func stripMetadata(data []byte, contentType string) ([]byte, error) {
+	switch contentType {
+	case "image/jpeg":
+		return stripJPEGMetadata(data)
+	case "image/png":
+		return stripPNGMetadata(data)
+	}
+	return data, nil
+}
+
+// stripJPEGMetadata removes the segments that carry metadata, such as EXIF
+// with its camera details and GPS position, XMP, IPTC and comments. JFIF,
+// ICC profiles and Adobe color information are kept since decoders need
+// them. EXIF is replaced by one holding just the orientation, if the image
+// had one, so it still displays the right way up.
+func stripJPEGMetadata(data []byte) ([]byte, error) {
+	if len(data) < 2 || data[0] != 0xff || data[1] != 0xd8 {
+		return nil, ErrInvalidImage
+	}
+	out := bytes.NewBuffer(make([]byte, 0, len(data)))
+	out.Write(data[:2])
+
+	rest := data[2:]
+	for {
+		if len(rest) < 2 || rest[0] != 0xff {
+			return nil, ErrInvalidImage
+		}
+		marker := rest[1]
+		if marker == 0xff {
+			// Fill byte before a marker.
+			rest = rest[1:]
+			continue
+		}
+		if marker == jpegSOS {
+			// The compressed image data follows, up to the first EOI since
+			// the data can't contain one. Whatever some cameras append
+			// after EOI is dropped too.
+			if end := bytes.Index(rest, []byte{0xff, jpegEOI}); end >= 0 {
+				rest = rest[:end+2]
+			}
+			out.Write(rest)
+			return out.Bytes(), nil
+		}
+		if len(rest) < 4 {
+			return nil, ErrInvalidImage
+		}
+		end := 2 + int(binary.BigEndian.Uint16(rest[2:4]))
+		if end < 4 || end > len(rest) {
+			return nil, ErrInvalidImage
+		}
+		segment, payload := rest[:end], rest[4:end]
+		rest = rest[end:]
+
+		switch {
+		case marker == jpegAPP1:
+			if orientation := exifOrientationOf(payload); orientation > 1 {
+				out.Write(orientationSegment(orientation))
+			}
+		case keepJPEGSegment(marker):
+			out.Write(segment)
+		}
+	}
+}
+
+func keepJPEGSegment(marker byte) bool {
+	if marker == jpegCOM {
+		return false
+	}
+	if marker >= jpegAPP0 && marker <= jpegAPP15 {
+		return marker == jpegAPP0 || marker == jpegAPP2 || marker == jpegAPP14
+	}
+	return true
+}
+
+// exifOrientationOf returns the orientation recorded in an APP1 payload, or
+// 0 if it isn't EXIF or has none.
+func exifOrientationOf(payload []byte) uint16 {
+	tiff, ok := bytes.CutPrefix(payload, exifHeader)
+	if !ok || len(tiff) < 8 {
+		return 0
+	}
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return 0
+	}
+
+	ifd := int(order.Uint32(tiff[4:8]))
+	if ifd < 8 || ifd+2 > len(tiff) {
+		return 0
+	}
+	count := int(order.Uint16(tiff[ifd:]))
+	for i := 0; i < count; i++ {
+		entry := ifd + 2 + i*12
+		if entry+12 > len(tiff) {
+			return 0
+		}
+		// The orientation is a single SHORT, stored in the value field.
+		if order.Uint16(tiff[entry:]) == exifOrientation && order.Uint16(tiff[entry+2:]) == 3 {
+			return order.Uint16(tiff[entry+8:])
+		}
+	}
+	return 0
+}
+
+// orientationSegment builds an APP1 segment with EXIF holding only the
+// given orientation.
+func orientationSegment(orientation uint16) []byte {
+	tiff := []byte("MM\x00\x2a\x00\x00\x00\x08")
+	tiff = binary.BigEndian.AppendUint16(tiff, 1)
+	tiff = binary.BigEndian.AppendUint16(tiff, exifOrientation)
+	tiff = binary.BigEndian.AppendUint16(tiff, 3)
+	tiff = binary.BigEndian.AppendUint32(tiff, 1)
+	tiff = binary.BigEndian.AppendUint16(tiff, orientation)
+	tiff = append(tiff, 0, 0)
+	// No further IFDs.
+	tiff = binary.BigEndian.AppendUint32(tiff, 0)
+
+	segment := []byte{0xff, jpegAPP1}
+	segment = binary.BigEndian.AppendUint16(segment, uint16(2+len(exifHeader)+len(tiff)))
+	segment = append(segment, exifHeader...)
+	return append(segment, tiff...)
+}
+
+// pngMetadataChunks are the ancillary PNG chunks that carry metadata rather
+// than anything needed to display the image.
+var pngMetadataChunks = map[string]bool{
+	"eXIf": true,
+	"tEXt": true,
+	"zTXt": true,
+	"iTXt": true,
+	"tIME": true,
+}
+
+// stripPNGMetadata removes pngMetadataChunks from a PNG, along with
+// anything after its IEND chunk.
+func stripPNGMetadata(data []byte) ([]byte, error) {
+	const signatureLength = 8
+	if len(data) < signatureLength {
+		return nil, ErrInvalidImage
+	}
+	out := bytes.NewBuffer(make([]byte, 0, len(data)))
+	out.Write(data[:signatureLength])
+
+	rest := data[signatureLength:]
+	for len(rest) > 0 {
+		if len(rest) < 12 {
+			return nil, ErrInvalidImage
+		}
+		// Length, type, data and CRC.
+		length := binary.BigEndian.Uint32(rest[:4])
+		if uint64(length) > uint64(len(rest)-12) {
+			return nil, ErrInvalidImage
+		}
+		chunk := rest[:12+length]
+		rest = rest[12+length:]
+		if !pngMetadataChunks[string(chunk[4:8])] {
+			out.Write(chunk)
+		}
+		if string(chunk[4:8]) == "IEND" {
+			break
+		}
+	}
+	return out.Bytes(), nil
+}
@@ -0,0 +1,105 @@
+package media
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"testing"
+)
+
+const secret = "lat=51.5074 lon=-0.1278"
+
+// exifSegment builds a little-endian APP1 EXIF segment with the given
+// orientation, followed by secret standing in for GPS data.
+func exifSegment(orientation uint16) []byte {
+	tiff := []byte("II\x2a\x00\x08\x00\x00\x00")
+	tiff = binary.LittleEndian.AppendUint16(tiff, 1)
+	tiff = binary.LittleEndian.AppendUint16(tiff, exifOrientation)
+	tiff = binary.LittleEndian.AppendUint16(tiff, 3)
+	tiff = binary.LittleEndian.AppendUint32(tiff, 1)
+	tiff = binary.LittleEndian.AppendUint16(tiff, orientation)
+	tiff = append(tiff, 0, 0, 0, 0, 0, 0)
+	tiff = append(tiff, secret...)
+
+	segment := []byte{0xff, jpegAPP1}
+	segment = binary.BigEndian.AppendUint16(segment, uint16(2+len(exifHeader)+len(tiff)))
+	return append(append(segment, exifHeader...), tiff...)
+}
+
+func TestStripJPEGMetadata(t *testing.T) {
+	encoded := &bytes.Buffer{}
+	if err := jpeg.Encode(encoded, image.NewGray(image.Rect(0, 0, 16, 8)), nil); err != nil {
+		t.Fatal(err)
+	}
+	comment := append([]byte{0xff, jpegCOM, 0, byte(2 + len(secret))}, secret...)
+	data := append([]byte{0xff, 0xd8}, exifSegment(6)...)
+	data = append(data, comment...)
+	data = append(data, encoded.Bytes()[2:]...)
+	data = append(data, secret...)
+
+	stripped, err := stripMetadata(data, "image/jpeg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(stripped, []byte(secret)) {
+		t.Fatal("metadata left in the stripped image")
+	}
+	img, err := jpeg.Decode(bytes.NewReader(stripped))
+	if err != nil {
+		t.Fatalf("stripped image doesn't decode: %v", err)
+	}
+	if img.Bounds().Dx() != 16 || img.Bounds().Dy() != 8 {
+		t.Fatalf("stripped image is %v", img.Bounds())
+	}
+
+	app1 := stripped[2:]
+	if app1[1] != jpegAPP1 {
+		t.Fatalf("orientation not kept: segment %#x follows SOI", app1[1])
+	}
+	if got := exifOrientationOf(app1[4 : 2+binary.BigEndian.Uint16(app1[2:4])]); got != 6 {
+		t.Fatalf("kept orientation %d, want 6", got)
+	}
+}
+
+func TestStripPNGMetadata(t *testing.T) {
+	encoded := &bytes.Buffer{}
+	if err := png.Encode(encoded, image.NewGray(image.Rect(0, 0, 16, 8))); err != nil {
+		t.Fatal(err)
+	}
+	text := []byte("tEXtComment\x00" + secret)
+	chunk := binary.BigEndian.AppendUint32(nil, uint32(len(text)-4))
+	chunk = append(chunk, text...)
+	chunk = binary.BigEndian.AppendUint32(chunk, crc32.ChecksumIEEE(text))
+	// The signature and IHDR chunk come first.
+	const ihdrEnd = 8 + 25
+	data := append(append([]byte{}, encoded.Bytes()[:ihdrEnd]...), chunk...)
+	data = append(data, encoded.Bytes()[ihdrEnd:]...)
+
+	stripped, err := stripMetadata(data, "image/png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(stripped, []byte(secret)) {
+		t.Fatal("metadata left in the stripped image")
+	}
+	if !bytes.Equal(stripped, encoded.Bytes()) {
+		t.Fatal("stripping changed more than the metadata")
+	}
+}
+
+func TestStripMetadataRejectsTruncatedImages(t *testing.T) {
+	encoded := &bytes.Buffer{}
+	if err := png.Encode(encoded, image.NewGray(image.Rect(0, 0, 16, 8))); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := stripMetadata(encoded.Bytes()[:20], "image/png"); !errors.Is(err, ErrInvalidImage) {
+		t.Fatalf("truncated PNG returned %v, want ErrInvalidImage", err)
+	}
+	if _, err := stripMetadata([]byte{0xff, 0xd8, 0xff, jpegAPP1, 0xff}, "image/jpeg"); !errors.Is(err, ErrInvalidImage) {
+		t.Fatalf("truncated JPEG returned %v, want ErrInvalidImage", err)
+	}
+}
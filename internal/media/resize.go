@@ -0,0 +1,58 @@
+package media
+
+import (
+	"image"
+	"image/draw"
+)
+
+// resize scales img down to fit in a size by size square, keeping its
+// aspect ratio. Each pixel of the result is the average of the pixels it
+// covers, which keeps thumbnails of detailed images from aliasing. Images
+// that already fit are returned as they are.
+func resize(img image.Image, size int) image.Image {
+	bounds := img.Bounds()
+	srcW, srcH := bounds.Dx(), bounds.Dy()
+	if srcW <= size && srcH <= size {
+		return img
+	}
+
+	dstW, dstH := size, size
+	if srcW > srcH {
+		dstH = max(1, srcH*size/srcW)
+	} else {
+		dstW = max(1, srcW*size/srcH)
+	}
+
+	// Working on premultiplied RGBA pixels makes averaging a plain sum and
+	// lets draw.Draw use its fast paths for the common source formats. Only
+	// the band of source rows behind one row of the result is converted at
+	// a time, so resizing doesn't hold a second full-size copy of img.
+	band := image.NewRGBA(image.Rect(0, 0, srcW, srcH/dstH+1))
+
+	dst := image.NewRGBA(image.Rect(0, 0, dstW, dstH))
+	for y := 0; y < dstH; y++ {
+		y0, y1 := y*srcH/dstH, max((y+1)*srcH/dstH, y*srcH/dstH+1)
+		draw.Draw(band, image.Rect(0, 0, srcW, y1-y0), img, bounds.Min.Add(image.Pt(0, y0)), draw.Src)
+		for x := 0; x < dstW; x++ {
+			x0, x1 := x*srcW/dstW, max((x+1)*srcW/dstW, x*srcW/dstW+1)
+
+			var sum [4]int
+			for sy := 0; sy < y1-y0; sy++ {
+				row := band.Pix[sy*band.Stride+x0*4 : sy*band.Stride+x1*4]
+				for i := 0; i < len(row); i += 4 {
+					sum[0] += int(row[i])
+					sum[1] += int(row[i+1])
+					sum[2] += int(row[i+2])
+					sum[3] += int(row[i+3])
+				}
+			}
+
+			n := (y1 - y0) * (x1 - x0)
+			offset := y*dst.Stride + x*4
+			for i := range sum {
+				dst.Pix[offset+i] = uint8(sum[i] / n)
+			}
+		}
+	}
+	return dst
+}
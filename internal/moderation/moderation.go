@@ -0,0 +1,318 @@
+package moderation
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Action is what happens to text that matches a word list or rule.
+type Action string
+
+const (
+	// ActionMask replaces the match with asterisks.
+	ActionMask Action = "mask"
+	// ActionFlag keeps the text as it is but marks it for review.
+	ActionFlag Action = "flag"
+	// ActionReject refuses the text altogether.
+	ActionReject Action = "reject"
+)
+
+const mask = "****"
+
+// severity orders actions so a word listed with several actions gets the
+// strictest one.
+func (a Action) severity() int {
+	switch a {
+	case ActionMask:
+		return 1
+	case ActionFlag:
+		return 2
+	case ActionReject:
+		return 3
+	}
+	return 0
+}
+
+// Config is the moderation setup of a deployment, usually read from a JSON
+// file with LoadConfig.
+type Config struct {
+	WordLists []WordList `json:"word_lists"`
+	Rules     []Rule     `json:"rules"`
+}
+
+// WordList is a set of words that share an action. Words can be given
+// inline, read from a file with one word per line, or both. Lines starting
+// with # are comments.
+type WordList struct {
+	Name   string   `json:"name"`
+	Path   string   `json:"path"`
+	Words  []string `json:"words"`
+	Action Action   `json:"action"`
+}
+
+// Rule is a regular expression matched against the text as written.
+type Rule struct {
+	Name    string `json:"name"`
+	Pattern string `json:"pattern"`
+	Action  Action `json:"action"`
+}
+
+// DefaultConfig masks the words Chirpy has always masked.
+func DefaultConfig() Config {
+	return Config{
+		WordLists: []WordList{{
+			Name:   "profanity",
+			Words:  []string{"kerfuffle", "sharbert", "fornax"},
+			Action: ActionMask,
+		}},
+	}
+}
+
+// LoadConfig reads a JSON config from path. Word list paths are relative
+// to the directory of the config file.
+func LoadConfig(path string) (Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return Config{}, err
+	}
+	cfg := Config{}
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return Config{}, fmt.Errorf("parsing %s: %v", path, err)
+	}
+	for i, list := range cfg.WordLists {
+		if list.Path != "" && !filepath.IsAbs(list.Path) {
+			cfg.WordLists[i].Path = filepath.Join(filepath.Dir(path), list.Path)
+		}
+	}
+	return cfg, nil
+}
+
+type wordEntry struct {
+	list   string
+	action Action
+}
+
+type compiledRule struct {
+	name   string
+	re     *regexp.Regexp
+	action Action
+}
+
+// Moderator checks text against the word lists and rules of a Config.
+type Moderator struct {
+	words map[string]wordEntry
+	rules []compiledRule
+}
+
+func New(cfg Config) (*Moderator, error) {
+	m := &Moderator{words: make(map[string]wordEntry)}
+	for _, list := range cfg.WordLists {
+		if list.Action.severity() == 0 {
+			return nil, fmt.Errorf("word list %q: unknown action %q", list.Name, list.Action)
+		}
+		words := slices.Clone(list.Words)
+		if list.Path != "" {
+			fileWords, err := readWordList(list.Path)
+			if err != nil {
+				return nil, fmt.Errorf("word list %q: %v", list.Name, err)
+			}
+			words = append(words, fileWords...)
+		}
+		for _, word := range words {
+			key := normalize(word)
+			if key == "" {
+				continue
+			}
+			if old, ok := m.words[key]; ok && old.action.severity() >= list.Action.severity() {
+				continue
+			}
+			m.words[key] = wordEntry{list: list.Name, action: list.Action}
+		}
+	}
+	for _, rule := range cfg.Rules {
+		if rule.Action.severity() == 0 {
+			return nil, fmt.Errorf("rule %q: unknown action %q", rule.Name, rule.Action)
+		}
+		re, err := regexp.Compile(rule.Pattern)
+		if err != nil {
+			return nil, fmt.Errorf("rule %q: %v", rule.Name, err)
+		}
+		m.rules = append(m.rules, compiledRule{name: rule.Name, re: re, action: rule.Action})
+	}
+	return m, nil
+}
+
+func readWordList(path string) ([]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	words := []string{}
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		words = append(words, line)
+	}
+	return words, scanner.Err()
+}
+
+// Match is a part of the text that a word list or rule matched. Start and
+// End are byte offsets into the original text.
+type Match struct {
+	Start  int    `json:"start"`
+	End    int    `json:"end"`
+	Source string `json:"source"`
+	Action Action `json:"action"`
+}
+
+type Result struct {
+	// Text is the input with masked matches replaced; everything else,
+	// whitespace included, is left untouched.
+	Text     string
+	Rejected bool
+	Flagged  bool
+	Matches  []Match
+}
+
+func (m *Moderator) Moderate(text string) Result {
+	matches := m.matchWords(text)
+	for _, rule := range m.rules {
+		for _, loc := range rule.re.FindAllStringIndex(text, -1) {
+			if loc[0] == loc[1] {
+				continue
+			}
+			matches = append(matches, Match{Start: loc[0], End: loc[1], Source: rule.name, Action: rule.action})
+		}
+	}
+	slices.SortStableFunc(matches, func(a, b Match) int { return a.Start - b.Start })
+
+	result := Result{Matches: matches}
+	builder := strings.Builder{}
+	last := 0
+	for _, match := range matches {
+		switch match.Action {
+		case ActionReject:
+			result.Rejected = true
+		case ActionFlag:
+			result.Flagged = true
+		case ActionMask:
+			if match.End <= last {
+				continue
+			}
+			if match.Start >= last {
+				builder.WriteString(text[last:match.Start])
+				builder.WriteString(mask)
+			}
+			last = match.End
+		}
+	}
+	builder.WriteString(text[last:])
+	result.Text = builder.String()
+	return result
+}
+
+// matchWords looks up every word of text in the word lists. A word is a
+// run of non-space characters with surrounding punctuation trimmed; if the
+// whole word isn't listed, the parts between its inner punctuation are
+// tried one by one, so "fornax,kerfuffle" matches twice.
+func (m *Moderator) matchWords(text string) []Match {
+	matches := []Match{}
+	for _, span := range fields(text) {
+		start, end := trim(text, span[0], span[1])
+		if start == end {
+			continue
+		}
+		if match, ok := m.lookup(text, start, end); ok {
+			matches = append(matches, match)
+			continue
+		}
+		partStart := -1
+		for i, r := range text[start:end] {
+			i += start
+			if isWordRune(r) {
+				if partStart < 0 {
+					partStart = i
+				}
+				continue
+			}
+			if partStart >= 0 {
+				if match, ok := m.lookup(text, partStart, i); ok {
+					matches = append(matches, match)
+				}
+				partStart = -1
+			}
+		}
+		if partStart >= 0 && partStart != start {
+			if match, ok := m.lookup(text, partStart, end); ok {
+				matches = append(matches, match)
+			}
+		}
+	}
+	return matches
+}
+
+func (m *Moderator) lookup(text string, start int, end int) (Match, bool) {
+	entry, ok := m.words[normalize(text[start:end])]
+	if !ok {
+		return Match{}, false
+	}
+	return Match{Start: start, End: end, Source: entry.list, Action: entry.action}, true
+}
+
+// fields returns the byte spans of the runs of non-space characters in
+// text.
+func fields(text string) [][2]int {
+	spans := [][2]int{}
+	start := -1
+	for i, r := range text {
+		if unicode.IsSpace(r) {
+			if start >= 0 {
+				spans = append(spans, [2]int{start, i})
+				start = -1
+			}
+		} else if start < 0 {
+			start = i
+		}
+	}
+	if start >= 0 {
+		spans = append(spans, [2]int{start, len(text)})
+	}
+	return spans
+}
+
+// trim narrows text[start:end] to begin and end with a word character.
+func trim(text string, start int, end int) (int, int) {
+	for start < end {
+		r, size := utf8.DecodeRuneInString(text[start:end])
+		if isWordRune(r) {
+			break
+		}
+		start += size
+	}
+	for end > start {
+		r, size := utf8.DecodeLastRuneInString(text[start:end])
+		if isWordRune(r) {
+			break
+		}
+		end -= size
+	}
+	return start, end
+}
+
+func isWordRune(r rune) bool {
+	_, leet := leetspeak[r]
+	return leet || unicode.IsLetter(r) || unicode.IsDigit(r) || unicode.Is(unicode.Mn, r)
+}
@@ -0,0 +1,95 @@
+package moderation
+
+import (
+	"strings"
+	"unicode"
+)
+
+// leetspeak maps characters commonly used in place of letters.
+var leetspeak = map[rune]rune{
+	'0': 'o',
+	'1': 'i',
+	'3': 'e',
+	'4': 'a',
+	'5': 's',
+	'7': 't',
+	'8': 'b',
+	'@': 'a',
+	'$': 's',
+	'+': 't',
+}
+
+// confusables maps Cyrillic and Greek letters to the Latin letters they are
+// hard to tell apart from. Uppercase and lowercase forms are listed
+// separately since they don't always look alike.
+var confusables = map[rune]rune{
+	'а': 'a', 'в': 'b', 'е': 'e', 'ё': 'e', 'к': 'k', 'м': 'm', 'о': 'o',
+	'р': 'p', 'с': 'c', 'у': 'y', 'х': 'x', 'ѕ': 's', 'і': 'i', 'ї': 'i',
+	'ј': 'j', 'ԁ': 'd', 'ԛ': 'q', 'ԝ': 'w', 'һ': 'h',
+	'А': 'a', 'В': 'b', 'Е': 'e', 'К': 'k', 'М': 'm', 'Н': 'h', 'О': 'o',
+	'Р': 'p', 'С': 'c', 'Т': 't', 'У': 'y', 'Х': 'x', 'Ѕ': 's', 'І': 'i',
+	'Ј': 'j',
+	'α': 'a', 'β': 'b', 'ε': 'e', 'η': 'n', 'ι': 'i', 'κ': 'k', 'ν': 'v',
+	'ο': 'o', 'ρ': 'p', 'τ': 't', 'υ': 'u', 'χ': 'x', 'ω': 'w',
+	'Α': 'a', 'Β': 'b', 'Ε': 'e', 'Ζ': 'z', 'Η': 'h', 'Ι': 'i', 'Κ': 'k',
+	'Μ': 'm', 'Ν': 'n', 'Ο': 'o', 'Ρ': 'p', 'Τ': 't', 'Υ': 'y', 'Χ': 'x',
+}
+
+// accents maps precomposed Latin letters to the letter without diacritics.
+var accents = map[rune]rune{}
+
+func init() {
+	for base, letters := range map[rune]string{
+		'a': "àáâãäåāăą",
+		'c': "çćĉċč",
+		'd': "ďđ",
+		'e': "èéêëēĕėęě",
+		'g': "ĝğġģ",
+		'h': "ĥħ",
+		'i': "ìíîïĩīĭįı",
+		'j': "ĵ",
+		'k': "ķ",
+		'l': "ĺļľŀł",
+		'n': "ñńņňŉ",
+		'o': "òóôõöøōŏő",
+		'r': "ŕŗř",
+		's': "śŝşšș",
+		't': "ţťŧț",
+		'u': "ùúûüũūŭůűų",
+		'w': "ŵ",
+		'y': "ýÿŷ",
+		'z': "źżž",
+	} {
+		for _, r := range letters {
+			accents[r] = base
+		}
+	}
+}
+
+// normalize reduces word to the form word lists are compared in: fullwidth
+// forms, confusables, accents and leetspeak are mapped to plain lowercase
+// Latin letters, and everything that is not a letter or digit afterwards,
+// such as punctuation inside the word, combining marks and zero-width
+// characters, is dropped.
+func normalize(word string) string {
+	builder := strings.Builder{}
+	for _, r := range word {
+		if r >= 0xFF01 && r <= 0xFF5E {
+			r -= 0xFEE0
+		}
+		if c, ok := confusables[r]; ok {
+			r = c
+		}
+		r = unicode.ToLower(r)
+		if a, ok := accents[r]; ok {
+			r = a
+		}
+		if l, ok := leetspeak[r]; ok {
+			r = l
+		}
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			builder.WriteRune(r)
+		}
+	}
+	return builder.String()
+}
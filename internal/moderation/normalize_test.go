@@ -0,0 +1,23 @@
+package moderation
+
+import "testing"
+
+func TestNormalize(t *testing.T) {
+	tests := []struct {
+		word string
+		want string
+	}{
+		{"hello", "hello"},
+		{"h3ll0", "hello"},
+		{"ＨＥＬＬＯ", "hello"},
+		{"hеllо", "hello"}, // Cyrillic е and о
+		{"héllö", "hello"},
+		{"h.e-l_l'o", "hello"},
+		{"l1", "li"},
+	}
+	for _, tt := range tests {
+		if got := normalize(tt.word); got != tt.want {
+			t.Errorf("normalize(%q) = %q, want %q", tt.word, got, tt.want)
+		}
+	}
+}
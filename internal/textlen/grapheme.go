@@ -0,0 +1,97 @@
+package textlen
+
+import "unicode"
+
+const (
+	zwj = '\u200d'
+	cr  = '\r'
+	lf  = '\n'
+)
+
+// Graphemes counts the user-perceived characters in text. It follows the
+// parts of the Unicode extended grapheme cluster rules that matter for
+// chirps: combining marks, variation selectors, emoji modifiers and tags,
+// ZWJ sequences, regional indicator pairs, Hangul jamo and CR LF all count
+// as one character together with what they attach to.
+func Graphemes(text string) int {
+	count := 0
+	var prev rune
+	riRun := 0
+	for i, r := range text {
+		if i > 0 && !breaksBetween(prev, r, riRun) {
+			if isRegionalIndicator(r) {
+				riRun++
+			}
+			prev = r
+			continue
+		}
+		count++
+		riRun = 0
+		if isRegionalIndicator(r) {
+			riRun = 1
+		}
+		prev = r
+	}
+	return count
+}
+
+// breaksBetween reports whether a new character starts at r, given the rune
+// before it and how many regional indicators the current character holds.
+func breaksBetween(prev rune, r rune, riRun int) bool {
+	switch {
+	case prev == cr && r == lf:
+		return false
+	case prev == cr || prev == lf || r == cr || r == lf:
+		return true
+	case isExtend(r) || r == zwj:
+		return false
+	case prev == zwj && isPictographic(r):
+		return false
+	case isRegionalIndicator(prev) && isRegionalIndicator(r):
+		return riRun%2 == 0
+	case isHangulL(prev) && (isHangulL(r) || isHangulV(r)):
+		return false
+	case isHangulV(prev) && (isHangulV(r) || isHangulT(r)):
+		return false
+	case isHangulT(prev) && isHangulT(r):
+		return false
+	case isHangulSyllable(prev) && (isHangulV(r) || isHangulT(r)):
+		return false
+	}
+	return true
+}
+
+func isExtend(r rune) bool {
+	return unicode.In(r, unicode.Mn, unicode.Me, unicode.Mc) ||
+		r >= 0xFE00 && r <= 0xFE0F ||
+		r >= 0xE0100 && r <= 0xE01EF ||
+		r >= 0x1F3FB && r <= 0x1F3FF ||
+		r >= 0xE0020 && r <= 0xE007F
+}
+
+func isPictographic(r rune) bool {
+	return r >= 0x1F000 && r <= 0x1FAFF ||
+		r >= 0x2600 && r <= 0x27BF ||
+		r == 0x2764 || r == 0x2B50 || r == 0x2B55 ||
+		r == 0x00A9 || r == 0x00AE
+}
+
+func isRegionalIndicator(r rune) bool {
+	return r >= 0x1F1E6 && r <= 0x1F1FF
+}
+
+func isHangulL(r rune) bool {
+	return r >= 0x1100 && r <= 0x115F || r >= 0xA960 && r <= 0xA97C
+}
+
+func isHangulV(r rune) bool {
+	return r >= 0x1160 && r <= 0x11A7 || r >= 0xD7B0 && r <= 0xD7C6
+}
+
+func isHangulT(r rune) bool {
+	return r >= 0x11A8 && r <= 0x11FF || r >= 0xD7CB && r <= 0xD7FB
+}
+
+func isHangulSyllable(r rune) bool {
+	return r >= 0xAC00 && r <= 0xD7A3
+}
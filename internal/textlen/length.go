@@ -0,0 +1,27 @@
+package textlen
+
+import (
+	"regexp"
+	"strings"
+)
+
+// urlRegex matches http and https links up to the next whitespace.
+var urlRegex = regexp.MustCompile(`https?://\S+`)
+
+// Weighted returns the length of text as it counts against a chirp limit:
+// user-perceived characters, with every link counting as urlLength no
+// matter how long it is. Punctuation right after a link, like the period
+// ending a sentence, is not considered part of it.
+func Weighted(text string, urlLength int) int {
+	length := 0
+	last := 0
+	for _, loc := range urlRegex.FindAllStringIndex(text, -1) {
+		url := strings.TrimRight(text[loc[0]:loc[1]], `.,;:!?)]}'"`)
+		if !strings.Contains(url, "://") || strings.HasSuffix(url, "://") {
+			continue
+		}
+		length += Graphemes(text[last:loc[0]]) + urlLength
+		last = loc[0] + len(url)
+	}
+	return length + Graphemes(text[last:])
+}
@@ -0,0 +1,31 @@
+package textlen
+
+import "testing"
+
+func TestGraphemes(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		text string
+		want int
+	}{
+		{"empty", "", 0},
+		{"ascii", "hello", 5},
+		{"precomposed accent", "caf\u00e9", 4},
+		{"combining mark", "cafe\u0301", 4},
+		{"CR LF", "a\r\nb", 3},
+		{"lone CR and LF", "a\r\n\nb", 4},
+		{"variation selector", "\u2764\ufe0f", 1},
+		{"skin tone modifier", "\U0001F44D\U0001F3FD", 1},
+		{"ZWJ family", "\U0001F468\u200d\U0001F469\u200d\U0001F467", 1},
+		{"flag", "\U0001F1F0\U0001F1FF", 1},
+		{"two flags", "\U0001F1F0\U0001F1FF\U0001F1FA\U0001F1F8", 2},
+		{"odd regional indicator", "\U0001F1F0\U0001F1FF\U0001F1FA", 2},
+		{"tag sequence flag", "\U0001F3F4\U000E0067\U000E0062\U000E0073\U000E0063\U000E0074\U000E007F", 1},
+		{"Hangul syllables", "\ud55c\uae00", 2},
+		{"Hangul jamo", "\u1112\u1161\u11ab", 1},
+	} {
+		if got := Graphemes(tc.text); got != tc.want {
+			t.Errorf("%s: Graphemes(%q) = %d, want %d", tc.name, tc.text, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,75 @@
+package totp
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// rfcSecret is the SHA-1 seed from RFC 6238 appendix B, base32 encoded.
+const rfcSecret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+func TestCodeMatchesRFC6238(t *testing.T) {
+	// The RFC lists eight digits; authenticator apps show the last six.
+	for unix, want := range map[int64]string{
+		59:          "287082",
+		1111111109:  "081804",
+		1111111111:  "050471",
+		1234567890:  "005924",
+		2000000000:  "279037",
+		20000000000: "353130",
+	} {
+		got, err := Code(rfcSecret, Step(time.Unix(unix, 0)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != want {
+			t.Errorf("code at %d = %s, want %s", unix, got, want)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	now := time.Unix(1111111111, 0)
+	step := Step(now)
+	code := func(step int64) string {
+		c, err := Code(rfcSecret, step)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return c
+	}
+
+	for _, offset := range []int64{-skew, 0, skew} {
+		matched, ok := Validate(rfcSecret, code(step+offset), now, 0)
+		if !ok || matched != step+offset {
+			t.Errorf("code %d steps off: Validate = %d, %v", offset, matched, ok)
+		}
+	}
+	if _, ok := Validate(rfcSecret, code(step+skew+1), now, 0); ok {
+		t.Error("accepted a code from outside the allowed skew")
+	}
+	if _, ok := Validate(rfcSecret, code(step), now, step); ok {
+		t.Error("accepted a code from a step that was already used")
+	}
+	if _, ok := Validate(rfcSecret, code(step)[:3]+" "+code(step)[3:], now, 0); !ok {
+		t.Error("refused a code typed with a space")
+	}
+	if _, ok := Validate(rfcSecret, "12345", now, 0); ok {
+		t.Error("accepted a code that is too short")
+	}
+}
+
+func TestGenerateSecretRoundTrips(t *testing.T) {
+	secret, err := GenerateSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Code(strings.ToLower(secret), 1); err != nil {
+		t.Fatalf("generated secret %q doesn't decode: %v", secret, err)
+	}
+	uri := ProvisioningURI("Chirpy", "a@example.com", secret)
+	if !strings.HasPrefix(uri, "otpauth://totp/Chirpy:a@example.com?") || !strings.Contains(uri, "secret="+secret) {
+		t.Fatalf("provisioning URI %q", uri)
+	}
+}
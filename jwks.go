@@ -0,0 +1,49 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"time"
+)
+
+// keyRotationCheckInterval is how often the signing key's age is checked
+// and rotated-out keys are pruned.
+const keyRotationCheckInterval = time.Minute
+
+func (cfg *apiConfig) jwksHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	respondWithJSON(w, http.StatusOK, cfg.jwtKeys.JWKS())
+}
+
+// runKeyRotation replaces the signing key once it is older than rotation
+// and drops rotated-out keys once the tokens they signed have expired,
+// until ctx is done.
+func (cfg *apiConfig) runKeyRotation(ctx context.Context, rotation time.Duration) {
+	ticker := time.NewTicker(min(rotation, keyRotationCheckInterval))
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+
+		if time.Since(cfg.jwtKeys.Active().Created) >= rotation {
+			key, err := cfg.jwtKeys.Rotate(cfg.jwtAlgorithm)
+			if err != nil {
+				log.Printf("error rotating JWT signing key: %v", err)
+			} else {
+				log.Printf("rotated JWT signing key, now signing with %s", key.ID)
+			}
+		}
+		pruned, err := cfg.jwtKeys.Prune(time.Now())
+		if err != nil {
+			log.Printf("error pruning JWT keys: %v", err)
+		}
+		for _, kid := range pruned {
+			log.Printf("removed expired JWT key %s", kid)
+		}
+	}
+}
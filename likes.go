@@ -0,0 +1,144 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/railanbaigazy/chirpy/internal/database"
+)
+
+func (cfg *apiConfig) likeChirpHandler(w http.ResponseWriter, r *http.Request) {
+	cfg.setLike(w, r, true)
+}
+
+func (cfg *apiConfig) unlikeChirpHandler(w http.ResponseWriter, r *http.Request) {
+	cfg.setLike(w, r, false)
+}
+
+func (cfg *apiConfig) setLike(w http.ResponseWriter, r *http.Request, liked bool) {
+	w.Header().Set("Content-Type", "application/json")
+
+	tokenStr, err := getTokenString(r)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, err.Error())
+		return
+	}
+
+	userID, err := getUserIDByToken(cfg, tokenStr)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, err.Error())
+		return
+	}
+
+	chirpID, err := strconv.Atoi(r.PathValue("chirpid"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "invalid id")
+		return
+	}
+
+	var chirp database.Chirp
+	if liked {
+		chirp, err = cfg.db.LikeChirp(chirpID, userID)
+	} else {
+		chirp, err = cfg.db.UnlikeChirp(chirpID, userID)
+	}
+	if errors.Is(err, database.ErrChirpNotFound) {
+		respondWithError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	chirp.LikedByMe = &liked
+	respondWithJSON(w, http.StatusOK, chirp)
+}
+
+func (cfg *apiConfig) getUserLikesHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	userID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil || userID <= 0 {
+		respondWithError(w, http.StatusBadRequest, "invalid id")
+		return
+	}
+
+	viewerID, err := getViewerID(cfg, r)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, err.Error())
+		return
+	}
+
+	query, err := parseChirpQuery(r)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	query.LikedByUserID = userID
+
+	page, err := cfg.db.GetChirps(query)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if err = cfg.markLikedByMe(viewerID, page.Chirps); err != nil {
+		respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	respondWithChirpPage(w, page)
+}
+
+// markLikedByMe sets LikedByMe on each chirp for the given viewer. Anonymous
+// viewers, with an ID of zero, get no flag at all.
+func (cfg *apiConfig) markLikedByMe(viewerID int, chirps []database.Chirp) error {
+	if viewerID == 0 || len(chirps) == 0 {
+		return nil
+	}
+
+	ids := make([]int, len(chirps))
+	for i, chirp := range chirps {
+		ids[i] = chirp.ID
+	}
+	liked, err := cfg.db.LikedChirps(viewerID, ids)
+	if err != nil {
+		return err
+	}
+	for i := range chirps {
+		isLiked := liked[chirps[i].ID]
+		chirps[i].LikedByMe = &isLiked
+	}
+	return nil
+}
+
+// markThreadLikedByMe sets LikedByMe on every chirp in thread, like
+// markLikedByMe.
+func (cfg *apiConfig) markThreadLikedByMe(viewerID int, thread *database.Thread) error {
+	if viewerID == 0 {
+		return nil
+	}
+
+	nodes := []*database.Thread{}
+	var walk func(node *database.Thread)
+	walk = func(node *database.Thread) {
+		nodes = append(nodes, node)
+		for i := range node.Replies {
+			walk(&node.Replies[i])
+		}
+	}
+	walk(thread)
+
+	chirps := make([]database.Chirp, len(nodes))
+	for i, node := range nodes {
+		chirps[i] = node.Chirp
+	}
+	if err := cfg.markLikedByMe(viewerID, chirps); err != nil {
+		return err
+	}
+	for i, node := range nodes {
+		node.Chirp = chirps[i]
+	}
+	return nil
+}
@@ -0,0 +1,31 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/railanbaigazy/chirpy/internal/database"
+)
+
+// chirpLimits are the rules chirps are checked against. Lengths count
+// user-perceived characters, with each link counting as URLLength.
+type chirpLimits struct {
+	MaxLength          int `json:"max_length"`
+	ChirpyRedMaxLength int `json:"chirpy_red_max_length"`
+	URLLength          int `json:"url_length"`
+	MaxMedia           int `json:"max_media"`
+	MaxMediaBytes      int `json:"max_media_bytes"`
+}
+
+// forUser returns the length limit for user, which depends on whether they
+// are a Chirpy Red member.
+func (l chirpLimits) forUser(user database.UserResp) int {
+	if user.IsChirpyRed {
+		return max(l.MaxLength, l.ChirpyRedMaxLength)
+	}
+	return l.MaxLength
+}
+
+func (cfg *apiConfig) limitsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	respondWithJSON(w, http.StatusOK, cfg.chirpLimits)
+}
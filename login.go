@@ -4,11 +4,14 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
+
+	"github.com/railanbaigazy/chirpy/internal/database"
 )
 
 type loginRequest struct {
 	Email    string `json:"email"`
 	Password string `json:"password"`
+	Device   string `json:"device"`
 }
 
 func (cfg *apiConfig) loginHandler(w http.ResponseWriter, r *http.Request) {
@@ -21,11 +24,21 @@ func (cfg *apiConfig) loginHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	user, err := cfg.db.Login(loginReq.Email, loginReq.Password, []byte(cfg.jwtSecret))
+	device := loginReq.Device
+	if device == "" {
+		device = r.UserAgent()
+	}
+	client := database.SessionInfo{Device: device, IP: clientIP(r)}
+
+	user, challenge, err := cfg.db.Login(loginReq.Email, loginReq.Password, cfg.jwtKeys, client)
 	if err != nil {
 		respondWithError(w, 401, fmt.Sprint(err))
 		return
 	}
+	if challenge != nil {
+		respondWithJSON(w, http.StatusOK, challenge)
+		return
+	}
 
 	respondWithJSON(w, http.StatusOK, user)
 }
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/railanbaigazy/chirpy/internal/database"
+	"github.com/railanbaigazy/chirpy/internal/media"
+)
+
+const mediaURLPrefix = "/media/"
+
+func (cfg *apiConfig) uploadMediaHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	tokenStr, err := getTokenString(r)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, err.Error())
+		return
+	}
+
+	userID, err := getUserIDByToken(cfg, tokenStr)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, err.Error())
+		return
+	}
+
+	maxBytes := int64(cfg.chirpLimits.MaxMediaBytes)
+	// Leave some room for the multipart framing around the file itself.
+	r.Body = http.MaxBytesReader(w, r.Body, maxBytes+64<<10)
+	file, _, err := r.FormFile("file")
+	var maxBytesErr *http.MaxBytesError
+	if errors.As(err, &maxBytesErr) {
+		respondWithError(w, http.StatusRequestEntityTooLarge, fmt.Sprintf("file must be at most %d bytes", maxBytes))
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "request must be multipart with a file field")
+		return
+	}
+	defer file.Close()
+
+	data, err := io.ReadAll(io.LimitReader(file, maxBytes+1))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if int64(len(data)) > maxBytes {
+		respondWithError(w, http.StatusRequestEntityTooLarge, fmt.Sprintf("file must be at most %d bytes", maxBytes))
+		return
+	}
+
+	img, err := cfg.media.Save(data)
+	if errors.Is(err, media.ErrUnsupportedType) {
+		respondWithError(w, http.StatusUnsupportedMediaType, err.Error())
+		return
+	}
+	if errors.Is(err, media.ErrInvalidImage) {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	stored, err := cfg.db.CreateMedia(database.Media{
+		OwnerID:      userID,
+		Hash:         img.Hash,
+		ContentType:  img.ContentType,
+		Size:         img.Size,
+		Width:        img.Width,
+		Height:       img.Height,
+		URL:          path.Join(mediaURLPrefix, filepath.ToSlash(img.Name)),
+		ThumbnailURL: path.Join(mediaURLPrefix, filepath.ToSlash(img.ThumbnailName)),
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	respondWithJSON(w, http.StatusCreated, stored)
+}
+
+func (cfg *apiConfig) getMediaHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "invalid id")
+		return
+	}
+
+	stored, err := cfg.db.GetMedia(id)
+	if errors.Is(err, database.ErrMediaNotFound) {
+		respondWithError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	respondWithJSON(w, http.StatusOK, stored)
+}
+
+// mediaFileHandler serves stored media files. Their names are derived from
+// their contents, so they can be cached forever; directory listings are not
+// served, to keep other users' uploads from being enumerated.
+func (cfg *apiConfig) mediaFileHandler() http.Handler {
+	fileServer := http.StripPrefix(mediaURLPrefix, http.FileServer(http.Dir(cfg.media.Dir())))
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, "/") {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+		fileServer.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/railanbaigazy/chirpy/internal/database"
+)
+
+type totpCodeRequest struct {
+	Code string `json:"code"`
+}
+
+type loginMFARequest struct {
+	MFAToken string `json:"mfa_token"`
+	Code     string `json:"code"`
+	Device   string `json:"device"`
+}
+
+type recoveryCodesResp struct {
+	RecoveryCodes []string `json:"recovery_codes"`
+}
+
+func (cfg *apiConfig) beginTOTPHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	tokenStr, err := getTokenString(r)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, err.Error())
+		return
+	}
+
+	userID, err := getUserIDByToken(cfg, tokenStr)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, err.Error())
+		return
+	}
+
+	enrollment, err := cfg.db.BeginTOTPEnrollment(userID)
+	if errors.Is(err, database.ErrTOTPAlreadyEnabled) {
+		respondWithError(w, http.StatusConflict, err.Error())
+		return
+	}
+	if errors.Is(err, database.ErrUserNotFound) {
+		respondWithError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	respondWithJSON(w, http.StatusCreated, enrollment)
+}
+
+func (cfg *apiConfig) confirmTOTPHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	tokenStr, err := getTokenString(r)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, err.Error())
+		return
+	}
+
+	userID, err := getUserIDByToken(cfg, tokenStr)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, err.Error())
+		return
+	}
+
+	req := totpCodeRequest{}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	codes, err := cfg.db.ConfirmTOTP(userID, req.Code)
+	switch {
+	case errors.Is(err, database.ErrTOTPAlreadyEnabled):
+		respondWithError(w, http.StatusConflict, err.Error())
+	case errors.Is(err, database.ErrTOTPNotEnrolled):
+		respondWithError(w, http.StatusNotFound, err.Error())
+	case errors.Is(err, database.ErrInvalidMFACode):
+		respondWithError(w, http.StatusBadRequest, err.Error())
+	case err != nil:
+		respondWithError(w, http.StatusInternalServerError, err.Error())
+	default:
+		respondWithJSON(w, http.StatusOK, recoveryCodesResp{RecoveryCodes: codes})
+	}
+}
+
+func (cfg *apiConfig) disableTOTPHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	tokenStr, err := getTokenString(r)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, err.Error())
+		return
+	}
+
+	userID, err := getUserIDByToken(cfg, tokenStr)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, err.Error())
+		return
+	}
+
+	req := totpCodeRequest{}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	err = cfg.db.DisableTOTP(userID, req.Code)
+	switch {
+	case errors.Is(err, database.ErrTOTPNotEnabled):
+		respondWithError(w, http.StatusNotFound, err.Error())
+	case errors.Is(err, database.ErrInvalidMFACode):
+		respondWithError(w, http.StatusBadRequest, err.Error())
+	case errors.Is(err, database.ErrMFALocked):
+		respondWithError(w, http.StatusTooManyRequests, err.Error())
+	case err != nil:
+		respondWithError(w, http.StatusInternalServerError, err.Error())
+	default:
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// loginMFAHandler completes a login that Login answered with an MFA
+// challenge, using a code from the user's authenticator app or a recovery
+// code.
+func (cfg *apiConfig) loginMFAHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	req := loginMFARequest{}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	device := req.Device
+	if device == "" {
+		device = r.UserAgent()
+	}
+	client := database.SessionInfo{Device: device, IP: clientIP(r)}
+
+	user, err := cfg.db.CompleteMFALogin(req.MFAToken, req.Code, cfg.jwtKeys, client)
+	if errors.Is(err, database.ErrMFAChallengeNotFound) || errors.Is(err, database.ErrInvalidMFACode) {
+		respondWithError(w, http.StatusUnauthorized, err.Error())
+		return
+	}
+	if errors.Is(err, database.ErrMFALocked) {
+		respondWithError(w, http.StatusTooManyRequests, err.Error())
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	respondWithJSON(w, http.StatusOK, user)
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/railanbaigazy/chirpy/internal/database"
+)
+
+const (
+	defaultPageLimit = 50
+	maxPageLimit     = 1000
+)
+
+// parseChirpQuery reads the paging and filtering parameters shared by every
+// endpoint that lists chirps. Pages hold defaultPageLimit chirps unless the
+// limit parameter asks for another size.
+func parseChirpQuery(r *http.Request) (database.ChirpQuery, error) {
+	params := r.URL.Query()
+	query := database.ChirpQuery{Cursor: params.Get("cursor")}
+
+	switch params.Get("sort") {
+	case "", "asc":
+	case "desc":
+		query.Descending = true
+	default:
+		return database.ChirpQuery{}, errors.New("invalid sort")
+	}
+
+	var err error
+	if query.AuthorID, err = intParam(params, "author_id"); err != nil {
+		return database.ChirpQuery{}, err
+	}
+	if query.SinceID, err = intParam(params, "since_id"); err != nil {
+		return database.ChirpQuery{}, err
+	}
+	if query.MaxID, err = intParam(params, "max_id"); err != nil {
+		return database.ChirpQuery{}, err
+	}
+	if query.Limit, err = intParam(params, "limit"); err != nil {
+		return database.ChirpQuery{}, err
+	}
+	if query.Limit == 0 {
+		query.Limit = defaultPageLimit
+	}
+	if query.Limit > maxPageLimit {
+		return database.ChirpQuery{}, fmt.Errorf("limit must be at most %d", maxPageLimit)
+	}
+	if query.CreatedAfter, err = timeParam(params, "created_after"); err != nil {
+		return database.ChirpQuery{}, err
+	}
+	if query.CreatedBefore, err = timeParam(params, "created_before"); err != nil {
+		return database.ChirpQuery{}, err
+	}
+	return query, nil
+}
+
+func intParam(params url.Values, name string) (int, error) {
+	value := params.Get(name)
+	if value == "" {
+		return 0, nil
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid %s", name)
+	}
+	return n, nil
+}
+
+func timeParam(params url.Values, name string) (time.Time, error) {
+	value := params.Get(name)
+	if value == "" {
+		return time.Time{}, nil
+	}
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid %s", name)
+	}
+	return t, nil
+}
+
+// respondWithChirpPage writes a page of chirps as
+// {"chirps": [...], "next_cursor": "..."}. next_cursor is left out on the
+// last page; otherwise passing it as the cursor parameter fetches the next one.
+func respondWithChirpPage(w http.ResponseWriter, page database.ChirpPage) {
+	if page.Chirps == nil {
+		page.Chirps = []database.Chirp{}
+	}
+	respondWithJSON(w, http.StatusOK, page)
+}
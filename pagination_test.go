@@ -0,0 +1,28 @@
+package main
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestParseChirpQueryLimit(t *testing.T) {
+	for target, want := range map[string]int{
+		"/api/chirps":          defaultPageLimit,
+		"/api/chirps?limit=0":  defaultPageLimit,
+		"/api/chirps?limit=10": 10,
+	} {
+		query, err := parseChirpQuery(httptest.NewRequest("GET", target, nil))
+		if err != nil {
+			t.Fatalf("%s: %v", target, err)
+		}
+		if query.Limit != want {
+			t.Errorf("%s: limit %d, want %d", target, query.Limit, want)
+		}
+	}
+
+	for _, target := range []string{"/api/chirps?limit=-1", "/api/chirps?limit=1001"} {
+		if _, err := parseChirpQuery(httptest.NewRequest("GET", target, nil)); err == nil {
+			t.Errorf("%s: accepted", target)
+		}
+	}
+}
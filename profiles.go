@@ -0,0 +1,108 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/railanbaigazy/chirpy/internal/database"
+)
+
+type profileRequest struct {
+	Handle      *string `json:"handle"`
+	DisplayName *string `json:"display_name"`
+	Bio         *string `json:"bio"`
+	AvatarURL   *string `json:"avatar_url"`
+}
+
+func (cfg *apiConfig) getUserHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	userID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil || userID <= 0 {
+		respondWithError(w, http.StatusBadRequest, "invalid id")
+		return
+	}
+	user, err := cfg.db.GetUserByID(userID)
+	respondWithUser(w, user, err)
+}
+
+func (cfg *apiConfig) getUserByHandleHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	user, err := cfg.db.GetUserByHandle(r.PathValue("handle"))
+	respondWithUser(w, user, err)
+}
+
+func respondWithUser(w http.ResponseWriter, user database.UserResp, err error) {
+	if errors.Is(err, database.ErrUserNotFound) {
+		respondWithError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	respondWithJSON(w, http.StatusOK, user)
+}
+
+// getUserListHandler serves the lists hanging off a user. They share one
+// route because ServeMux rejects /api/users/{id}/likes next to
+// /api/users/by-handle/{handle} as ambiguous.
+func (cfg *apiConfig) getUserListHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.PathValue("list") {
+	case "mentions":
+		cfg.getUserMentionsHandler(w, r)
+	case "likes":
+		cfg.getUserLikesHandler(w, r)
+	case "followers":
+		cfg.getFollowersHandler(w, r)
+	case "following":
+		cfg.getFollowingHandler(w, r)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (cfg *apiConfig) updateProfileHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	tokenStr, err := getTokenString(r)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, err.Error())
+		return
+	}
+
+	userID, err := getUserIDByToken(cfg, tokenStr)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, err.Error())
+		return
+	}
+
+	profileReq := profileRequest{}
+	err = json.NewDecoder(r.Body).Decode(&profileReq)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+
+	user, err := cfg.db.UpdateProfile(userID, database.ProfileUpdate{
+		Handle:      profileReq.Handle,
+		DisplayName: profileReq.DisplayName,
+		Bio:         profileReq.Bio,
+		AvatarURL:   profileReq.AvatarURL,
+	})
+	if errors.Is(err, database.ErrUserNotFound) {
+		respondWithError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	if errors.Is(err, database.ErrHandleTaken) {
+		respondWithError(w, http.StatusConflict, err.Error())
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	respondWithJSON(w, http.StatusOK, user)
+}
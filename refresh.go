@@ -11,7 +11,7 @@ func (cfg *apiConfig) refreshAccessTokenHandler(w http.ResponseWriter, r *http.R
 		return
 	}
 
-	refreshResp, err := cfg.db.RefreshAccessToken(tokenStr, []byte(cfg.jwtSecret))
+	refreshResp, err := cfg.db.RefreshAccessToken(tokenStr, cfg.jwtKeys, clientIP(r))
 	if err != nil {
 		respondWithError(w, 401, err.Error())
 		return
@@ -1,10 +1,14 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
 	"os"
+	"os/signal"
+	"syscall"
 
 	"github.com/joho/godotenv"
 )
@@ -29,20 +33,61 @@ func runServer() error {
 	mux.Handle("/app/*", fileserverHandler)
 
 	mux.HandleFunc("GET /api/healthz", readinessHandler)
+	mux.HandleFunc("GET /.well-known/jwks.json", apiCfg.jwksHandler)
 	mux.HandleFunc("GET /admin/metrics", apiCfg.metricsHandler)
 	mux.HandleFunc("GET /api/reset", apiCfg.resetMetricsHandler)
+	mux.HandleFunc("GET /admin/chirps/flagged", apiCfg.getFlaggedChirpsHandler)
 
+	mux.HandleFunc("GET /api/limits", apiCfg.limitsHandler)
 	mux.HandleFunc("POST /api/chirps", apiCfg.createChirpHandler)
 	mux.HandleFunc("GET /api/chirps", apiCfg.getChirpsHandler)
+	mux.HandleFunc("GET /api/chirps/search", apiCfg.searchChirpsHandler)
 	mux.HandleFunc("GET /api/chirps/{chirpid}", apiCfg.getChirpByIDHandler)
 	mux.HandleFunc("DELETE /api/chirps/{chirpid}", apiCfg.deleteChirpHandler)
+	mux.HandleFunc("PUT /api/chirps/{chirpid}", apiCfg.updateChirpHandler)
+	mux.HandleFunc("GET /api/chirps/{chirpid}/history", apiCfg.getChirpHistoryHandler)
+	mux.HandleFunc("GET /api/chirps/{chirpid}/thread", apiCfg.getChirpThreadHandler)
+	mux.HandleFunc("POST /api/chirps/{chirpid}/likes", apiCfg.likeChirpHandler)
+	mux.HandleFunc("DELETE /api/chirps/{chirpid}/likes", apiCfg.unlikeChirpHandler)
+	mux.HandleFunc("POST /api/chirps/{chirpid}/bookmark", apiCfg.bookmarkChirpHandler)
+	mux.HandleFunc("DELETE /api/chirps/{chirpid}/bookmark", apiCfg.unbookmarkChirpHandler)
+	mux.HandleFunc("GET /api/bookmarks", apiCfg.getBookmarksHandler)
+
+	mux.HandleFunc("POST /api/media", apiCfg.uploadMediaHandler)
+	mux.HandleFunc("GET /api/media/{id}", apiCfg.getMediaHandler)
+	mux.Handle("GET "+mediaURLPrefix, apiCfg.mediaFileHandler())
+
+	mux.HandleFunc("GET /api/drafts", apiCfg.getDraftsHandler)
+	mux.HandleFunc("POST /api/drafts", apiCfg.createDraftHandler)
+	mux.HandleFunc("DELETE /api/drafts/{draftid}", apiCfg.deleteDraftHandler)
+	mux.HandleFunc("POST /api/drafts/{draftid}/publish", apiCfg.publishDraftHandler)
+
+	mux.HandleFunc("GET /api/hashtags/{tag}/chirps", apiCfg.getHashtagChirpsHandler)
+	mux.HandleFunc("GET /api/users/{id}", apiCfg.getUserHandler)
+	mux.HandleFunc("GET /api/users/by-handle/{handle}", apiCfg.getUserByHandleHandler)
+	mux.HandleFunc("GET /api/users/{id}/{list}", apiCfg.getUserListHandler)
+	mux.HandleFunc("POST /api/users/{id}/follow", apiCfg.followUserHandler)
+	mux.HandleFunc("DELETE /api/users/{id}/follow", apiCfg.unfollowUserHandler)
+	mux.HandleFunc("GET /api/timeline", apiCfg.timelineHandler)
 
 	mux.HandleFunc("POST /api/users", apiCfg.createUserHandler)
 	mux.HandleFunc("POST /api/login", apiCfg.loginHandler)
+	mux.HandleFunc("POST /api/login/mfa", apiCfg.loginMFAHandler)
+	mux.HandleFunc("POST /api/mfa/totp", apiCfg.beginTOTPHandler)
+	mux.HandleFunc("POST /api/mfa/totp/confirm", apiCfg.confirmTOTPHandler)
+	mux.HandleFunc("DELETE /api/mfa/totp", apiCfg.disableTOTPHandler)
+	mux.HandleFunc("POST /api/password-reset/request", apiCfg.requestPasswordResetHandler)
+	mux.HandleFunc("POST /api/password-reset", apiCfg.resetPasswordHandler)
+	mux.HandleFunc("POST /api/verify-email/request", apiCfg.requestEmailVerificationHandler)
+	mux.HandleFunc("POST /api/verify-email", apiCfg.verifyEmailHandler)
 	mux.HandleFunc("PUT /api/users", apiCfg.updateUserHandler)
+	mux.HandleFunc("PUT /api/users/profile", apiCfg.updateProfileHandler)
 
 	mux.HandleFunc("POST /api/refresh", apiCfg.refreshAccessTokenHandler)
 	mux.HandleFunc("POST /api/revoke", apiCfg.revokeRefreshTokenHandler)
+	mux.HandleFunc("GET /api/sessions", apiCfg.getSessionsHandler)
+	mux.HandleFunc("DELETE /api/sessions", apiCfg.revokeSessionsHandler)
+	mux.HandleFunc("DELETE /api/sessions/{sessionid}", apiCfg.revokeSessionHandler)
 
 	mux.HandleFunc("POST /api/polka/webhooks", apiCfg.upgradeUserHandler)
 
@@ -51,10 +96,31 @@ func runServer() error {
 		Handler: mux,
 	}
 
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	go func() {
+		<-ctx.Done()
+		server.Shutdown(context.Background())
+	}()
+
+	schedulerDone := make(chan struct{})
+	go apiCfg.runScheduler(ctx, apiCfg.schedulerInterval, schedulerDone)
+	pruningDone := make(chan struct{})
+	go apiCfg.runSessionPruning(ctx, pruningDone)
+	if apiCfg.jwtKeyRotation > 0 {
+		go apiCfg.runKeyRotation(ctx, apiCfg.jwtKeyRotation)
+	}
+
 	err = server.ListenAndServe()
 	log.Println("Starting server on port", port)
-	if err != nil {
+	if err != nil && !errors.Is(err, http.ErrServerClosed) {
 		return fmt.Errorf("error starting server: %s", err)
 	}
+
+	<-schedulerDone
+	<-pruningDone
+	if err = apiCfg.db.Close(); err != nil {
+		return fmt.Errorf("error closing database: %s", err)
+	}
 	return nil
 }
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/railanbaigazy/chirpy/internal/database"
+)
+
+// sessionPruneInterval is how often sessions whose refresh token has
+// expired are deleted.
+const sessionPruneInterval = time.Hour
+
+// clientIP returns the address the request came from. Proxy headers are
+// not trusted, since any client can set them.
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+func (cfg *apiConfig) getSessionsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	tokenStr, err := getTokenString(r)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, err.Error())
+		return
+	}
+
+	userID, err := getUserIDByToken(cfg, tokenStr)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, err.Error())
+		return
+	}
+
+	sessions, err := cfg.db.GetSessions(userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	respondWithJSON(w, http.StatusOK, sessions)
+}
+
+func (cfg *apiConfig) revokeSessionsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	tokenStr, err := getTokenString(r)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, err.Error())
+		return
+	}
+
+	userID, err := getUserIDByToken(cfg, tokenStr)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, err.Error())
+		return
+	}
+
+	if err = cfg.db.RevokeSessions(userID); err != nil {
+		respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (cfg *apiConfig) revokeSessionHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	tokenStr, err := getTokenString(r)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, err.Error())
+		return
+	}
+
+	userID, err := getUserIDByToken(cfg, tokenStr)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, err.Error())
+		return
+	}
+
+	sessionID, err := strconv.Atoi(r.PathValue("sessionid"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "invalid id")
+		return
+	}
+
+	err = cfg.db.RevokeSession(sessionID, userID)
+	if errors.Is(err, database.ErrSessionNotFound) {
+		respondWithError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// runSessionPruning deletes expired sessions every sessionPruneInterval
+// until ctx is done. It closes done once it has stopped.
+func (cfg *apiConfig) runSessionPruning(ctx context.Context, done chan<- struct{}) {
+	defer close(done)
+	ticker := time.NewTicker(sessionPruneInterval)
+	defer ticker.Stop()
+
+	for {
+		pruned, err := cfg.db.PruneExpiredSessions(time.Now())
+		if err != nil {
+			log.Printf("error pruning expired sessions: %v", err)
+		}
+		if pruned > 0 {
+			log.Printf("pruned %d expired sessions", pruned)
+		}
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
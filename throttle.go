@@ -0,0 +1,65 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	mailsPerIP         = 10
+	mailsPerIPAndEmail = 3
+	mailWindow         = time.Hour
+)
+
+// A throttle allows each key at most limit events per window, such as mails
+// sent to one address. Keys are forgotten once their window has passed.
+type throttle struct {
+	mux       *sync.Mutex
+	limit     int
+	window    time.Duration
+	events    map[string][]time.Time
+	lastSweep time.Time
+}
+
+func newThrottle(limit int, window time.Duration) *throttle {
+	return &throttle{
+		mux:    &sync.Mutex{},
+		limit:  limit,
+		window: window,
+		events: make(map[string][]time.Time),
+	}
+}
+
+// allow records an event for key at now and reports whether it is within
+// the limit. Refused events are not recorded.
+func (t *throttle) allow(key string, now time.Time) bool {
+	t.mux.Lock()
+	defer t.mux.Unlock()
+
+	if now.Sub(t.lastSweep) >= t.window {
+		for k := range t.events {
+			t.events[k] = t.recent(k, now)
+			if len(t.events[k]) == 0 {
+				delete(t.events, k)
+			}
+		}
+		t.lastSweep = now
+	}
+
+	events := t.recent(key, now)
+	if len(events) >= t.limit {
+		t.events[key] = events
+		return false
+	}
+	t.events[key] = append(events, now)
+	return true
+}
+
+// recent returns the events of key that are still inside the window.
+func (t *throttle) recent(key string, now time.Time) []time.Time {
+	events := t.events[key]
+	for len(events) > 0 && now.Sub(events[0]) >= t.window {
+		events = events[1:]
+	}
+	return events
+}
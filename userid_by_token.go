@@ -2,31 +2,53 @@ package main
 
 import (
 	"errors"
+	"net/http"
 	"strconv"
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
+	"github.com/railanbaigazy/chirpy/internal/database"
+	"github.com/railanbaigazy/chirpy/internal/jwtkeys"
 )
 
 func getUserIDByToken(cfg *apiConfig, tokenString string) (int, error) {
-	token, err := jwt.ParseWithClaims(tokenString, &jwt.RegisteredClaims{}, func(token *jwt.Token) (interface{}, error) {
-		// if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-		// 	return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
-		// }
-		return []byte(cfg.jwtSecret), nil
-	})
+	userID, _, err := getSessionByToken(cfg, tokenString)
+	return userID, err
+}
+
+// getSessionByToken returns the user an access token was issued to and the
+// session it was issued for. The session ID is zero for tokens signed
+// before access tokens named their session.
+func getSessionByToken(cfg *apiConfig, tokenString string) (userID int, sessionID int, err error) {
+	token, err := jwt.ParseWithClaims(tokenString, &database.AccessClaims{}, cfg.jwtKeys.Keyfunc,
+		jwt.WithValidMethods(jwtkeys.Algorithms),
+		jwt.WithExpirationRequired(),
+	)
 	if err != nil || !token.Valid {
-		return 0, errors.New("invalid token")
+		return 0, 0, errors.New("invalid token")
 	}
 
-	claims, ok := token.Claims.(*jwt.RegisteredClaims)
+	claims, ok := token.Claims.(*database.AccessClaims)
 	if !ok || claims.ExpiresAt == nil || claims.ExpiresAt.Before(time.Now().UTC()) {
-		return 0, errors.New("invalid token claims")
+		return 0, 0, errors.New("invalid token claims")
 	}
 
-	userID, err := strconv.Atoi(claims.Subject)
+	userID, err = strconv.Atoi(claims.Subject)
+	if err != nil {
+		return 0, 0, errors.New("invalid user ID")
+	}
+	return userID, claims.SessionID, nil
+}
+
+// getViewerID returns the ID of the user making the request, or zero when
+// no Authorization header is sent. A header with a bad token is an error.
+func getViewerID(cfg *apiConfig, r *http.Request) (int, error) {
+	if r.Header.Get("Authorization") == "" {
+		return 0, nil
+	}
+	tokenStr, err := getTokenString(r)
 	if err != nil {
-		return 0, errors.New("invalid user ID")
+		return 0, err
 	}
-	return userID, nil
+	return getUserIDByToken(cfg, tokenStr)
 }
@@ -2,16 +2,20 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
+	"log"
 	"net/http"
 	"os"
 	"regexp"
 
+	"github.com/railanbaigazy/chirpy/internal/database"
 	"golang.org/x/crypto/bcrypt"
 )
 
 type userRequest struct {
 	Email    string `json:"email"`
 	Password string `json:"password"`
+	Handle   string `json:"handle"`
 }
 
 func (cfg *apiConfig) createUserHandler(w http.ResponseWriter, r *http.Request) {
@@ -35,12 +39,20 @@ func (cfg *apiConfig) createUserHandler(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	user, err := cfg.db.CreateUser(userReq.Email, hashPassword)
+	user, err := cfg.db.CreateUser(userReq.Email, userReq.Handle, hashPassword)
+	if errors.Is(err, database.ErrEmailTaken) || errors.Is(err, database.ErrHandleTaken) {
+		respondWithError(w, http.StatusConflict, err.Error())
+		return
+	}
 	if err != nil {
 		respondWithError(w, http.StatusBadRequest, err.Error())
 		return
 	}
 
+	if err := cfg.sendEmailVerification(user.ID); err != nil {
+		log.Printf("failed to send email verification to user %d: %v", user.ID, err)
+	}
+
 	respondWithJSON(w, 201, user)
 }
 
@@ -48,11 +60,13 @@ func (cfg *apiConfig) updateUserHandler(w http.ResponseWriter, r *http.Request)
 	tokenStr, err := getTokenString(r)
 	if err != nil {
 		respondWithError(w, http.StatusUnauthorized, err.Error())
+		return
 	}
 
-	userID, err := getUserIDByToken(cfg, tokenStr)
+	userID, sessionID, err := getSessionByToken(cfg, tokenStr)
 	if err != nil {
 		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
 	}
 
 	userReq := userRequest{}
@@ -67,7 +81,11 @@ func (cfg *apiConfig) updateUserHandler(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	user, err := cfg.db.UpdateUser(userID, userReq.Email, hashPassword)
+	user, err := cfg.db.UpdateUser(userID, userReq.Email, hashPassword, sessionID)
+	if errors.Is(err, database.ErrEmailTaken) {
+		respondWithError(w, http.StatusConflict, err.Error())
+		return
+	}
 	if err != nil {
 		respondWithError(w, http.StatusUnauthorized, err.Error())
 		return